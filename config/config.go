@@ -10,23 +10,37 @@ type Configer interface {
 
 // Entry 配置入口
 type Entry struct {
-	ConfigPath   string            `json:"config_path"`   // 配置路径
-	ConfigFormat string            `json:"config_format"` // json/toml/yaml
-	EngineType   string            `json:"engine_type"`   // etcd/file
-	EndPoints    []string          `json:"endpoints"`
-	UserName     string            `json:"username"`
-	Password     string            `json:"password"`
-	Ext          map[string]string `json:"ext"` // 扩展配置
+	ConfigPath    string            `json:"config_path"`   // 配置路径
+	CommonPath    string            `json:"common_path"`   // 公共配置路径，多个服务共享，优先级低于ConfigPath
+	ConfigFormat  string            `json:"config_format"` // json/toml/yaml，为空则根据路径扩展名或内容自动识别
+	EngineType    string            `json:"engine_type"`   // etcd/file/consul/http
+	EndPoints     []string          `json:"endpoints"`
+	UserName      string            `json:"username"`
+	Password      string            `json:"password"`
+	SecretKeyEnv  string            `json:"secret_key_env"`  // 解密 ENC(...) 配置值的密钥所在的环境变量
+	SecretKeyFile string            `json:"secret_key_file"` // 解密 ENC(...) 配置值的密钥文件，环境变量优先
+	CAFile        string            `json:"ca_file"`         // 配置中心TLS的CA证书，与cert_file/key_file任一配置时启用TLS
+	CertFile      string            `json:"cert_file"`       // 客户端证书
+	KeyFile       string            `json:"key_file"`        // 客户端私钥
+	ServerName    string            `json:"server_name"`     // 校验服务端证书使用的主机名，为空则使用endpoint
+	Ext           map[string]string `json:"ext"`             // 扩展配置
+	Profile       string            `json:"profile"`         // 当前环境，如 dev/test/prod，选择Profiles中对应的配置
+	Profiles      map[string]Entry  `json:"profiles"`        // 各环境的配置入口，非空字段覆盖上面的配置
+}
+
+// TLSEnabled 是否使用TLS连接配置中心
+func (e *Entry) TLSEnabled() bool {
+	return e.CAFile != "" || e.CertFile != "" || e.KeyFile != ""
 }
 
 // AppConf 应用的具体配置
 type AppConf struct {
-	LogConf       LogConf            `json:"log_conf" toml:"log_conf" yaml:"log_conf"`
-	Redis         Redis              `json:"redis"`
-	MongoDB       MongoDB            `json:"mongodb"`
-	MongoDBSource map[string]MongoDB `json:"mongodb_source"`
-	//MysqlSource         map[string]MysqlDB     `json:"mysql_source"`
+	LogConf             LogConf                `json:"log_conf" toml:"log_conf" yaml:"log_conf"`
+	Redis               Redis                  `json:"redis"`
+	MongoDB             MongoDB                `json:"mongodb"`
+	MongoDBSource       map[string]MongoDB     `json:"mongodb_source"`
 	Mysql               Mysql                  `json:"mysql"`
+	MysqlSource         map[string]Mysql       `json:"mysql_source"`
 	RedisSource         map[string]Redis       `json:"redis_source"`
 	BrokerSource        map[string]Broker      `json:"broker_source"`
 	EBus                EBus                   `json:"ebus"`
@@ -36,6 +50,8 @@ type AppConf struct {
 	Broker              Broker                 `json:"broker"`
 	CurrentBusIdSpIdMap map[string]string      `json:"current_busid_spid_map,omitempty"`
 	GoMicro             GoMicro                `json:"go_micro"`
+	Components          map[string]interface{} `json:"components"` // 注册组件的配置，key为组件名
+	Health              Health                 `json:"health"`
 	UpdateTime          time.Time              `json:"-"`
 }
 
@@ -57,6 +73,7 @@ type MongoDB struct {
 	MaxPoolSize     uint16 `json:"max_pool_size"`
 	MaxConnIdleTime uint32 `json:"max_conn_idletime"` // 单位秒
 	Enable          bool   `json:"enable"`            // 启用组件
+	Required        bool   `json:"required"`          // 启动时等待连接成功，否则在后台重试连接，服务不等待
 }
 
 type Redis struct {
@@ -65,7 +82,8 @@ type Redis struct {
 	SentinelMastername string `json:"sentinel_mastername"`
 	Pwd                string `json:"pwd"`
 	PoolSize           int    `json:"poolsize"`
-	Enable             bool   `json:"enable"` // 启用组件
+	Enable             bool   `json:"enable"`   // 启用组件
+	Required           bool   `json:"required"` // 启动时等待连接成功，否则在后台重试连接，服务不等待
 }
 
 type Mysql struct {
@@ -78,7 +96,9 @@ type Mysql struct {
 	ConnMaxLifetime time.Duration `json:"conn_max_lifetime"`
 	MaxIdleConns    int           `json:"max_idle_conns"`
 	MaxOpenConns    int           `json:"max_oepn_conns"`
-	Enable          bool          `json:"enable"` // 启用组件
+	Replicas        string        `json:"replicas"` // 从库地址，多个用逗号分隔，与主库使用相同的账号和库名，配置后读操作分发到从库
+	Enable          bool          `json:"enable"`   // 启用组件
+	Required        bool          `json:"required"` // 启动时等待连接成功，否则在后台重试连接，服务不等待
 }
 
 type EBus struct {
@@ -121,6 +141,7 @@ type Broker struct {
 	SubscribeTopics []*TopicInfo `json:"subscribe_topics"` // 服务订阅的主题
 	EnablePub       bool         `json:"enable_pub"`       // 启用pub
 	EnableSub       bool         `json:"enable_sub"`       // 启用sub
+	Required        bool         `json:"required"`         // 启动时等待连接成功，否则在后台重试连接，服务不等待
 }
 
 type TopicInfo struct {
@@ -131,6 +152,14 @@ type TopicInfo struct {
 	Handler  string `json:"handler"`  // 处理器
 }
 
+// Health 健康检查配置
+type Health struct {
+	// Criticality 组件名 -> 是否关键组件，关键组件不可用时服务不就绪
+	// 未配置的组件为关键组件，config_watcher 默认非关键
+	Criticality map[string]bool `json:"criticality"`
+	Timeout     uint32          `json:"timeout"` // 单个检查的超时时间，单位毫秒，默认2000
+}
+
 type GoMicro struct {
 	ServiceName        string   `json:"service_name"`
 	ServerPort         uint32   `json:"server_port"`
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/elvisNg/broccoli/utils"
+)
+
+// Change 配置项的变化
+type Change struct {
+	Path string      `json:"path"` // json字段路径，如 redis.host
+	Old  interface{} `json:"old"`
+	New  interface{} `json:"new"`
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %v -> %v", c.Path, c.Old, c.New)
+}
+
+// Diff 比较两份配置，返回按路径排序的变化项
+// 数组作为整体比较，不展开
+func Diff(old, new *AppConf) []Change {
+	return diffMap(toMap(old), toMap(new))
+}
+
+// DiffMap 比较两份未解析为AppConf的配置，如 DecodeMap 的结果，不同格式的数值类型统一后比较
+func DiffMap(old, new map[string]interface{}) []Change {
+	return diffMap(jsonMap(old), jsonMap(new))
+}
+
+func diffMap(old, new map[string]interface{}) []Change {
+	om := flatten(old)
+	nm := flatten(new)
+	var changes []Change
+	for p, ov := range om {
+		nv, ok := nm[p]
+		if !ok {
+			changes = append(changes, Change{Path: p, Old: ov})
+			continue
+		}
+		if !reflect.DeepEqual(ov, nv) {
+			changes = append(changes, Change{Path: p, Old: ov, New: nv})
+		}
+	}
+	for p, nv := range nm {
+		if _, ok := om[p]; !ok {
+			changes = append(changes, Change{Path: p, New: nv})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func toMap(conf *AppConf) map[string]interface{} {
+	m := make(map[string]interface{})
+	if conf == nil {
+		return m
+	}
+	b, err := utils.Marshal(conf)
+	if err != nil {
+		return m
+	}
+	if err = utils.Unmarshal(b, &m); err != nil {
+		return make(map[string]interface{})
+	}
+	return m
+}
+
+// jsonMap 经过json编解码，统一yaml/toml解析出的map和数值类型
+func jsonMap(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	b, err := utils.Marshal(normalize(m))
+	if err != nil {
+		return out
+	}
+	if err = utils.Unmarshal(b, &out); err != nil {
+		return make(map[string]interface{})
+	}
+	return out
+}
+
+func flatten(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		if mm, ok := v.(map[string]interface{}); ok && len(mm) > 0 {
+			for k, vv := range mm {
+				walk(joinPath(prefix, k), vv)
+			}
+			return
+		}
+		out[prefix] = v
+	}
+	for k, v := range m {
+		walk(k, v)
+	}
+	return out
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return strings.Join([]string{prefix, key}, ".")
+}
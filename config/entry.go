@@ -0,0 +1,203 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/elvisNg/broccoli/utils"
+)
+
+const entryProfileKey = "profile"
+
+// LoadEntry 生成配置入口，优先级从低到高：配置入口文件、profile、环境变量、overrides
+// path 指向的文件不存在时忽略，完全使用环境变量和overrides
+// 环境变量为 BROCCOLI_ 加大写的json字段名，如 BROCCOLI_ENGINE_TYPE、BROCCOLI_CONFIG_PATH、BROCCOLI_ENDPOINTS（逗号分隔）
+// overrides 的key为json字段名，如 config_path，通常来自命令行参数
+func LoadEntry(path string, environ []string, overrides map[string]string) (*Entry, error) {
+	e := &Entry{}
+	if !utils.IsEmptyString(path) {
+		b, err := ioutil.ReadFile(path)
+		switch {
+		case os.IsNotExist(err):
+			log.Printf("[broccoli] [config.LoadEntry] 配置入口文件 %s 不存在，使用环境变量和命令行参数\n", path)
+		case err != nil:
+			return nil, fmt.Errorf("读取配置入口文件 %s 失败: %s", path, err)
+		default:
+			if err = utils.Unmarshal(b, e); err != nil {
+				return nil, fmt.Errorf("解析配置入口文件 %s 失败: %s", path, err)
+			}
+		}
+	}
+
+	env := entryEnv(environ)
+	profile := e.Profile
+	if v, ok := env[entryProfileKey]; ok {
+		profile = v
+	}
+	if v, ok := overrides[entryProfileKey]; ok {
+		profile = v
+	}
+	if err := e.applyProfile(profile); err != nil {
+		return nil, err
+	}
+	for _, src := range []map[string]string{env, overrides} {
+		for _, key := range sortedKeys(src) {
+			if key == entryProfileKey {
+				continue
+			}
+			if err := e.set(key, src[key]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := e.Check(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Check 检查配置入口的必填项
+func (e *Entry) Check() error {
+	var missing []string
+	if utils.IsEmptyString(e.EngineType) {
+		missing = append(missing, "engine_type (BROCCOLI_ENGINE_TYPE, -engineType)")
+	}
+	if utils.IsEmptyString(e.ConfigPath) {
+		missing = append(missing, "config_path (BROCCOLI_CONFIG_PATH, -configPath)")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("配置入口缺少必填项: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// String 用于日志输出，隐藏密码和 Ext 中的敏感字段
+func (e Entry) String() string {
+	if !utils.IsEmptyString(e.Password) {
+		e.Password = redacted
+	}
+	if len(e.Ext) > 0 {
+		ext := make(map[string]string, len(e.Ext))
+		for k, v := range e.Ext {
+			if IsSecretKey(k) {
+				v = redacted
+			}
+			ext[k] = v
+		}
+		e.Ext = ext
+	}
+	e.Profiles = nil
+	type entry Entry // 避免递归调用String
+	return fmt.Sprintf("%+v", entry(e))
+}
+
+// applyProfile 使用profile中非空的字段覆盖当前配置
+func (e *Entry) applyProfile(profile string) error {
+	if utils.IsEmptyString(profile) {
+		return nil
+	}
+	p, ok := e.Profiles[profile]
+	if !ok {
+		names := make([]string, 0, len(e.Profiles))
+		for name := range e.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("配置入口未定义profile %q，可选: %v", profile, names)
+	}
+	dst, src := reflect.ValueOf(e).Elem(), reflect.ValueOf(p)
+	for i := 0; i < src.NumField(); i++ {
+		f := src.Field(i)
+		switch f.Kind() {
+		case reflect.String:
+			if f.Len() > 0 {
+				dst.Field(i).Set(f)
+			}
+		case reflect.Slice:
+			if f.Len() > 0 {
+				dst.Field(i).Set(f)
+			}
+		case reflect.Map:
+			if f.Type() != reflect.TypeOf(e.Ext) {
+				continue
+			}
+			if e.Ext == nil && f.Len() > 0 {
+				e.Ext = make(map[string]string, f.Len())
+			}
+			for _, k := range f.MapKeys() {
+				e.Ext[k.String()] = f.MapIndex(k).String()
+			}
+		}
+	}
+	e.Profile = profile
+	log.Printf("[broccoli] [config.LoadEntry] 使用profile: %s\n", profile)
+	return nil
+}
+
+// set 按json字段名设置字符串和字符串数组字段
+func (e *Entry) set(key, value string) error {
+	v := reflect.ValueOf(e).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if strings.Split(t.Field(i).Tag.Get("json"), ",")[0] != key {
+			continue
+		}
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(value)
+		case reflect.Slice:
+			var items []string
+			for _, s := range strings.Split(value, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					items = append(items, s)
+				}
+			}
+			f.Set(reflect.ValueOf(items))
+		default:
+			return fmt.Errorf("配置入口字段 %s 不支持通过环境变量或命令行参数设置", key)
+		}
+		return nil
+	}
+	return fmt.Errorf("配置入口不存在字段 %s", key)
+}
+
+// entryEnvKeys 配置入口支持的环境变量，key为环境变量名，value为json字段名
+var entryEnvKeys = func() map[string]string {
+	keys := make(map[string]string)
+	t := reflect.TypeOf(Entry{})
+	for i := 0; i < t.NumField(); i++ {
+		k := t.Field(i).Type.Kind()
+		if k != reflect.String && k != reflect.Slice {
+			continue
+		}
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		keys[EnvPrefix+strings.ToUpper(name)] = name
+	}
+	return keys
+}()
+
+// isEntryEnv 判断环境变量是否属于配置入口，避免被当作配置覆盖项
+func isEntryEnv(key string) bool {
+	_, ok := entryEnvKeys[key]
+	return ok
+}
+
+func entryEnv(environ []string) map[string]string {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			continue
+		}
+		if name, ok := entryEnvKeys[kv[:i]]; ok {
+			env[name] = kv[i+1:]
+		}
+	}
+	return env
+}
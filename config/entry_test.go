@@ -0,0 +1,103 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadEntry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "broccoli.json")
+	content := `{
+		"engine_type": "etcd",
+		"config_path": "/broccoli/svc",
+		"endpoints": ["127.0.0.1:2379"],
+		"password": "pass",
+		"profiles": {
+			"prod": {"config_path": "/broccoli/prod/svc", "endpoints": ["etcd-prod:2379"]}
+		}
+	}`
+	if err = ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		environ   []string
+		overrides map[string]string
+		want      Entry
+		wantErr   string
+	}{
+		{
+			name: "file",
+			path: path,
+			want: Entry{EngineType: "etcd", ConfigPath: "/broccoli/svc", EndPoints: []string{"127.0.0.1:2379"}},
+		},
+		{
+			name:    "profile from env",
+			path:    path,
+			environ: []string{"BROCCOLI_PROFILE=prod"},
+			want:    Entry{EngineType: "etcd", ConfigPath: "/broccoli/prod/svc", EndPoints: []string{"etcd-prod:2379"}, Profile: "prod"},
+		},
+		{
+			name:      "env and flags override profile",
+			path:      path,
+			environ:   []string{"BROCCOLI_PROFILE=prod", "BROCCOLI_CONFIG_PATH=/env/svc"},
+			overrides: map[string]string{"endpoints": "a:2379, b:2379"},
+			want:      Entry{EngineType: "etcd", ConfigPath: "/env/svc", EndPoints: []string{"a:2379", "b:2379"}, Profile: "prod"},
+		},
+		{
+			name:    "no file",
+			path:    filepath.Join(dir, "missing.json"),
+			environ: []string{"BROCCOLI_ENGINE_TYPE=file", "BROCCOLI_CONFIG_PATH=./conf/app.yaml"},
+			want:    Entry{EngineType: "file", ConfigPath: "./conf/app.yaml"},
+		},
+		{
+			name:    "missing required",
+			path:    filepath.Join(dir, "missing.json"),
+			wantErr: "engine_type",
+		},
+		{
+			name:      "unknown profile",
+			path:      path,
+			overrides: map[string]string{"profile": "staging"},
+			wantErr:   "staging",
+		},
+	}
+	for _, tt := range tests {
+		e, err := LoadEntry(tt.path, tt.environ, tt.overrides)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		got := Entry{EngineType: e.EngineType, ConfigPath: e.ConfigPath, EndPoints: e.EndPoints, Profile: e.Profile}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: LoadEntry() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEntryString(t *testing.T) {
+	e := &Entry{ConfigPath: "/broccoli/svc", Password: "pass", Ext: map[string]string{"consul_token": "c0nsul", "http_token": "h77p"}}
+	if s := e.String(); strings.Contains(s, "pass") || strings.Contains(s, "c0nsul") || strings.Contains(s, "h77p") {
+		t.Errorf("String() = %s", s)
+	}
+	if e.Ext["http_token"] != "h77p" {
+		t.Error("String() modified Ext")
+	}
+}
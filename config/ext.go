@@ -0,0 +1,220 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/elvisNg/broccoli/utils"
+)
+
+// ExtValidatable 绑定的结构体实现该接口时，解码后进行校验，校验失败的配置会被拒绝
+type ExtValidatable interface {
+	Validate() error
+}
+
+// ExtValue 获取Ext中的值，key支持"."分隔的嵌套路径，为空时返回整个Ext
+func (c *AppConf) ExtValue(key string) (interface{}, bool) {
+	if c == nil || c.Ext == nil {
+		return nil, false
+	}
+	if key == "" {
+		return c.Ext, true
+	}
+	var cur interface{} = c.Ext
+	for _, seg := range strings.Split(key, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[seg]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// ExtString 获取Ext中的字符串，key不存在或为空时返回def
+func (c *AppConf) ExtString(key, def string) string {
+	v, ok := c.ExtValue(key)
+	if !ok || v == nil {
+		return def
+	}
+	s := fmt.Sprint(v)
+	if utils.IsEmptyString(s) {
+		return def
+	}
+	return s
+}
+
+// ExtInt 获取Ext中的整数，兼容数字和数字字符串，key不存在或无法转换时返回def
+func (c *AppConf) ExtInt(key string, def int) int {
+	v, ok := c.ExtValue(key)
+	if !ok {
+		return def
+	}
+	switch t := v.(type) {
+	case float64:
+		return int(t)
+	case int:
+		return t
+	case int64:
+		return int(t)
+	case string:
+		if i, err := strconv.Atoi(strings.TrimSpace(t)); err == nil {
+			return i
+		}
+	}
+	return def
+}
+
+// ExtBool 获取Ext中的布尔值，兼容"true"/"false"字符串，key不存在或无法转换时返回def
+func (c *AppConf) ExtBool(key string, def bool) bool {
+	v, ok := c.ExtValue(key)
+	if !ok {
+		return def
+	}
+	switch t := v.(type) {
+	case bool:
+		return t
+	case string:
+		if b, err := strconv.ParseBool(strings.TrimSpace(t)); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+// DecodeExt 将Ext[key]解码到out，out中已有的值作为默认值，key不存在时保持默认值
+// out 实现 ExtValidatable 时解码后进行校验
+func (c *AppConf) DecodeExt(key string, out interface{}) error {
+	if v, ok := c.ExtValue(key); ok && v != nil {
+		b, err := utils.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if err = utils.Unmarshal(b, out); err != nil {
+			return fmt.Errorf("decode ext %s failed: %s", key, err)
+		}
+	}
+	if v, ok := out.(ExtValidatable); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// ExtBinding Ext配置与业务结构体的绑定，每次配置生效后原子替换为新的快照
+type ExtBinding struct {
+	id       int64
+	key      string
+	typ      reflect.Type
+	defaults []byte
+	value    atomic.Value
+	onChange func(old, new interface{})
+}
+
+var (
+	extBindingsMu sync.RWMutex
+	extBindings   = make(map[int64]*ExtBinding)
+	extBindingID  int64
+)
+
+// BindExt 绑定Ext[key]到与defaults相同类型的结构体，key为空时绑定整个Ext
+// defaults 为结构体指针，其中的值作为默认值；解码或校验失败时新配置会被拒绝
+// onChange 在配置生效且绑定的值发生变化时同步回调，old/new 与defaults类型相同，可为nil
+func BindExt(key string, defaults interface{}, onChange func(old, new interface{})) (*ExtBinding, error) {
+	t := reflect.TypeOf(defaults)
+	if t == nil || t.Kind() != reflect.Ptr || reflect.ValueOf(defaults).IsNil() {
+		return nil, errors.New("BindExt defaults must be a non-nil pointer")
+	}
+	d, err := utils.Marshal(defaults)
+	if err != nil {
+		return nil, err
+	}
+	b := &ExtBinding{
+		id:       atomic.AddInt64(&extBindingID, 1),
+		key:      key,
+		typ:      t.Elem(),
+		defaults: d,
+		onChange: onChange,
+	}
+	v, err := b.decode(nil)
+	if err != nil {
+		return nil, err
+	}
+	b.value.Store(v)
+
+	extBindingsMu.Lock()
+	extBindings[b.id] = b
+	extBindingsMu.Unlock()
+	RegisterValidator(b.validatorName(), func(conf *AppConf) error {
+		_, err := b.decode(conf)
+		return err
+	})
+	return b, nil
+}
+
+// Get 当前的配置快照，与defaults类型相同的指针，只读，不要修改
+func (b *ExtBinding) Get() interface{} {
+	return b.value.Load()
+}
+
+// Unbind 解除绑定，之后不再更新快照
+func (b *ExtBinding) Unbind() {
+	extBindingsMu.Lock()
+	delete(extBindings, b.id)
+	extBindingsMu.Unlock()
+	RegisterValidator(b.validatorName(), nil)
+}
+
+func (b *ExtBinding) validatorName() string {
+	return fmt.Sprintf("ext.%s(binding %d)", b.key, b.id)
+}
+
+// decode 以默认值为基础解码出新的快照
+func (b *ExtBinding) decode(conf *AppConf) (interface{}, error) {
+	v := reflect.New(b.typ).Interface()
+	if err := utils.Unmarshal(b.defaults, v); err != nil {
+		return nil, err
+	}
+	if err := conf.DecodeExt(b.key, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (b *ExtBinding) apply(conf *AppConf) {
+	v, err := b.decode(conf)
+	if err != nil {
+		log.Printf("[broccoli] [config.ExtBinding] ext %s 解码失败，保留原来的值，err: %s\n", b.key, err)
+		return
+	}
+	old := b.value.Load()
+	if reflect.DeepEqual(old, v) {
+		return
+	}
+	b.value.Store(v)
+	if b.onChange != nil {
+		b.onChange(old, v)
+	}
+}
+
+// ApplyExtBindings 使用已生效的配置更新所有绑定，由service在配置加载和变更后调用
+func ApplyExtBindings(conf *AppConf) {
+	extBindingsMu.RLock()
+	bindings := make([]*ExtBinding, 0, len(extBindings))
+	for _, b := range extBindings {
+		bindings = append(bindings, b)
+	}
+	extBindingsMu.RUnlock()
+	sort.Slice(bindings, func(i, j int) bool { return bindings[i].id < bindings[j].id })
+	for _, b := range bindings {
+		b.apply(conf)
+	}
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type featureConf struct {
+	Enable  bool     `json:"enable"`
+	Limit   int      `json:"limit"`
+	Targets []string `json:"targets"`
+}
+
+func (f *featureConf) Validate() error {
+	if f.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+	return nil
+}
+
+func TestExtAccessors(t *testing.T) {
+	conf := &AppConf{Ext: map[string]interface{}{
+		"prefix":  "/v1/",
+		"port":    "8080",
+		"retries": float64(3),
+		"debug":   "true",
+		"nested":  map[string]interface{}{"name": "x"},
+	}}
+	if got := conf.ExtString("prefix", "/api/"); got != "/v1/" {
+		t.Errorf("ExtString() = %q", got)
+	}
+	if got := conf.ExtString("missing", "/api/"); got != "/api/" {
+		t.Errorf("ExtString(missing) = %q", got)
+	}
+	if got := conf.ExtString("nested.name", ""); got != "x" {
+		t.Errorf("ExtString(nested.name) = %q", got)
+	}
+	if conf.ExtInt("port", 0) != 8080 || conf.ExtInt("retries", 0) != 3 || conf.ExtInt("prefix", 7) != 7 {
+		t.Errorf("ExtInt() mismatch")
+	}
+	if !conf.ExtBool("debug", false) || !conf.ExtBool("missing", true) {
+		t.Errorf("ExtBool() mismatch")
+	}
+	var nilConf *AppConf
+	if got := nilConf.ExtString("prefix", "/api/"); got != "/api/" {
+		t.Errorf("nil ExtString() = %q", got)
+	}
+}
+
+func TestBindExt(t *testing.T) {
+	var changes int
+	b, err := BindExt("feature", &featureConf{Limit: 10}, func(old, new interface{}) { changes++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Unbind()
+	if f := b.Get().(*featureConf); f.Limit != 10 || f.Enable {
+		t.Fatalf("initial = %+v", f)
+	}
+
+	conf := &AppConf{Ext: map[string]interface{}{"feature": map[string]interface{}{"enable": true}}}
+	ApplyExtBindings(conf)
+	if f := b.Get().(*featureConf); f.Limit != 10 || !f.Enable {
+		t.Errorf("after apply = %+v, want default limit kept", f)
+	}
+	ApplyExtBindings(conf)
+	if changes != 1 {
+		t.Errorf("changes = %d, want 1", changes)
+	}
+
+	bad := &AppConf{Ext: map[string]interface{}{"feature": map[string]interface{}{"limit": -1}}}
+	if err := Validate(bad); err == nil || !strings.Contains(err.Error(), "limit must not be negative") {
+		t.Errorf("Validate() = %v, want binding error", err)
+	}
+	b.Unbind()
+	ApplyExtBindings(bad)
+	if f := b.Get().(*featureConf); f.Limit != 10 {
+		t.Errorf("unbound binding updated: %+v", f)
+	}
+}
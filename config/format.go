@@ -0,0 +1,178 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+
+	"github.com/elvisNg/broccoli/utils"
+)
+
+// 支持的配置格式
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+var (
+	tomlTableRe = regexp.MustCompile(`^\[\[?[A-Za-z0-9_.\-" ]+\]\]?$`)
+	tomlKeyRe   = regexp.MustCompile(`^[A-Za-z0-9_.\-"]+\s*=`)
+)
+
+// NewConfiger 根据配置格式创建配置器
+func NewConfiger(format string) (Configer, error) {
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		return &Jsoner{}, nil
+	case FormatYAML, "yml":
+		return &Yamler{}, nil
+	case FormatTOML:
+		return &Tomler{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported config format: %s", format)
+	}
+}
+
+// DetectFormat 推断配置格式
+// 优先根据路径的扩展名判断，无法判断时根据内容判断，默认为yaml
+func DetectFormat(path string, content []byte) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	}
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) == 0 || trimmed[0] == '{' {
+		return FormatJSON
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if tomlTableRe.MatchString(line) || tomlKeyRe.MatchString(line) {
+			return FormatTOML
+		}
+		// 第一行有效内容即可确定格式
+		break
+	}
+	return FormatYAML
+}
+
+// Load 加载原始配置内容
+// entry.ConfigFormat 为空时自动推断配置格式，解析后应用全局覆盖层并校验
+// 校验失败时记录与prev的差异，返回 *ValidationError，调用方应保留prev继续使用
+func Load(entry *Entry, content []byte, prev Configer) (configer Configer, err error) {
+	format := entry.ConfigFormat
+	if utils.IsEmptyString(format) {
+		format = DetectFormat(entry.ConfigPath, content)
+	}
+	if configer, err = NewConfiger(format); err != nil {
+		return nil, err
+	}
+	if err = configer.Init(content); err != nil {
+		return nil, fmt.Errorf("%s 加载配置失败: %s", format, err)
+	}
+	// 应用环境变量、命令行参数等覆盖项
+	if err = GetOverlay().Apply(configer.Get()); err != nil {
+		return nil, err
+	}
+	if err = Validate(configer.Get()); err != nil {
+		atomic.AddUint64(&rejectedCounter, 1)
+		log.Printf("[broccoli] [config.Load] 配置被拒绝，configpath: %s，err: %s\n", entry.ConfigPath, err)
+		if prev != nil && prev.Get() != nil {
+			for _, c := range Diff(prev.Get(), configer.Get()) {
+				log.Printf("[broccoli] [config.Load] rejected change %s\n", RedactChange(c))
+			}
+		}
+		return nil, err
+	}
+	return configer, nil
+}
+
+// DecodeMap 按格式将配置内容解析为通用结构
+func DecodeMap(format string, content []byte) (map[string]interface{}, error) {
+	var raw map[string]interface{}
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		if err := utils.Unmarshal(content, &raw); err != nil {
+			return nil, err
+		}
+	case FormatYAML, "yml":
+		if err := yaml.Unmarshal(content, &raw); err != nil {
+			return nil, err
+		}
+	case FormatTOML:
+		if _, err := toml.Decode(string(content), &raw); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config format: %s", format)
+	}
+	m, _ := normalize(raw).(map[string]interface{})
+	return m, nil
+}
+
+// decodeMap 将yaml/toml解析出的通用结构转换为AppConf
+// 字段名与json标签保持一致，保证不同格式的配置键名相同
+func decodeMap(raw map[string]interface{}) (conf *AppConf, err error) {
+	if raw == nil {
+		return nil, errors.New("配置内容为空")
+	}
+	b, err := utils.Marshal(normalize(raw))
+	if err != nil {
+		return
+	}
+	var c AppConf
+	if err = utils.Unmarshal(b, &c); err != nil {
+		return
+	}
+	conf = &c
+	return
+}
+
+// normalize yaml.v2 解析的嵌套map为map[interface{}]interface{}，需转换后才能进行json编码
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, vv := range t {
+			m[fmt.Sprint(k)] = normalize(vv)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, vv := range t {
+			m[k] = normalize(vv)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(t))
+		for i, vv := range t {
+			s[i] = normalize(vv)
+		}
+		return s
+	case []map[string]interface{}:
+		s := make([]interface{}, len(t))
+		for i, vv := range t {
+			s[i] = normalize(vv)
+		}
+		return s
+	default:
+		return v
+	}
+}
@@ -0,0 +1,79 @@
+package config
+
+import "testing"
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		content string
+		want    string
+	}{
+		{"json_ext", "./conf/app.json", "", FormatJSON},
+		{"yaml_ext", "./conf/app.yaml", "", FormatYAML},
+		{"yml_ext", "./conf/app.yml", "", FormatYAML},
+		{"toml_ext", "./conf/app.toml", "", FormatTOML},
+		{"json_content", "/broccoli/svc", `{"redis":{"host":"127.0.0.1:6379"}}`, FormatJSON},
+		{"toml_table", "/broccoli/svc", "# comment\n[redis]\nhost = \"127.0.0.1:6379\"", FormatTOML},
+		{"toml_key", "/broccoli/svc", "ext = {}\n", FormatTOML},
+		{"yaml_content", "/broccoli/svc", "redis:\n  host: 127.0.0.1:6379\n", FormatYAML},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectFormat(tt.path, []byte(tt.content)); got != tt.want {
+				t.Errorf("DetectFormat() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   *Entry
+		content string
+	}{
+		{
+			"json",
+			&Entry{ConfigPath: "/broccoli/svc"},
+			`{"log_conf":{"level":"debug"},"redis":{"host":"127.0.0.1:6379","poolsize":10},"mysql":{"max_oepn_conns":20},"ext":{"feature":"on"}}`,
+		},
+		{
+			"yaml",
+			&Entry{ConfigPath: "./conf/app.yml"},
+			"log_conf:\n  level: debug\nredis:\n  host: 127.0.0.1:6379\n  poolsize: 10\nmysql:\n  max_oepn_conns: 20\next:\n  feature: \"on\"\n",
+		},
+		{
+			"toml",
+			&Entry{ConfigPath: "/broccoli/svc", ConfigFormat: FormatTOML},
+			"[log_conf]\nlevel = \"debug\"\n[redis]\nhost = \"127.0.0.1:6379\"\npoolsize = 10\n[mysql]\nmax_oepn_conns = 20\n[ext]\nfeature = \"on\"\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configer, err := Load(tt.entry, []byte(tt.content), nil)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			conf := configer.Get()
+			if conf.LogConf.Level != "debug" {
+				t.Errorf("LogConf.Level = %v, want debug", conf.LogConf.Level)
+			}
+			if conf.Redis.Host != "127.0.0.1:6379" || conf.Redis.PoolSize != 10 {
+				t.Errorf("Redis = %+v", conf.Redis)
+			}
+			if conf.Mysql.MaxOpenConns != 20 {
+				t.Errorf("Mysql.MaxOpenConns = %v, want 20", conf.Mysql.MaxOpenConns)
+			}
+			if conf.Ext["feature"] != "on" {
+				t.Errorf("Ext[feature] = %v, want on", conf.Ext["feature"])
+			}
+		})
+	}
+}
+
+func TestLoadUnsupportedFormat(t *testing.T) {
+	if _, err := Load(&Entry{ConfigFormat: "ini"}, []byte("a=b"), nil); err == nil {
+		t.Error("Load() expected error for unsupported format")
+	}
+}
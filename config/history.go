@@ -0,0 +1,111 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultHistorySize 默认保留的配置版本数
+const DefaultHistorySize = 20
+
+// Version 一次已生效的配置
+type Version struct {
+	Version  int       `json:"version"`  // 版本号，进程内递增
+	Revision int64     `json:"revision"` // 配置中心的版本，etcd为revision，consul为ModifyIndex，文件引擎为0
+	Time     time.Time `json:"time"`
+	Hash     string    `json:"hash"` // 原始内容的sha256
+	Format   string    `json:"format"`
+	Pinned   bool      `json:"pinned"` // 是否由回滚产生
+
+	Content  []byte            `json:"-"` // 原始内容
+	Snapshot map[string][]byte `json:"-"` // 多key配置树的快照，用于回滚
+	conf     *AppConf
+}
+
+// Conf 该版本解析后的配置
+func (v *Version) Conf() *AppConf {
+	return v.conf
+}
+
+// History 有界的配置历史，超出容量时丢弃最旧的版本
+type History struct {
+	mu       sync.RWMutex
+	size     int
+	next     int
+	versions []*Version
+}
+
+func NewHistory(size int) *History {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	return &History{size: size, next: 1}
+}
+
+// Add 记录一个已生效的配置，返回分配了版本号的记录
+func (h *History) Add(v Version, conf *AppConf) *Version {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	v.Version = h.next
+	h.next++
+	if v.Time.IsZero() {
+		v.Time = time.Now()
+	}
+	sum := sha256.Sum256(v.Content)
+	v.Hash = hex.EncodeToString(sum[:])
+	v.conf = conf
+	h.versions = append(h.versions, &v)
+	if len(h.versions) > h.size {
+		h.versions = h.versions[len(h.versions)-h.size:]
+	}
+	return &v
+}
+
+// List 按版本号从旧到新返回所有版本
+func (h *History) List() []Version {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	list := make([]Version, 0, len(h.versions))
+	for _, v := range h.versions {
+		list = append(list, *v)
+	}
+	return list
+}
+
+// Get 获取指定版本
+func (h *History) Get(version int) (*Version, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, v := range h.versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("config version %d not found", version)
+}
+
+// Latest 最新的版本，没有记录时返回nil
+func (h *History) Latest() *Version {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.versions) == 0 {
+		return nil
+	}
+	return h.versions[len(h.versions)-1]
+}
+
+// Diff 比较两个版本的配置
+func (h *History) Diff(from, to int) ([]Change, error) {
+	a, err := h.Get(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := h.Get(to)
+	if err != nil {
+		return nil, err
+	}
+	return Diff(a.conf, b.conf), nil
+}
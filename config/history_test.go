@@ -0,0 +1,30 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	h := NewHistory(2)
+	h.Add(Version{Content: []byte("a")}, &AppConf{Redis: Redis{Host: "a"}})
+	h.Add(Version{Content: []byte("b")}, &AppConf{Redis: Redis{Host: "b"}})
+	h.Add(Version{Content: []byte("c")}, &AppConf{Redis: Redis{Host: "c"}})
+
+	list := h.List()
+	if len(list) != 2 || list[0].Version != 2 || list[1].Version != 3 {
+		t.Fatalf("List() = %+v, want versions 2, 3", list)
+	}
+	if _, err := h.Get(1); err == nil {
+		t.Errorf("Get(1) should fail after eviction")
+	}
+	if h.Latest().Conf().Redis.Host != "c" {
+		t.Errorf("Latest() = %+v", h.Latest())
+	}
+	changes, err := h.Diff(2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Path != "redis.host" || changes[0].Old != "b" || changes[0].New != "c" {
+		t.Errorf("Diff(2, 3) = %v", changes)
+	}
+}
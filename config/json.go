@@ -25,6 +25,10 @@ func (j *Jsoner) Init(original []byte) (err error) {
 		log.Println(err)
 		return
 	}
+	if err = DecryptSecrets(&conf); err != nil {
+		log.Println(err)
+		return
+	}
 	j.original = original
 	j.conf = &conf
 	j.conf.UpdateTime = time.Now()
@@ -0,0 +1,432 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// EnvPrefix 覆盖配置的环境变量前缀
+const EnvPrefix = "BROCCOLI_"
+
+// 覆盖来源
+const (
+	SourceEnv  = "env"
+	SourceFlag = "flag"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// Overlay 配置覆盖层，按路径覆盖engine加载的配置
+// 优先级：engine配置 < 环境变量 < 命令行参数，每次热更新都会重新应用
+type Overlay struct {
+	rw     sync.RWMutex
+	items  []overlayItem
+	logged int // 已输出日志的覆盖项数量，覆盖项只会追加
+}
+
+type overlayItem struct {
+	source string   // 来源 env/flag
+	key    string   // 原始的键，如 BROCCOLI_REDIS_HOST、redis.host
+	path   []string // 解析后的json字段路径
+	value  string
+}
+
+// NewOverlay 创建覆盖层
+func NewOverlay() *Overlay {
+	return &Overlay{}
+}
+
+// LoadEnv 加载以 EnvPrefix 开头的环境变量
+// 键名使用"_"连接，层级不明确时可使用"__"显式分隔，如 BROCCOLI_REDIS_HOST、BROCCOLI_MYSQL__MAX_OPEN_CONNS
+// 无法匹配AppConf字段的环境变量会被忽略
+func (o *Overlay) LoadEnv(environ []string) {
+	var items []overlayItem
+	for _, kv := range environ {
+		i := strings.Index(kv, "=")
+		if i <= 0 || !strings.HasPrefix(kv[:i], EnvPrefix) {
+			continue
+		}
+		key, value := kv[:i], kv[i+1:]
+		if isEntryEnv(key) {
+			continue
+		}
+		path, err := resolvePath(strings.TrimPrefix(key, EnvPrefix))
+		if err != nil {
+			continue
+		}
+		items = append(items, overlayItem{source: SourceEnv, key: key, path: path, value: value})
+	}
+	// 保证应用顺序稳定
+	sort.Slice(items, func(i, j int) bool { return items[i].key < items[j].key })
+	o.rw.Lock()
+	o.items = append(o.items, items...)
+	o.rw.Unlock()
+}
+
+// Set 按路径覆盖配置，路径使用"."分隔，如 redis.host、mysql.max_open_conns、ext.feature_x
+func (o *Overlay) Set(source, key, value string) error {
+	name := strings.ToUpper(strings.Join(strings.Split(strings.Trim(key, "."), "."), "__"))
+	path, err := resolvePath(name)
+	if err != nil {
+		return fmt.Errorf("invalid config path %q: %s", key, err)
+	}
+	o.rw.Lock()
+	o.items = append(o.items, overlayItem{source: source, key: key, path: path, value: value})
+	o.rw.Unlock()
+	return nil
+}
+
+// SetPair 解析 path=value 格式的覆盖项
+func (o *Overlay) SetPair(source, pair string) error {
+	i := strings.Index(pair, "=")
+	if i <= 0 {
+		return fmt.Errorf("invalid config override %q, want path=value", pair)
+	}
+	return o.Set(source, pair[:i], pair[i+1:])
+}
+
+// Len 覆盖项数量
+func (o *Overlay) Len() int {
+	o.rw.RLock()
+	defer o.rw.RUnlock()
+	return len(o.items)
+}
+
+// Apply 将覆盖项应用到conf，每个覆盖项只在首次应用时输出日志，避免热更新时重复输出
+func (o *Overlay) Apply(conf *AppConf) error {
+	if conf == nil {
+		return nil
+	}
+	o.rw.Lock()
+	defer o.rw.Unlock()
+	v := reflect.ValueOf(conf).Elem()
+	for i, it := range o.items {
+		if err := setPath(v, it.path, it.value); err != nil {
+			return fmt.Errorf("apply %s override %s failed: %s", it.source, it.key, err)
+		}
+		if i >= o.logged {
+			log.Printf("[broccoli] [config.Overlay] %s overridden by %s %s\n", strings.Join(it.path, "."), it.source, it.key)
+		}
+	}
+	o.logged = len(o.items)
+	return nil
+}
+
+var (
+	overlayMu     sync.RWMutex
+	globalOverlay = NewOverlay()
+)
+
+// SetOverlay 设置全局覆盖层，所有engine加载配置时都会应用
+func SetOverlay(o *Overlay) {
+	if o == nil {
+		o = NewOverlay()
+	}
+	overlayMu.Lock()
+	globalOverlay = o
+	overlayMu.Unlock()
+}
+
+// GetOverlay 获取全局覆盖层
+func GetOverlay() *Overlay {
+	overlayMu.RLock()
+	defer overlayMu.RUnlock()
+	return globalOverlay
+}
+
+// resolvePath 将大写的键名解析为AppConf的json字段路径
+func resolvePath(name string) ([]string, error) {
+	var groups [][]string
+	for _, g := range strings.Split(name, "__") {
+		tokens := splitTokens(g)
+		if len(tokens) == 0 {
+			return nil, fmt.Errorf("empty segment in %q", name)
+		}
+		groups = append(groups, tokens)
+	}
+	path, ok := resolve(reflect.TypeOf(AppConf{}), groups)
+	if !ok {
+		return nil, fmt.Errorf("no field matches %q", name)
+	}
+	return path, nil
+}
+
+func splitTokens(s string) []string {
+	var tokens []string
+	for _, t := range strings.Split(strings.ToUpper(s), "_") {
+		if t != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+type fieldCandidate struct {
+	name   string   // json字段名
+	tokens []string // 用于匹配的分词
+	typ    reflect.Type
+}
+
+// resolve 深度优先匹配，groups[0] 为当前层级待匹配的分词
+func resolve(t reflect.Type, groups [][]string) ([]string, bool) {
+	if len(groups) == 0 {
+		return nil, true
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return resolve(t.Elem(), groups)
+	case reflect.Struct:
+		if t == timeType {
+			return nil, false
+		}
+		cur := groups[0]
+		for _, c := range structCandidates(t) {
+			if !hasTokenPrefix(cur, c.tokens) {
+				continue
+			}
+			if p, ok := resolve(c.typ, nextGroups(groups, len(c.tokens))); ok {
+				return append([]string{c.name}, p...), true
+			}
+		}
+		return nil, false
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, false
+		}
+		cur := groups[0]
+		// 优先匹配最长的key
+		for i := len(cur); i >= 1; i-- {
+			key := strings.ToLower(strings.Join(cur[:i], "_"))
+			if p, ok := resolve(t.Elem(), nextGroups(groups, i)); ok {
+				return append([]string{key}, p...), true
+			}
+		}
+		return nil, false
+	case reflect.Interface:
+		// 任意结构，剩余的每组作为一级key
+		var p []string
+		for _, g := range groups {
+			p = append(p, strings.ToLower(strings.Join(g, "_")))
+		}
+		return p, true
+	default:
+		return nil, false
+	}
+}
+
+func nextGroups(groups [][]string, consumed int) [][]string {
+	rest := groups[0][consumed:]
+	if len(rest) == 0 {
+		return groups[1:]
+	}
+	next := make([][]string, 0, len(groups))
+	next = append(next, rest)
+	return append(next, groups[1:]...)
+}
+
+func hasTokenPrefix(tokens, prefix []string) bool {
+	if len(prefix) == 0 || len(prefix) > len(tokens) {
+		return false
+	}
+	for i := range prefix {
+		if tokens[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// structCandidates 字段可通过json标签或字段名的蛇形命名匹配，较长的优先
+func structCandidates(t reflect.Type) []fieldCandidate {
+	var cs []fieldCandidate
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, ok := jsonFieldName(f)
+		if !ok {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, n := range []string{name, snakeCase(f.Name)} {
+			tokens := splitTokens(n)
+			k := strings.Join(tokens, "_")
+			if len(tokens) == 0 || seen[k] {
+				continue
+			}
+			seen[k] = true
+			cs = append(cs, fieldCandidate{name: name, tokens: tokens, typ: f.Type})
+		}
+	}
+	sort.SliceStable(cs, func(i, j int) bool { return len(cs[i].tokens) > len(cs[j].tokens) })
+	return cs
+}
+
+func jsonFieldName(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" {
+		return "", false
+	}
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	if i := strings.Index(tag, ","); i >= 0 {
+		tag = tag[:i]
+	}
+	if tag == "" {
+		tag = f.Name
+	}
+	return tag, true
+}
+
+// snakeCase MaxOpenConns -> max_open_conns, MongoDBSource -> mongo_db_source
+func snakeCase(s string) string {
+	rs := []rune(s)
+	var b strings.Builder
+	for i, r := range rs {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(rs[i-1]) || (i+1 < len(rs) && unicode.IsLower(rs[i+1]) && unicode.IsUpper(rs[i-1]))) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// setPath 按json字段路径设置值，map会复制后再修改，避免影响共享的数据
+func setPath(v reflect.Value, path []string, raw string) error {
+	if len(path) == 0 {
+		return setValue(v, raw)
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setPath(v.Elem(), path, raw)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if name, ok := jsonFieldName(t.Field(i)); ok && name == path[0] {
+				return setPath(v.Field(i), path[1:], raw)
+			}
+		}
+		return fmt.Errorf("unknown field %s", path[0])
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		if !v.IsNil() {
+			for _, k := range v.MapKeys() {
+				m.SetMapIndex(k, v.MapIndex(k))
+			}
+		}
+		key := reflect.ValueOf(path[0]).Convert(v.Type().Key())
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if old := m.MapIndex(key); old.IsValid() {
+			elem.Set(old)
+		}
+		if err := setPath(elem, path[1:], raw); err != nil {
+			return err
+		}
+		m.SetMapIndex(key, elem)
+		v.Set(m)
+		return nil
+	case reflect.Interface:
+		// 嵌套的任意结构按map[string]interface{}处理
+		nested := map[string]interface{}{}
+		if old, ok := v.Interface().(map[string]interface{}); ok && old != nil {
+			nested = old
+		}
+		mv := reflect.ValueOf(&nested).Elem()
+		if err := setPath(mv, path, raw); err != nil {
+			return err
+		}
+		v.Set(mv)
+		return nil
+	default:
+		return fmt.Errorf("can not set field %s on %s", path[0], v.Type())
+	}
+}
+
+func setValue(v reflect.Value, raw string) error {
+	if v.Type() == durationType {
+		if d, err := time.ParseDuration(raw); err == nil {
+			v.SetInt(int64(d))
+			return nil
+		}
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(raw), "[") {
+			var ss []string
+			for _, s := range strings.Split(raw, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					ss = append(ss, s)
+				}
+			}
+			v.Set(reflect.ValueOf(ss).Convert(v.Type()))
+			return nil
+		}
+		return decodeJSONValue(v, raw)
+	case reflect.Interface:
+		var val interface{}
+		if err := json.Unmarshal([]byte(raw), &val); err != nil {
+			// 非json值按字符串处理
+			val = raw
+		}
+		if val == nil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		v.Set(reflect.ValueOf(val))
+	default:
+		return decodeJSONValue(v, raw)
+	}
+	return nil
+}
+
+func decodeJSONValue(v reflect.Value, raw string) error {
+	ptr := reflect.New(v.Type())
+	if err := json.Unmarshal([]byte(raw), ptr.Interface()); err != nil {
+		return err
+	}
+	v.Set(ptr.Elem())
+	return nil
+}
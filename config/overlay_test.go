@@ -0,0 +1,124 @@
+package config
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolvePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    []string
+		wantErr bool
+	}{
+		{"REDIS_HOST", []string{"redis", "host"}, false},
+		{"REDIS__POOLSIZE", []string{"redis", "poolsize"}, false},
+		{"MYSQL__MAX_OPEN_CONNS", []string{"mysql", "max_oepn_conns"}, false},
+		{"MYSQL_MAX_OPEN_CONNS", []string{"mysql", "max_oepn_conns"}, false},
+		{"LOG_CONF_LEVEL", []string{"log_conf", "level"}, false},
+		{"REDIS_SOURCE__CACHE__HOST", []string{"redis_source", "cache", "host"}, false},
+		{"EXT__FEATURE_X", []string{"ext", "feature_x"}, false},
+		{"EXT__A__B", []string{"ext", "a", "b"}, false},
+		{"GO_MICRO_SERVER_PORT", []string{"go_micro", "server_port"}, false},
+		{"ENGINE_TYPE", nil, true},
+		{"REDIS_HOST_PORT", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolvePath(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolvePath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolvePath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOverlayApply(t *testing.T) {
+	o := NewOverlay()
+	o.LoadEnv([]string{
+		"PATH=/usr/bin",
+		"BROCCOLI_ENGINE_TYPE=etcd",
+		"BROCCOLI_REDIS_HOST=10.0.0.1:6379",
+		"BROCCOLI_MYSQL__MAX_OPEN_CONNS=50",
+		"BROCCOLI_MYSQL__CONN_MAX_LIFETIME=2m",
+		"BROCCOLI_BROKER_HOSTS=a:9092,b:9092",
+		"BROCCOLI_EXT__FEATURE_X=true",
+	})
+	if err := o.SetPair(SourceFlag, "redis.host=10.0.0.2:6379"); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Set(SourceFlag, "redis_source.cache.enable", "true"); err != nil {
+		t.Fatal(err)
+	}
+
+	ext := map[string]interface{}{"keep": "me"}
+	conf := &AppConf{
+		Redis: Redis{Host: "127.0.0.1:6379", PoolSize: 10},
+		Ext:   ext,
+	}
+	if err := o.Apply(conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Redis.Host != "10.0.0.2:6379" {
+		t.Errorf("Redis.Host = %v, flag should win over env", conf.Redis.Host)
+	}
+	if conf.Redis.PoolSize != 10 {
+		t.Errorf("Redis.PoolSize = %v, want 10", conf.Redis.PoolSize)
+	}
+	if conf.Mysql.MaxOpenConns != 50 {
+		t.Errorf("Mysql.MaxOpenConns = %v, want 50", conf.Mysql.MaxOpenConns)
+	}
+	if conf.Mysql.ConnMaxLifetime != 2*time.Minute {
+		t.Errorf("Mysql.ConnMaxLifetime = %v, want 2m", conf.Mysql.ConnMaxLifetime)
+	}
+	if !reflect.DeepEqual(conf.Broker.Hosts, []string{"a:9092", "b:9092"}) {
+		t.Errorf("Broker.Hosts = %v", conf.Broker.Hosts)
+	}
+	if conf.Ext["feature_x"] != true || conf.Ext["keep"] != "me" {
+		t.Errorf("Ext = %v", conf.Ext)
+	}
+	if _, ok := ext["feature_x"]; ok {
+		t.Error("Apply should not modify the original map")
+	}
+	if !conf.RedisSource["cache"].Enable {
+		t.Errorf("RedisSource = %v", conf.RedisSource)
+	}
+}
+
+func TestOverlayInvalidValue(t *testing.T) {
+	o := NewOverlay()
+	if err := o.Set(SourceFlag, "redis.poolsize", "ten"); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Apply(&AppConf{}); err == nil {
+		t.Error("Apply() expected error for invalid int")
+	}
+	if err := o.Set(SourceFlag, "redis.unknown", "x"); err == nil {
+		t.Error("Set() expected error for unknown path")
+	}
+}
+
+func TestOverlayApplyLogsOnce(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	o := NewOverlay()
+	o.Set(SourceFlag, "redis.host", "a")
+	o.Apply(&AppConf{})
+	o.Apply(&AppConf{})
+	o.Set(SourceFlag, "redis.poolsize", "10")
+	o.Apply(&AppConf{})
+	// 每个覆盖项只在首次应用时输出
+	if got := strings.Count(buf.String(), "overridden by"); got != 2 {
+		t.Errorf("logged %d overrides, want 2:\n%s", got, buf.String())
+	}
+}
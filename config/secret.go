@@ -0,0 +1,269 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/elvisNg/broccoli/utils"
+)
+
+const (
+	secretPrefix = "ENC("
+	secretSuffix = ")"
+	redacted     = "***"
+)
+
+// SecretProvider 解密配置中形如 ENC(...) 的值
+type SecretProvider interface {
+	// Decrypt 解密ENC()括号内的密文
+	Decrypt(ciphertext string) (string, error)
+}
+
+var (
+	secretMu       sync.RWMutex
+	secretProvider SecretProvider
+)
+
+// SetSecretProvider 设置全局的解密器，Configer.Init 时使用
+func SetSecretProvider(p SecretProvider) {
+	secretMu.Lock()
+	defer secretMu.Unlock()
+	secretProvider = p
+}
+
+func GetSecretProvider() SecretProvider {
+	secretMu.RLock()
+	defer secretMu.RUnlock()
+	return secretProvider
+}
+
+// NewSecretProvider 根据 Entry 的 secret_key_env/secret_key_file 创建AES解密器，都未配置时返回nil
+// 环境变量优先于文件
+func NewSecretProvider(entry *Entry) (SecretProvider, error) {
+	var key string
+	if !utils.IsEmptyString(entry.SecretKeyEnv) {
+		key = os.Getenv(entry.SecretKeyEnv)
+	}
+	if utils.IsEmptyString(key) && !utils.IsEmptyString(entry.SecretKeyFile) {
+		b, err := ioutil.ReadFile(entry.SecretKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read secret key file failed: %s", err)
+		}
+		key = strings.TrimSpace(string(b))
+	}
+	if utils.IsEmptyString(key) {
+		if !utils.IsEmptyString(entry.SecretKeyEnv) || !utils.IsEmptyString(entry.SecretKeyFile) {
+			return nil, errors.New("secret key is empty")
+		}
+		return nil, nil
+	}
+	return NewAesProvider([]byte(key))
+}
+
+// AesProvider AES-CBC解密，密文为base64编码，与 utils.AesEncrypt 兼容
+type AesProvider struct {
+	key []byte
+}
+
+// NewAesProvider key长度必须为16/24/32字节
+func NewAesProvider(key []byte) (*AesProvider, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("invalid secret key length %d, must be 16, 24 or 32", len(key))
+	}
+	return &AesProvider{key: key}, nil
+}
+
+// Encrypt 加密明文，返回可直接写入配置的 ENC(...) 值
+func (p *AesProvider) Encrypt(plaintext string) (string, error) {
+	b, err := utils.AesEncrypt([]byte(plaintext), p.key)
+	if err != nil {
+		return "", err
+	}
+	return secretPrefix + base64.StdEncoding.EncodeToString(b) + secretSuffix, nil
+}
+
+// Decrypt 与 utils.AesEncrypt 对应，使用CBC模式，IV为密钥的前16字节
+// 校验PKCS#7 padding，密钥错误时通常padding非法，返回错误
+func (p *AesProvider) Decrypt(ciphertext string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("invalid base64 secret: %s", err)
+	}
+	if len(b) == 0 || len(b)%aes.BlockSize != 0 {
+		return "", errors.New("invalid secret length")
+	}
+	block, err := aes.NewCipher(p.key)
+	if err != nil {
+		return "", err
+	}
+	out := make([]byte, len(b))
+	cipher.NewCBCDecrypter(block, p.key[:aes.BlockSize]).CryptBlocks(out, b)
+	if out, err = unpad(out, aes.BlockSize); err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// unpad 去除PKCS#7 padding，padding长度为1..blockSize且每个字节都等于长度
+func unpad(b []byte, blockSize int) ([]byte, error) {
+	n := int(b[len(b)-1])
+	if n == 0 || n > blockSize || n > len(b) {
+		return nil, errors.New("decrypt secret failed, wrong key?")
+	}
+	for _, c := range b[len(b)-n:] {
+		if int(c) != n {
+			return nil, errors.New("decrypt secret failed, wrong key?")
+		}
+	}
+	return b[:len(b)-n], nil
+}
+
+// FileKeystore 本地文件密钥库，文件内容为 {"密文": "明文"} 的json，用于测试和本地开发
+type FileKeystore struct {
+	secrets map[string]string
+}
+
+func NewFileKeystore(path string) (*FileKeystore, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ks := &FileKeystore{}
+	if err = utils.Unmarshal(b, &ks.secrets); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+func (ks *FileKeystore) Decrypt(ciphertext string) (string, error) {
+	v, ok := ks.secrets[ciphertext]
+	if !ok {
+		return "", fmt.Errorf("secret not found in keystore")
+	}
+	return v, nil
+}
+
+// IsSecret 判断是否为 ENC(...) 形式的值
+func IsSecret(s string) bool {
+	s = strings.TrimSpace(s)
+	return strings.HasPrefix(s, secretPrefix) && strings.HasSuffix(s, secretSuffix)
+}
+
+// DecryptSecrets 解密conf中所有 ENC(...) 形式的字符串，包括map和Ext中的值
+func DecryptSecrets(conf *AppConf) error {
+	if conf == nil {
+		return nil
+	}
+	return decryptValue(GetSecretProvider(), "", reflect.ValueOf(conf).Elem())
+}
+
+func decryptValue(p SecretProvider, path string, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return decryptValue(p, path, v.Elem())
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Field(i).CanSet() {
+				continue
+			}
+			name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" {
+				name = t.Field(i).Name
+			}
+			if err := decryptValue(p, joinPath(path, name), v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := decryptValue(p, fmt.Sprintf("%s[%d]", path, i), v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			// map的值不可寻址，复制后处理再写回
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(v.MapIndex(k))
+			if err := decryptValue(p, joinPath(path, fmt.Sprint(k.Interface())), e); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, e)
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		e := reflect.New(v.Elem().Type()).Elem()
+		e.Set(v.Elem())
+		if err := decryptValue(p, path, e); err != nil {
+			return err
+		}
+		v.Set(e)
+	case reflect.String:
+		s := strings.TrimSpace(v.String())
+		if !IsSecret(s) {
+			return nil
+		}
+		if p == nil {
+			return fmt.Errorf("%s: 配置包含加密值，但未配置secret provider", path)
+		}
+		plain, err := p.Decrypt(s[len(secretPrefix) : len(s)-len(secretSuffix)])
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		v.SetString(plain)
+	}
+	return nil
+}
+
+// secretKeyPattern 敏感字段名，如 pwd、registry_authpwd、paas_token、ak/sk
+var secretKeyPattern = regexp.MustCompile(`(?i)^([a-z0-9_]*(pwd|password|passwd|token|secret)|ak|sk|datasourcename)$`)
+
+// IsSecretKey 判断字段名是否为敏感字段
+func IsSecretKey(name string) bool {
+	return secretKeyPattern.MatchString(name)
+}
+
+var (
+	encPattern       = regexp.MustCompile(`ENC\([^)]*\)`)
+	secretKVPattern  = regexp.MustCompile(`(?i)(["']?\b(?:[a-z0-9_]*(?:pwd|password|passwd|token|secret)|ak|sk|datasourcename)["']?\s*[:=]\s*)("(?:[^"\\]|\\.)*"|'[^']*'|[^\s,}\]]+)`)
+	redactedQuoted   = `${1}"` + redacted + `"`
+	redactedEncValue = "ENC(" + redacted + ")"
+)
+
+// Redact 遮盖配置原始内容中的密文和敏感字段的值，用于日志输出，支持json/yaml/toml
+func Redact(content []byte) string {
+	s := encPattern.ReplaceAllString(string(content), redactedEncValue)
+	return secretKVPattern.ReplaceAllString(s, redactedQuoted)
+}
+
+// RedactChange 遮盖敏感字段的变化值，用于日志和命令行输出
+func RedactChange(c Change) Change {
+	segs := strings.Split(c.Path, ".")
+	if !IsSecretKey(segs[len(segs)-1]) {
+		return c
+	}
+	if c.Old != nil {
+		c.Old = redacted
+	}
+	if c.New != nil {
+		c.New = redacted
+	}
+	return c
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecryptSecrets(t *testing.T) {
+	defer SetSecretProvider(nil)
+	p, err := NewAesProvider([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := p.Encrypt("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte(`{"redis":{"host":"127.0.0.1:6379","pwd":"` + enc + `"},"ext":{"token":"` + enc + `"}}`)
+
+	if _, err := Load(&Entry{ConfigFormat: FormatJSON}, content, nil); err == nil {
+		t.Errorf("Load() without provider should fail")
+	}
+
+	SetSecretProvider(p)
+	c, err := Load(&Entry{ConfigFormat: FormatJSON}, content, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Get().Redis.Pwd != "s3cret" || c.Get().Ext["token"] != "s3cret" {
+		t.Errorf("decrypted = %q, %v", c.Get().Redis.Pwd, c.Get().Ext["token"])
+	}
+
+	wrong, _ := NewAesProvider([]byte("fedcba9876543210"))
+	SetSecretProvider(wrong)
+	if _, err := Load(&Entry{ConfigFormat: FormatJSON}, content, nil); err == nil {
+		t.Errorf("Load() with wrong key should fail")
+	}
+}
+
+func TestFileKeystore(t *testing.T) {
+	defer SetSecretProvider(nil)
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keystore.json")
+	if err = ioutil.WriteFile(path, []byte(`{"mysql-pwd":"root"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ks, err := NewFileKeystore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetSecretProvider(ks)
+	conf := &AppConf{Mysql: Mysql{Pwd: "ENC(mysql-pwd)"}}
+	if err = DecryptSecrets(conf); err != nil || conf.Mysql.Pwd != "root" {
+		t.Errorf("DecryptSecrets() = %q, %v", conf.Mysql.Pwd, err)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []string{
+		`{"redis":{"host":"h","pwd":"plain"},"obs":{"ak":"plain","sk":"plain"}}`,
+		"redis:\n  pwd: plain\n  registry_authpwd: 'plain'\n",
+		"[ebus]\npaas_token = \"plain\"\n",
+		`{"ext":{"x":"ENC(plain)"}}`,
+	}
+	for _, in := range tests {
+		if out := Redact([]byte(in)); strings.Contains(out, "plain") {
+			t.Errorf("Redact(%q) = %q", in, out)
+		}
+	}
+	if out := Redact([]byte(`{"redis":{"host":"h"}}`)); out != `{"redis":{"host":"h"}}` {
+		t.Errorf("Redact() changed non-secret content: %q", out)
+	}
+}
+
+func TestUnpad(t *testing.T) {
+	tests := []struct {
+		in   []byte
+		want string
+		ok   bool
+	}{
+		{[]byte("abc\x03\x03\x03"), "abc", true},
+		{[]byte("abcdefgh\x08\x08\x08\x08\x08\x08\x08\x08"), "abcdefgh", true},
+		{[]byte("abc\x00"), "", false},
+		{[]byte("abc\x01\x03\x03"), "", false}, // padding字节不一致
+		{[]byte("abc\x11"), "", false},         // 超过blockSize
+		{[]byte("\x05\x05"), "", false},        // 超过长度
+	}
+	for i, tt := range tests {
+		got, err := unpad(tt.in, 16)
+		if (err == nil) != tt.ok || string(got) != tt.want {
+			t.Errorf("%d: unpad(%q) = %q, %v", i, tt.in, got, err)
+		}
+	}
+}
@@ -1,16 +1,45 @@
 package config
 
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/elvisNg/broccoli/utils"
+)
+
 type Tomler struct {
 	original []byte // 配置的原始数据
-	conf *AppConf
+	conf     *AppConf
 }
 
-func (t *Tomler) Init(original []byte) error {
+func (t *Tomler) Init(original []byte) (err error) {
+	if utils.IsEmptyString(string(original)) {
+		msg := "Tomler.Init failed, 配置原始数据不能为空"
+		log.Println(msg)
+		err = errors.New(msg)
+		return
+	}
+	raw, err := DecodeMap(FormatTOML, original)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	conf, err := decodeMap(raw)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err = DecryptSecrets(conf); err != nil {
+		log.Println(err)
+		return
+	}
 	t.original = original
-	t.conf = nil
+	t.conf = conf
+	t.conf.UpdateTime = time.Now()
 	return nil
 }
 
 func (t *Tomler) Get() *AppConf {
 	return t.conf
-}
\ No newline at end of file
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/elvisNg/broccoli/utils"
+)
+
+// InTree 判断key是否属于以root为根的配置树，避免 /broccoli/svc 匹配到 /broccoli/svc2
+func InTree(root, key string) bool {
+	root = strings.TrimSuffix(root, "/")
+	return key == root || strings.HasPrefix(key, root+"/")
+}
+
+// MergeTree 将配置树合并到dst中
+// root 对应完整的配置文档，root下的子key按相对路径覆盖对应字段，如 root/redis、root/ext/feature_x
+// 浅层的key先合并，深层的key后合并，format 为根文档的格式，为空则自动识别
+func MergeTree(dst map[string]interface{}, root string, kvs map[string][]byte, format string) (map[string]interface{}, error) {
+	if dst == nil {
+		dst = make(map[string]interface{})
+	}
+	root = strings.TrimSuffix(root, "/")
+	keys := make([]string, 0, len(kvs))
+	for k := range kvs {
+		if InTree(root, k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		di, dj := strings.Count(keys[i], "/"), strings.Count(keys[j], "/")
+		if di != dj {
+			return di < dj
+		}
+		return keys[i] < keys[j]
+	})
+	for _, k := range keys {
+		content := kvs[k]
+		if len(bytes.TrimSpace(content)) == 0 {
+			continue
+		}
+		if k == root {
+			f := format
+			if f == "" {
+				f = DetectFormat(root, content)
+			}
+			doc, err := DecodeMap(f, content)
+			if err != nil {
+				return nil, fmt.Errorf("decode %s failed: %s", k, err)
+			}
+			deepMerge(dst, doc)
+			continue
+		}
+		v, err := decodeValue(content)
+		if err != nil {
+			return nil, fmt.Errorf("decode %s failed: %s", k, err)
+		}
+		rel := strings.Trim(strings.TrimPrefix(k, root), "/")
+		if rel == "" {
+			continue
+		}
+		setTreeValue(dst, strings.Split(rel, "/"), v)
+	}
+	return dst, nil
+}
+
+// decodeValue 解析子key的值
+// json值（对象、数字、布尔、带引号的字符串）按json解析，yaml/toml文档解析为对象，其余按原始字符串处理
+func decodeValue(content []byte) (interface{}, error) {
+	trimmed := bytes.TrimSpace(content)
+	if json.Valid(trimmed) {
+		var v interface{}
+		if err := utils.Unmarshal(trimmed, &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	if f := DetectFormat("", trimmed); f != FormatJSON {
+		if m, err := DecodeMap(f, trimmed); err == nil && len(m) > 0 {
+			return m, nil
+		}
+	}
+	return string(trimmed), nil
+}
+
+func setTreeValue(dst map[string]interface{}, path []string, v interface{}) {
+	cur := dst
+	for _, p := range path[:len(path)-1] {
+		next, ok := cur[p].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			cur[p] = next
+		}
+		cur = next
+	}
+	last := path[len(path)-1]
+	if vm, ok := v.(map[string]interface{}); ok {
+		if old, ok := cur[last].(map[string]interface{}); ok {
+			deepMerge(old, vm)
+			return
+		}
+	}
+	cur[last] = v
+}
+
+func deepMerge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if vm, ok := v.(map[string]interface{}); ok {
+			if dm, ok := dst[k].(map[string]interface{}); ok {
+				deepMerge(dm, vm)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInTree(t *testing.T) {
+	tests := []struct {
+		root, key string
+		want      bool
+	}{
+		{"/broccoli/svc", "/broccoli/svc", true},
+		{"/broccoli/svc", "/broccoli/svc/redis", true},
+		{"/broccoli/svc/", "/broccoli/svc/redis", true},
+		{"/broccoli/svc", "/broccoli/svc2", false},
+		{"/broccoli/svc", "/broccoli", false},
+	}
+	for _, tt := range tests {
+		if got := InTree(tt.root, tt.key); got != tt.want {
+			t.Errorf("InTree(%q, %q) = %v, want %v", tt.root, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestMergeTree(t *testing.T) {
+	common := map[string][]byte{
+		"/broccoli/common/redis":      []byte(`{"host":"common:6379","poolsize":10}`),
+		"/broccoli/common/ext/region": []byte(`cn`),
+	}
+	kvs := map[string][]byte{
+		"/broccoli/svc":                []byte(`{"redis":{"host":"svc:6379"},"ext":{"a":1}}`),
+		"/broccoli/svc/ext/feature_x":  []byte(`true`),
+		"/broccoli/svc/redis/poolsize": []byte(`20`),
+		"/broccoli/svc2/redis":         []byte(`{"host":"other"}`),
+	}
+	merged, err := MergeTree(nil, "/broccoli/common", common, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged, err = MergeTree(merged, "/broccoli/svc", kvs, FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"redis": map[string]interface{}{"host": "svc:6379", "poolsize": float64(20)},
+		"ext":   map[string]interface{}{"a": float64(1), "feature_x": true, "region": "cn"},
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("MergeTree() = %v, want %v", merged, want)
+	}
+}
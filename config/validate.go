@@ -0,0 +1,236 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Validator 配置校验器
+type Validator func(conf *AppConf) error
+
+// ExtValidator 扩展配置校验器，value为Ext[key]的值，key不存在时为nil
+type ExtValidator func(value interface{}) error
+
+// ValidationError 配置校验失败
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return "配置校验失败: " + strings.Join(e.Errors, "; ")
+}
+
+var (
+	validatorsMu    sync.RWMutex
+	validators      = make(map[string]Validator)
+	extValidators   = make(map[string]ExtValidator)
+	rejectedCounter uint64
+)
+
+// RegisterValidator 注册自定义配置校验器，同名覆盖
+func RegisterValidator(name string, fn Validator) {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	if fn == nil {
+		delete(validators, name)
+		return
+	}
+	validators[name] = fn
+}
+
+// RegisterExtValidator 注册Ext中指定key的校验器，同名覆盖
+func RegisterExtValidator(key string, fn ExtValidator) {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	if fn == nil {
+		delete(extValidators, key)
+		return
+	}
+	extValidators[key] = fn
+}
+
+// RejectedCount 校验失败被拒绝的配置次数
+func RejectedCount() uint64 {
+	return atomic.LoadUint64(&rejectedCounter)
+}
+
+// Validate 校验配置，依次执行内置校验器和注册的校验器
+func Validate(conf *AppConf) error {
+	if conf == nil {
+		return &ValidationError{Errors: []string{"配置为空"}}
+	}
+	var errs []string
+	add := func(section string, err error) {
+		if err != nil {
+			errs = append(errs, section+": "+err.Error())
+		}
+	}
+
+	add("log_conf", validateLogConf(&conf.LogConf))
+	add("redis", validateRedis(&conf.Redis))
+	for _, name := range sortedKeys(conf.RedisSource) {
+		r := conf.RedisSource[name]
+		add("redis_source."+name, validateRedis(&r))
+	}
+	add("mysql", validateMysql(&conf.Mysql))
+	for _, name := range sortedKeys(conf.MysqlSource) {
+		m := conf.MysqlSource[name]
+		add("mysql_source."+name, validateMysql(&m))
+	}
+	add("mongodb", validateMongoDB(&conf.MongoDB))
+	for _, name := range sortedKeys(conf.MongoDBSource) {
+		m := conf.MongoDBSource[name]
+		add("mongodb_source."+name, validateMongoDB(&m))
+	}
+	add("trace", validateTrace(&conf.Trace))
+	add("broker", validateBroker(&conf.Broker))
+	for _, name := range sortedKeys(conf.BrokerSource) {
+		b := conf.BrokerSource[name]
+		add("broker_source."+name, validateBroker(&b))
+	}
+
+	validatorsMu.RLock()
+	names := make([]string, 0, len(validators))
+	for name := range validators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add(name, validators[name](conf))
+	}
+	keys := make([]string, 0, len(extValidators))
+	for key := range extValidators {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		add("ext."+key, extValidators[key](conf.Ext[key]))
+	}
+	validatorsMu.RUnlock()
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// sortedKeys 排序后的map键，保证校验结果稳定
+func sortedKeys(m interface{}) []string {
+	v := reflect.ValueOf(m)
+	keys := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func oneOf(field, v string, allowed ...string) error {
+	if v == "" {
+		return nil
+	}
+	for _, a := range allowed {
+		if v == a {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %q 不支持，可选值: %s", field, v, strings.Join(allowed, "/"))
+}
+
+func firstErr(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateLogConf(c *LogConf) error {
+	// log_dir 为空时写到工作目录
+	return firstErr(
+		oneOf("level", strings.ToLower(c.Level), "panic", "fatal", "error", "warn", "warning", "info", "debug", "trace"),
+		oneOf("log", c.Log, "console", "file"),
+		oneOf("format", c.Format, "text", "json"),
+		oneOf("rotation_time", c.RotationTime, "hour", "day"),
+	)
+}
+
+func validateRedis(c *Redis) error {
+	if !c.Enable {
+		return nil
+	}
+	if strings.TrimSpace(c.Host) == "" && strings.TrimSpace(c.SentinelHost) == "" {
+		return fmt.Errorf("host 和 sentinel_host 不能同时为空")
+	}
+	if strings.TrimSpace(c.SentinelHost) != "" && strings.TrimSpace(c.SentinelMastername) == "" {
+		return fmt.Errorf("sentinel_mastername 不能为空")
+	}
+	if c.PoolSize < 0 {
+		return fmt.Errorf("poolsize 不能为负数")
+	}
+	return nil
+}
+
+func validateMysql(c *Mysql) error {
+	if !c.Enable {
+		return nil
+	}
+	if strings.TrimSpace(c.Host) == "" {
+		return fmt.Errorf("host 不能为空")
+	}
+	if strings.TrimSpace(c.DataSourceName) == "" {
+		return fmt.Errorf("datasourcename 不能为空")
+	}
+	if c.MaxOpenConns < 0 || c.MaxIdleConns < 0 || c.ConnMaxLifetime < 0 {
+		return fmt.Errorf("max_oepn_conns/max_idle_conns/conn_max_lifetime 不能为负数")
+	}
+	if c.MaxOpenConns > 0 && c.MaxIdleConns > c.MaxOpenConns {
+		return fmt.Errorf("max_idle_conns(%d) 不能大于 max_oepn_conns(%d)", c.MaxIdleConns, c.MaxOpenConns)
+	}
+	if strings.TrimSpace(c.Replicas) != "" {
+		for _, h := range strings.Split(c.Replicas, ",") {
+			if strings.TrimSpace(h) == "" {
+				return fmt.Errorf("replicas 包含空地址")
+			}
+		}
+	}
+	return nil
+}
+
+func validateMongoDB(c *MongoDB) error {
+	if !c.Enable {
+		return nil
+	}
+	if strings.TrimSpace(c.Host) == "" {
+		return fmt.Errorf("host 不能为空")
+	}
+	return nil
+}
+
+func validateTrace(c *Trace) error {
+	if err := oneOf("sampler", c.Sampler, "boundary", "counting", "mod"); err != nil {
+		return err
+	}
+	if c.Enable && strings.TrimSpace(c.TraceUrl) == "" {
+		return fmt.Errorf("trace_url 不能为空")
+	}
+	return nil
+}
+
+func validateBroker(c *Broker) error {
+	if !c.EnablePub && !c.EnableSub {
+		return nil
+	}
+	if len(c.Hosts) == 0 {
+		return fmt.Errorf("hosts 不能为空")
+	}
+	if c.Type == "" {
+		return fmt.Errorf("type 不能为空")
+	}
+	return oneOf("type", c.Type, "kafka", "rabbitmq", "redis")
+}
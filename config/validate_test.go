@@ -0,0 +1,96 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    *AppConf
+		wantErr bool
+	}{
+		{"empty", &AppConf{}, false},
+		{"nil", nil, true},
+		{"bad_log_level", &AppConf{LogConf: LogConf{Level: "verbose"}}, true},
+		{"file_log_without_dir", &AppConf{LogConf: LogConf{Log: "file"}}, false},
+		{"redis_disabled_without_host", &AppConf{Redis: Redis{}}, false},
+		{"redis_without_host", &AppConf{Redis: Redis{Enable: true}}, true},
+		{"redis_negative_pool", &AppConf{Redis: Redis{Enable: true, Host: "127.0.0.1:6379", PoolSize: -1}}, true},
+		{"redis_source_without_host", &AppConf{RedisSource: map[string]Redis{"cache": {Enable: true}}}, true},
+		{"mysql_idle_gt_open", &AppConf{Mysql: Mysql{Enable: true, Host: "db", DataSourceName: "app", MaxOpenConns: 1, MaxIdleConns: 2}}, true},
+		{"mysql_ok", &AppConf{Mysql: Mysql{Enable: true, Host: "db", DataSourceName: "app", MaxOpenConns: 2, MaxIdleConns: 1}}, false},
+		{"mysql_source_empty_replica", &AppConf{MysqlSource: map[string]Mysql{"order": {Enable: true, Host: "db", DataSourceName: "app", Replicas: "r1,,r2"}}}, true},
+		{"broker_unknown_type", &AppConf{Broker: Broker{EnablePub: true, Hosts: []string{"h"}, Type: "nats"}}, true},
+		{"go_micro_default_registry", &AppConf{GoMicro: GoMicro{RegistryPluginType: "kubernetes"}}, false},
+		{"go_micro_etcd_default_addrs", &AppConf{GoMicro: GoMicro{RegistryPluginType: "etcd"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.conf)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegisterExtValidator(t *testing.T) {
+	RegisterExtValidator("feature_x", func(v interface{}) error {
+		if _, ok := v.(bool); !ok {
+			return errors.New("must be bool")
+		}
+		return nil
+	})
+	defer RegisterExtValidator("feature_x", nil)
+
+	if err := Validate(&AppConf{Ext: map[string]interface{}{"feature_x": "yes"}}); err == nil {
+		t.Error("Validate() expected ext validator error")
+	}
+	if err := Validate(&AppConf{Ext: map[string]interface{}{"feature_x": true}}); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestLoadRejectsInvalid(t *testing.T) {
+	entry := &Entry{ConfigPath: "/broccoli/svc"}
+	prev, err := Load(entry, []byte(`{"redis":{"enable":true,"host":"127.0.0.1:6379"}}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := RejectedCount()
+	_, err = Load(entry, []byte(`{"redis":{"enable":true,"hots":"127.0.0.1:6379"}}`), prev)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Load() error = %v, want *ValidationError", err)
+	}
+	if RejectedCount() != before+1 {
+		t.Errorf("RejectedCount() = %d, want %d", RejectedCount(), before+1)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := &AppConf{Redis: Redis{Host: "a"}, Ext: map[string]interface{}{"k": "v1", "gone": 1}}
+	new := &AppConf{Redis: Redis{Host: "b"}, Ext: map[string]interface{}{"k": "v2", "added": true}}
+	changes := Diff(old, new)
+	got := make(map[string]Change)
+	for _, c := range changes {
+		got[c.Path] = c
+	}
+	if c, ok := got["redis.host"]; !ok || c.Old != "a" || c.New != "b" {
+		t.Errorf("redis.host change = %+v", c)
+	}
+	if c, ok := got["ext.k"]; !ok || c.Old != "v1" || c.New != "v2" {
+		t.Errorf("ext.k change = %+v", c)
+	}
+	if c, ok := got["ext.gone"]; !ok || c.New != nil {
+		t.Errorf("ext.gone change = %+v", c)
+	}
+	if c, ok := got["ext.added"]; !ok || c.Old != nil || c.New != true {
+		t.Errorf("ext.added change = %+v", c)
+	}
+	if len(changes) != 4 {
+		t.Errorf("Diff() = %v, want 4 changes", changes)
+	}
+}
@@ -1,16 +1,45 @@
 package config
 
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/elvisNg/broccoli/utils"
+)
+
 type Yamler struct {
 	original []byte // 配置的原始数据
 	conf     *AppConf
 }
 
-func (t *Yamler) Init(original []byte) error {
-	t.original = original
-	t.conf = nil
+func (y *Yamler) Init(original []byte) (err error) {
+	if utils.IsEmptyString(string(original)) {
+		msg := "Yamler.Init failed, 配置原始数据不能为空"
+		log.Println(msg)
+		err = errors.New(msg)
+		return
+	}
+	raw, err := DecodeMap(FormatYAML, original)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	conf, err := decodeMap(raw)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err = DecryptSecrets(conf); err != nil {
+		log.Println(err)
+		return
+	}
+	y.original = original
+	y.conf = conf
+	y.conf.UpdateTime = time.Now()
 	return nil
 }
 
-func (t *Yamler) Get() *AppConf {
-	return t.conf
+func (y *Yamler) Get() *AppConf {
+	return y.conf
 }
@@ -13,8 +13,15 @@ type ctxMongo struct {
 	cli zmongo.Mongo
 }
 
+type ctxMongoSourceMarker struct{}
+
+type ctxMongoSource struct {
+	clis map[string]zmongo.Mongo
+}
+
 var (
-	ctxMongoKey = &ctxMongoMarker{}
+	ctxMongoKey       = &ctxMongoMarker{}
+	ctxMongoSourceKey = &ctxMongoSourceMarker{}
 )
 
 // ExtractMongo takes the mongo from ctx.
@@ -39,3 +46,24 @@ func MongoToContext(ctx context.Context, c zmongo.Mongo) context.Context {
 	}
 	return context.WithValue(ctx, ctxMongoKey, r)
 }
+
+// ExtractMongoByName takes the named mongo from ctx.
+func ExtractMongoByName(ctx context.Context, name string) (c zmongo.Mongo, err error) {
+	r, ok := ctx.Value(ctxMongoSourceKey).(*ctxMongoSource)
+	if !ok || r == nil {
+		return nil, errors.New("ctxMongoSource was not set or nil")
+	}
+	if c = r.clis[name]; c == nil {
+		return nil, errors.New("ctxMongoSource." + name + " was not set or nil")
+	}
+	return
+}
+
+// MongoSourceToContext adds the named mongos to the context for extraction later.
+// Returning the new context that has been created.
+func MongoSourceToContext(ctx context.Context, clis map[string]zmongo.Mongo) context.Context {
+	r := &ctxMongoSource{
+		clis: clis,
+	}
+	return context.WithValue(ctx, ctxMongoSourceKey, r)
+}
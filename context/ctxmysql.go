@@ -12,8 +12,15 @@ type ctxMysql struct {
 	cli zmysql.Mysql
 }
 
+type ctxMysqlSourceMarker struct{}
+
+type ctxMysqlSource struct {
+	clis map[string]zmysql.Mysql
+}
+
 var (
-	ctxMysqlKey = &ctxMysqlMarker{}
+	ctxMysqlKey       = &ctxMysqlMarker{}
+	ctxMysqlSourceKey = &ctxMysqlSourceMarker{}
 )
 
 // ExtractMysql takes the mysql from ctx.
@@ -38,3 +45,24 @@ func MysqlToContext(ctx context.Context, c zmysql.Mysql) context.Context {
 	}
 	return context.WithValue(ctx, ctxMysqlKey, r)
 }
+
+// ExtractMysqlByName takes the named mysql from ctx.
+func ExtractMysqlByName(ctx context.Context, name string) (c zmysql.Mysql, err error) {
+	r, ok := ctx.Value(ctxMysqlSourceKey).(*ctxMysqlSource)
+	if !ok || r == nil {
+		return nil, errors.New("ctxMysqlSource was not set or nil")
+	}
+	if c = r.clis[name]; c == nil {
+		return nil, errors.New("ctxMysqlSource." + name + " was not set or nil")
+	}
+	return
+}
+
+// MysqlSourceToContext adds the named mysqls to the context for extraction later.
+// Returning the new context that has been created.
+func MysqlSourceToContext(ctx context.Context, clis map[string]zmysql.Mysql) context.Context {
+	r := &ctxMysqlSource{
+		clis: clis,
+	}
+	return context.WithValue(ctx, ctxMysqlSourceKey, r)
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 
 	"github.com/go-redis/redis"
+
+	"github.com/elvisNg/broccoli/redis/zredis"
 )
 
 type ctxRedisMarker struct{}
@@ -13,8 +15,15 @@ type ctxRedis struct {
 	cli *redis.Client
 }
 
+type ctxRedisSourceMarker struct{}
+
+type ctxRedisSource struct {
+	clis map[string]zredis.Redis
+}
+
 var (
-	ctxRedisKey = &ctxRedisMarker{}
+	ctxRedisKey       = &ctxRedisMarker{}
+	ctxRedisSourceKey = &ctxRedisSourceMarker{}
 )
 
 // ExtractRedis takes the rediscli from ctx.
@@ -39,3 +48,26 @@ func RedisToContext(ctx context.Context, rdc *redis.Client) context.Context {
 	}
 	return context.WithValue(ctx, ctxRedisKey, r)
 }
+
+// ExtractRedisByName takes the named rediscli from ctx.
+func ExtractRedisByName(ctx context.Context, name string) (rdc *redis.Client, err error) {
+	r, ok := ctx.Value(ctxRedisSourceKey).(*ctxRedisSource)
+	if !ok || r == nil {
+		return nil, errors.New("ctxRedisSource was not set or nil")
+	}
+	cli, ok := r.clis[name]
+	if !ok || cli == nil || cli.GetCli() == nil {
+		return nil, errors.New("ctxRedisSource." + name + " was not set or nil")
+	}
+	rdc = cli.GetCli()
+	return
+}
+
+// RedisSourceToContext adds the named redisclis to the context for extraction later.
+// Returning the new context that has been created.
+func RedisSourceToContext(ctx context.Context, clis map[string]zredis.Redis) context.Context {
+	r := &ctxRedisSource{
+		clis: clis,
+	}
+	return context.WithValue(ctx, ctxRedisSourceKey, r)
+}
@@ -0,0 +1,304 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/engine"
+	"github.com/elvisNg/broccoli/plugin/zcontainer"
+	"github.com/elvisNg/broccoli/utils"
+)
+
+const (
+	defaultWaitTime   = 5 * time.Minute
+	defaultRetryDelay = time.Second
+	maxRetryDelay     = 30 * time.Second
+)
+
+// Entry.Ext 中可选的consul配置项
+const (
+	extToken      = "consul_token"
+	extDatacenter = "consul_datacenter"
+	extScheme     = "consul_scheme"
+)
+
+type ng struct {
+	entry      *config.Entry
+	configer   config.Configer
+	client     *api.Client
+	container  zcontainer.Container
+	context    context.Context
+	cancelFunc context.CancelFunc
+	options    *Options
+	lastIndex  uint64
+	prevRaw    []byte
+	history    *config.History
+
+	healthMu sync.RWMutex
+	health   engine.WatchHealth
+}
+
+type Options struct {
+	context  context.Context
+	waitTime time.Duration
+}
+
+type Option func(o *Options)
+
+// WithWaitTime blocking query 的最长等待时间
+func WithWaitTime(d time.Duration) Option {
+	return func(o *Options) {
+		o.waitTime = d
+	}
+}
+
+func New(entry *config.Entry, container zcontainer.Container, opts ...Option) (engine.Engine, error) {
+	n := &ng{
+		entry:     entry,
+		container: container,
+		options: &Options{
+			waitTime: defaultWaitTime,
+		},
+		history: config.NewHistory(config.DefaultHistorySize),
+	}
+	for _, o := range opts {
+		o(n.options)
+	}
+	client, err := api.NewClient(n.getConsulClientConfig())
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	n.client = client
+	n.context, n.cancelFunc = context.WithCancel(context.Background())
+	return n, nil
+}
+
+func (n *ng) getConsulClientConfig() *api.Config {
+	c := api.DefaultConfig()
+	if len(n.entry.EndPoints) > 0 {
+		c.Address = n.entry.EndPoints[0]
+	}
+	if !utils.IsEmptyString(n.entry.UserName) && !utils.IsEmptyString(n.entry.Password) {
+		c.HttpAuth = &api.HttpBasicAuth{
+			Username: n.entry.UserName,
+			Password: n.entry.Password,
+		}
+	}
+	if v, ok := n.entry.Ext[extToken]; ok {
+		c.Token = v
+	}
+	if v, ok := n.entry.Ext[extDatacenter]; ok {
+		c.Datacenter = v
+	}
+	if n.entry.TLSEnabled() {
+		c.Scheme = "https"
+		c.TLSConfig = api.TLSConfig{
+			Address:  n.entry.ServerName,
+			CAFile:   n.entry.CAFile,
+			CertFile: n.entry.CertFile,
+			KeyFile:  n.entry.KeyFile,
+		}
+	}
+	if v, ok := n.entry.Ext[extScheme]; ok {
+		c.Scheme = v
+	}
+	return c
+}
+
+// key consul的key不能以"/"开头
+func (n *ng) key() string {
+	return strings.TrimPrefix(n.entry.ConfigPath, "/")
+}
+
+// loadConfig 加载初始化配置，失败则程序退出
+func (n *ng) loadConfig() (err error) {
+	log.Printf("[broccoli] [engine.loadConfig] Begin: 加载配置，configpath: %s\n", n.entry.ConfigPath)
+	if utils.IsEmptyString(n.key()) {
+		msg := "[broccoli] [engine.loadConfig] 配置路径不能为空"
+		log.Println(msg)
+		err = errors.New(msg)
+		return
+	}
+	c, ccf := context.WithTimeout(n.context, 30*time.Second)
+	defer ccf()
+	pair, meta, err := n.client.KV().Get(n.key(), (&api.QueryOptions{}).WithContext(c))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if pair == nil || utils.IsEmptyString(string(pair.Value)) {
+		msg := "[broccoli] [engine.loadConfig] " + n.entry.ConfigPath + " " + "配置信息为空"
+		log.Println(msg)
+		err = errors.New(msg)
+		return
+	}
+	if err = n.refreshConfig(pair.Value, pair.ModifyIndex); err != nil {
+		log.Println(err)
+		return
+	}
+	n.lastIndex = meta.LastIndex
+	n.setWatchOK(meta.LastIndex, false)
+	log.Printf("[broccoli] [engine.loadConfig] End: 加载配置成功，configpath: %s\n", n.entry.ConfigPath)
+	return
+}
+
+// refreshConfig 刷新配置，失败则保留原来配置，不影响当前的运行
+func (n *ng) refreshConfig(content []byte, index uint64) (err error) {
+	log.Printf("[broccoli] [engine.refreshConfig] configpath: %s，configcontent: %s\n", n.entry.ConfigPath, config.Redact(content))
+	configer, err := config.Load(n.entry, content, n.configer)
+	if err != nil {
+		log.Printf("[broccoli] [engine.refreshConfig] 刷新配置失败，保留原来配置，configpath: %s，err: %s\n", n.entry.ConfigPath, err)
+		return
+	}
+	log.Printf("[broccoli] [engine.refreshConfig] 刷新配置成功，configpath: %s\n", n.entry.ConfigPath)
+	n.configer = configer
+	n.prevRaw = content
+	n.history.Add(config.Version{
+		Revision: int64(index),
+		Format:   n.entry.ConfigFormat,
+		Content:  content,
+	}, configer.Get())
+	return
+}
+
+func (n *ng) Init() (err error) {
+	return n.loadConfig()
+}
+
+func (n *ng) GetConfiger() (config.Configer, error) {
+	return n.configer, nil
+}
+
+func (n *ng) GetContainer() zcontainer.Container {
+	return n.container
+}
+
+func (n *ng) History() *config.History {
+	return n.history
+}
+
+// Rollback 将指定版本的内容写回consul，由监听生效
+func (n *ng) Rollback(version int) error {
+	v, err := n.history.Get(version)
+	if err != nil {
+		return err
+	}
+	c, ccf := context.WithTimeout(n.context, 30*time.Second)
+	defer ccf()
+	pair := &api.KVPair{Key: n.key(), Value: v.Content}
+	if _, err = n.client.KV().Put(pair, (&api.WriteOptions{}).WithContext(c)); err != nil {
+		log.Printf("[broccoli] [engine.Rollback] 回滚失败，configpath: %s，version: %d，err: %s\n", n.entry.ConfigPath, version, err)
+		return err
+	}
+	log.Printf("[broccoli] [engine.Rollback] 已回滚，configpath: %s，version: %d\n", n.entry.ConfigPath, version)
+	return nil
+}
+
+// Subscribe 使用blocking query监听配置变化
+func (n *ng) Subscribe(changes chan interface{}, cancelC chan struct{}) error {
+	defer n.cancelFunc()
+	// cancelC 触发时中断正在进行的blocking query
+	go func() {
+		select {
+		case <-cancelC:
+			n.cancelFunc()
+		case <-n.context.Done():
+		}
+	}()
+
+	log.Printf("[broccoli] [engine.Subscribe] Begin watching consul configpath: %s\n", n.entry.ConfigPath)
+	retryDelay := defaultRetryDelay
+	for {
+		opts := &api.QueryOptions{
+			WaitIndex: n.lastIndex,
+			WaitTime:  n.options.waitTime,
+		}
+		pair, meta, err := n.client.KV().Get(n.key(), opts.WithContext(n.context))
+		if n.context.Err() != nil {
+			log.Printf("[broccoli] [engine.Subscribe] cancel watch config: %s\n", n.entry.ConfigPath)
+			return nil
+		}
+		if err != nil {
+			n.setWatchError(err)
+			log.Printf("[broccoli] [engine.Subscribe] watch error: %s, retry after %s\n", err, retryDelay)
+			select {
+			case <-time.After(retryDelay):
+			case <-n.context.Done():
+				return nil
+			}
+			if retryDelay *= 2; retryDelay > maxRetryDelay {
+				retryDelay = maxRetryDelay
+			}
+			continue
+		}
+		retryDelay = defaultRetryDelay
+		n.setWatchOK(meta.LastIndex, meta.LastIndex != n.lastIndex)
+
+		// index 回退说明consul发生了重置，需要从头开始监听
+		if meta.LastIndex < n.lastIndex {
+			n.lastIndex = 0
+			continue
+		}
+		if meta.LastIndex == n.lastIndex {
+			continue
+		}
+		n.lastIndex = meta.LastIndex
+
+		if pair == nil {
+			log.Printf("[broccoli] [engine.Subscribe] configpath: %s was deleted, keep current config\n", n.entry.ConfigPath)
+			continue
+		}
+		if string(pair.Value) == string(n.prevRaw) {
+			log.Println("[broccoli] [engine.Subscribe] config content no changed")
+			continue
+		}
+		if err = n.refreshConfig(pair.Value, pair.ModifyIndex); err != nil {
+			log.Printf("[broccoli] [engine.Subscribe] ignore '%s', error: %s\n", n.entry.ConfigPath, err)
+			continue
+		}
+		log.Printf("[broccoli] [engine.Subscribe] configPath: %s change\n", n.entry.ConfigPath)
+		select {
+		case changes <- n.configer:
+		case <-n.context.Done():
+			log.Printf("[broccoli] [engine.Subscribe] cancel watch config: %s\n", n.entry.ConfigPath)
+			return nil
+		default: // 防止忘记消费changes导致一直阻塞
+			log.Printf("[broccoli] [engine.Subscribe] channel is blocked, can not push change into changes")
+		}
+	}
+}
+
+// WatchHealth 监听状态
+func (n *ng) WatchHealth() engine.WatchHealth {
+	n.healthMu.RLock()
+	defer n.healthMu.RUnlock()
+	return n.health
+}
+
+func (n *ng) setWatchOK(index uint64, event bool) {
+	n.healthMu.Lock()
+	defer n.healthMu.Unlock()
+	n.health.Healthy = true
+	n.health.Revision = int64(index)
+	n.health.Retries = 0
+	if event {
+		n.health.LastEvent = time.Now()
+	}
+}
+
+func (n *ng) setWatchError(err error) {
+	n.healthMu.Lock()
+	defer n.healthMu.Unlock()
+	n.health.Healthy = false
+	n.health.Retries++
+	n.health.LastError = err.Error()
+	n.health.ErrorTime = time.Now()
+}
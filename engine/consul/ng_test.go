@@ -0,0 +1,164 @@
+package consul
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/engine"
+	"github.com/elvisNg/broccoli/engine/enginetest"
+)
+
+var (
+	_ engine.Historian           = (*ng)(nil)
+	_ engine.WatchHealthReporter = (*ng)(nil)
+)
+
+// kvServer 模拟consul的 /v1/kv/ 接口，支持blocking query
+type kvServer struct {
+	*enginetest.Store
+}
+
+func newKVServer(value string) *kvServer {
+	return &kvServer{Store: enginetest.NewStore(value, 10)}
+}
+
+func (s *kvServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		b, _ := ioutil.ReadAll(r.Body)
+		s.Set(string(b), 0)
+		w.Write([]byte("true"))
+		return
+	}
+	if s.Failing() {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); index > 0 {
+		s.Wait(r.Context(), index, time.Second)
+		if r.Context().Err() != nil {
+			return
+		}
+	}
+	value, index := s.Get()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode([]map[string]interface{}{{
+		"Key":         "config/app",
+		"Value":       value,
+		"ModifyIndex": index,
+	}})
+}
+
+// watch 创建engine并开始监听，返回的next等待下一次推送的配置
+func watch(t *testing.T, ks *kvServer) (n *ng, next func() config.Configer, stop func()) {
+	srv := httptest.NewServer(ks)
+	entry := &config.Entry{
+		EngineType:   "consul",
+		ConfigPath:   "/config/app",
+		ConfigFormat: config.FormatJSON,
+		EndPoints:    []string{srv.URL},
+	}
+	e, err := New(entry, nil, WithWaitTime(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	changes := make(chan interface{}, 1)
+	cancelC := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		e.Subscribe(changes, cancelC)
+		close(exited)
+	}()
+	next = func() config.Configer {
+		t.Helper()
+		select {
+		case c := <-changes:
+			return c.(config.Configer)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no change received")
+		}
+		return nil
+	}
+	stop = func() {
+		close(cancelC)
+		select {
+		case <-exited:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Subscribe did not exit after cancel")
+		}
+		srv.Close()
+	}
+	return e.(*ng), next, stop
+}
+
+func TestSubscribe(t *testing.T) {
+	ks := newKVServer(`{"redis":{"host":"a"}}`)
+	n, next, stop := watch(t, ks)
+	defer stop()
+	if c, _ := n.GetConfiger(); c.Get().Redis.Host != "a" {
+		t.Fatalf("init host = %q, want a", c.Get().Redis.Host)
+	}
+
+	ks.Set(`{"redis":{"host":"b"}}`, 0)
+	if c := next(); c.Get().Redis.Host != "b" {
+		t.Errorf("host = %q, want b", c.Get().Redis.Host)
+	}
+
+	// consul异常时退避重试，恢复后继续监听
+	ks.Fail(1)
+	ks.Set(`{"redis":{"host":"c"}}`, 0)
+	if c := next(); c.Get().Redis.Host != "c" {
+		t.Errorf("host = %q, want c", c.Get().Redis.Host)
+	}
+	if h := n.WatchHealth(); !h.Healthy || h.Revision != 12 {
+		t.Errorf("health = %+v, want healthy at index 12", h)
+	}
+}
+
+func TestSubscribeIndexReset(t *testing.T) {
+	ks := newKVServer(`{"redis":{"host":"a"}}`)
+	_, next, stop := watch(t, ks)
+	defer stop()
+
+	// consul重置后index回退，从头开始监听，新的配置仍然生效
+	ks.Set(`{"redis":{"host":"b"}}`, 3)
+	if c := next(); c.Get().Redis.Host != "b" {
+		t.Errorf("host = %q, want b", c.Get().Redis.Host)
+	}
+	ks.Set(`{"redis":{"host":"c"}}`, 0)
+	if c := next(); c.Get().Redis.Host != "c" {
+		t.Errorf("host = %q, want c", c.Get().Redis.Host)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	ks := newKVServer(`{"redis":{"host":"a"}}`)
+	n, next, stop := watch(t, ks)
+	defer stop()
+
+	ks.Set(`{"redis":{"host":"b"}}`, 0)
+	next()
+
+	// 回滚写回consul，由监听生效
+	if err := n.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	if c := next(); c.Get().Redis.Host != "a" {
+		t.Errorf("rollback host = %q, want a", c.Get().Redis.Host)
+	}
+	if value, _ := ks.Get(); string(value) != `{"redis":{"host":"a"}}` {
+		t.Errorf("consul value = %s", value)
+	}
+	if _, err := n.History().Get(3); err != nil {
+		t.Errorf("rollback should be recorded as a new version: %s", err)
+	}
+}
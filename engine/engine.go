@@ -1,6 +1,8 @@
 package engine
 
 import (
+	"time"
+
 	"github.com/elvisNg/broccoli/config"
 	"github.com/elvisNg/broccoli/plugin/zcontainer"
 )
@@ -23,3 +25,34 @@ type Engine interface {
 }
 
 type NewEngineFn func(cnt zcontainer.Container) (Engine, error)
+
+// Historian 支持配置历史与回滚的engine，通过类型断言获取
+type Historian interface {
+	// History 已生效的配置历史
+	History() *config.History
+
+	// Rollback 回滚到指定版本
+	// etcd/consul 将该版本写回配置中心，由监听生效；file 在本地锁定该版本，直到配置文件再次变化
+	Rollback(version int) error
+}
+
+// WatchHealth 配置监听的健康状态
+type WatchHealth struct {
+	Healthy   bool      `json:"healthy"`              // 监听是否正常
+	Revision  int64     `json:"revision"`             // 最近一次读取或监听到的配置中心版本
+	LastEvent time.Time `json:"last_event,omitempty"` // 最近一次收到配置变化的时间
+	LastError string    `json:"last_error,omitempty"` // 最近一次监听错误
+	ErrorTime time.Time `json:"error_time,omitempty"`
+	Retries   int       `json:"retries"` // 连续重试次数，恢复后清零
+}
+
+// WatchHealthReporter 支持上报监听状态的engine，通过类型断言获取
+type WatchHealthReporter interface {
+	WatchHealth() WatchHealth
+}
+
+// Acker 需要确认处理完成的配置变化，通过类型断言获取
+// 处理方重新加载容器组件后调用 Ack，如 memory engine 的 Set 等待 Ack 后返回
+type Acker interface {
+	Ack()
+}
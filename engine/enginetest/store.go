@@ -0,0 +1,77 @@
+// Package enginetest 配置中心engine测试用的工具
+package enginetest
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store 模拟配置中心中的一份带版本的配置，用于实现各engine测试的假服务
+// 支持长轮询、blocking query 等待变化，以及注入请求失败
+type Store struct {
+	mu      sync.Mutex
+	version uint64
+	value   []byte
+	fail    int           // 接下来失败的请求数
+	changed chan struct{} // 配置变化时关闭
+}
+
+func NewStore(value string, version uint64) *Store {
+	return &Store{version: version, value: []byte(value), changed: make(chan struct{})}
+}
+
+// Set 更新配置，version为0时递增，唤醒等待中的请求
+func (s *Store) Set(value string, version uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if version == 0 {
+		version = s.version + 1
+	}
+	s.version = version
+	s.value = []byte(value)
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Get 当前的配置和版本
+func (s *Store) Get() (value []byte, version uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.value, s.version
+}
+
+// Fail 接下来的n个请求失败
+func (s *Store) Fail(n int) {
+	s.mu.Lock()
+	s.fail = n
+	s.mu.Unlock()
+}
+
+// Failing 本次请求是否应失败，由假服务在处理请求前调用
+func (s *Store) Failing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail > 0 {
+		s.fail--
+		return true
+	}
+	return false
+}
+
+// Wait 当前版本为version时等待配置变化，返回是否变化，超时或ctx结束时返回false
+func (s *Store) Wait(ctx context.Context, version uint64, timeout time.Duration) bool {
+	s.mu.Lock()
+	current, changed := s.version, s.changed
+	s.mu.Unlock()
+	if current != version {
+		return true
+	}
+	select {
+	case <-changed:
+		return true
+	case <-time.After(timeout):
+	case <-ctx.Done():
+	}
+	return false
+}
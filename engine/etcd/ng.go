@@ -5,9 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	etcd "github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/coreos/etcd/pkg/transport"
 
 	"github.com/elvisNg/broccoli/config"
 	"github.com/elvisNg/broccoli/engine"
@@ -23,8 +28,23 @@ type ng struct {
 	context    context.Context
 	cancelFunc context.CancelFunc
 	options    *Options
+
+	kvs       map[string][]byte // ConfigPath 配置树下的键值
+	commonKvs map[string][]byte // CommonPath 配置树下的键值
+	revision  int64             // 最近一次读取或监听到的etcd revision
+	history   *config.History
+
+	healthMu sync.RWMutex
+	health   engine.WatchHealth
 }
 
+const (
+	defaultRetryDelay = time.Second
+	maxRetryDelay     = 30 * time.Second
+	// maxTxnOps etcd 单个事务允许的最大操作数，对应服务端 --max-txn-ops 的默认值
+	maxTxnOps = 128
+)
+
 type Options struct {
 	context context.Context
 }
@@ -36,6 +56,7 @@ func New(entry *config.Entry, container zcontainer.Container, opts ...Option) (e
 		entry:     entry,
 		container: container,
 		options:   &Options{},
+		history:   config.NewHistory(config.DefaultHistorySize),
 	}
 	for _, o := range opts {
 		o(n.options)
@@ -48,10 +69,8 @@ func New(entry *config.Entry, container zcontainer.Container, opts ...Option) (e
 }
 
 func (n *ng) reconnect() error {
-	var client *etcd.Client
-	cfg := n.getEtcdClientConfig()
-	var err error
-	if client, err = etcd.New(cfg); err != nil {
+	client, err := NewClient(n.entry)
+	if err != nil {
 		return err
 	}
 	ctx, cancelFunc := context.WithCancel(context.Background())
@@ -67,18 +86,44 @@ func (n *ng) reconnect() error {
 	return nil
 }
 
-func (n *ng) getEtcdClientConfig() etcd.Config {
+// NewClient 按配置入口创建etcd客户端，支持用户名密码和TLS，供engine和工具使用
+func NewClient(entry *config.Entry) (*etcd.Client, error) {
+	cfg, err := clientConfig(entry)
+	if err != nil {
+		return nil, err
+	}
+	return etcd.New(cfg)
+}
+
+func clientConfig(entry *config.Entry) (etcd.Config, error) {
 	c := etcd.Config{
-		Endpoints: n.entry.EndPoints,
+		Endpoints: entry.EndPoints,
+		// 连接保活，及时发现断开的连接
+		DialKeepAliveTime:    10 * time.Second,
+		DialKeepAliveTimeout: 3 * time.Second,
+	}
+	if !utils.IsEmptyString(entry.UserName) && !utils.IsEmptyString(entry.Password) {
+		c.Username = entry.UserName
+		c.Password = entry.Password
 	}
-	if !utils.IsEmptyString(n.entry.UserName) && !utils.IsEmptyString(n.entry.Password) {
-		c.Username = n.entry.UserName
-		c.Password = n.entry.Password
+	if entry.TLSEnabled() {
+		tlsInfo := transport.TLSInfo{
+			CertFile:      entry.CertFile,
+			KeyFile:       entry.KeyFile,
+			TrustedCAFile: entry.CAFile,
+			ServerName:    entry.ServerName,
+		}
+		tlsConfig, err := tlsInfo.ClientConfig()
+		if err != nil {
+			return c, err
+		}
+		c.TLS = tlsConfig
 	}
-	return c
+	return c, nil
 }
 
 // loadConfig 加载初始化配置，失败则程序退出
+// 读取 ConfigPath 和 CommonPath 下的所有key，合并为一份配置
 func (n *ng) loadConfig() (err error) {
 	log.Printf("[broccoli] [engine.loadConfig] Begin: 加载配置，configpath: %s\n", n.entry.ConfigPath)
 	if utils.IsEmptyString(n.entry.ConfigPath) {
@@ -87,25 +132,27 @@ func (n *ng) loadConfig() (err error) {
 		err = errors.New(msg)
 		return
 	}
-	tt := 30 * time.Second
-	c, ccf := context.WithTimeout(n.context, tt)
-	defer ccf()
-	response, err := n.client.Get(c, n.entry.ConfigPath)
-	ccf()
+	kvs, rev, err := n.getTree(n.entry.ConfigPath)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-
-	if len(response.Kvs) == 0 || utils.IsEmptyString(string(response.Kvs[0].Value)) {
+	commonKvs := make(map[string][]byte)
+	if !utils.IsEmptyString(n.entry.CommonPath) {
+		if commonKvs, _, err = n.getTree(n.entry.CommonPath); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	if len(kvs) == 0 && len(commonKvs) == 0 {
 		msg := "[broccoli] [engine.loadConfig] " + n.entry.ConfigPath + " " + "配置信息为空"
 		log.Println(msg)
 		err = errors.New(msg)
 		return
 	}
-	content := response.Kvs[0].Value
-	err = n.refreshConfig(content)
-	if err != nil {
+	n.kvs, n.commonKvs = kvs, commonKvs
+	n.setWatchOK(rev, false)
+	if err = n.refreshTree(); err != nil {
 		log.Println(err)
 		return
 	}
@@ -113,42 +160,76 @@ func (n *ng) loadConfig() (err error) {
 	return
 }
 
-// refreshConfig 刷新配置，失败则保留原来配置，不影响当前的运行
-func (n *ng) refreshConfig(content []byte) (err error) {
-	log.Printf("[broccoli] [engine.refreshConfig] configpath: %s，configcontent: %s\n", n.entry.ConfigPath, string(content))
-	configFormat := n.entry.ConfigFormat
-	if utils.IsEmptyString(configFormat) {
-		configFormat = "json"
-	}
-	var configer config.Configer
-	switch configFormat {
-	case "json":
-		jsoner := &config.Jsoner{}
-		err = jsoner.Init(content)
-		if err != nil {
-			msg := "[broccoli] [engine.refreshConfig] jsoner 加载配置失败，configpath: " + n.entry.ConfigPath
-			log.Println(msg)
-			return
+// getTree 读取root配置树下的所有key
+func (n *ng) getTree(root string) (kvs map[string][]byte, rev int64, err error) {
+	c, ccf := context.WithTimeout(n.context, 30*time.Second)
+	defer ccf()
+	response, err := n.client.Get(c, root, etcd.WithPrefix())
+	if err != nil {
+		return
+	}
+	kvs = make(map[string][]byte)
+	for _, kv := range response.Kvs {
+		if config.InTree(root, string(kv.Key)) {
+			kvs[string(kv.Key)] = kv.Value
 		}
-		configer = jsoner
-	case "toml":
-		msg := "[broccoli] [engine.refreshConfig] toml:不支持的配置格式，configpath: " + n.entry.ConfigPath
-		log.Println(msg)
-		err = errors.New(msg)
+	}
+	rev = response.Header.Revision
+	return
+}
+
+// refreshTree 合并配置树并刷新配置
+// 只有 ConfigPath 一个key时直接使用原始内容，保持原有格式
+func (n *ng) refreshTree() (err error) {
+	entry, content, err := n.mergedContent()
+	if err != nil {
 		return
-	default:
-		msg := "[broccoli] [engine.refreshConfig] " + configFormat + ":不支持的配置格式，configpath: " + n.entry.ConfigPath
-		log.Println(msg)
-		err = errors.New(msg)
+	}
+	if err = n.refreshConfig(entry, content); err != nil {
+		return
+	}
+	snapshot := make(map[string][]byte, len(n.kvs))
+	for k, v := range n.kvs {
+		snapshot[k] = v
+	}
+	n.history.Add(config.Version{
+		Revision: n.revision,
+		Format:   entry.ConfigFormat,
+		Content:  content,
+		Snapshot: snapshot,
+	}, n.configer.Get())
+	return
+}
+
+func (n *ng) mergedContent() (entry *config.Entry, content []byte, err error) {
+	if v, ok := n.kvs[n.entry.ConfigPath]; ok && len(n.kvs) == 1 && len(n.commonKvs) == 0 {
+		return n.entry, v, nil
+	}
+	merged, err := config.MergeTree(nil, n.entry.CommonPath, n.commonKvs, "")
+	if err != nil {
+		return
+	}
+	if merged, err = config.MergeTree(merged, n.entry.ConfigPath, n.kvs, n.entry.ConfigFormat); err != nil {
+		return
+	}
+	if content, err = utils.Marshal(merged); err != nil {
 		return
 	}
+	e := *n.entry
+	e.ConfigFormat = config.FormatJSON
+	return &e, content, nil
+}
 
-	if configer != nil {
-		log.Printf("[broccoli] [engine.refreshConfig] 刷新配置成功，configpath: %s\n", n.entry.ConfigPath)
-		n.configer = configer
+// refreshConfig 刷新配置，失败则保留原来配置，不影响当前的运行
+func (n *ng) refreshConfig(entry *config.Entry, content []byte) (err error) {
+	log.Printf("[broccoli] [engine.refreshConfig] configpath: %s，configcontent: %s\n", n.entry.ConfigPath, config.Redact(content))
+	configer, err := config.Load(entry, content, n.configer)
+	if err != nil {
+		log.Printf("[broccoli] [engine.refreshConfig] 刷新配置失败，保留原来配置，configpath: %s，err: %s\n", n.entry.ConfigPath, err)
 		return
 	}
-	log.Printf("[broccoli] [engine.refreshConfig] 刷新配置失败，保留原来配置，configpath: %s\n", n.entry.ConfigPath)
+	log.Printf("[broccoli] [engine.refreshConfig] 刷新配置成功，configpath: %s\n", n.entry.ConfigPath)
+	n.configer = configer
 	return
 }
 
@@ -178,84 +259,248 @@ func (n *ng) GetContainer() zcontainer.Container {
 	return n.container
 }
 
-// Subscribe 监听
+func (n *ng) History() *config.History {
+	return n.history
+}
+
+// Rollback 将 ConfigPath 配置树恢复为指定版本的快照，写回etcd后由监听生效
+// 只恢复服务自身的配置树，CommonPath 为多个服务共享，不做修改
+// 读取配置树后有其他修改时不覆盖，返回错误；需要的操作数超过 maxTxnOps 时返回错误
+func (n *ng) Rollback(version int) error {
+	v, err := n.history.Get(version)
+	if err != nil {
+		return err
+	}
+	current, rev, err := n.getTree(n.entry.ConfigPath)
+	if err != nil {
+		return err
+	}
+	var ops []etcd.Op
+	for k := range current {
+		if _, ok := v.Snapshot[k]; !ok {
+			ops = append(ops, etcd.OpDelete(k))
+		}
+	}
+	for k, val := range v.Snapshot {
+		if cur, ok := current[k]; ok && string(cur) == string(val) {
+			continue
+		}
+		ops = append(ops, etcd.OpPut(k, string(val)))
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	if len(ops) > maxTxnOps {
+		return fmt.Errorf("rollback to version %d needs %d ops, exceeds %d ops per txn", version, len(ops), maxTxnOps)
+	}
+	root := strings.TrimSuffix(n.entry.ConfigPath, "/")
+	c, ccf := context.WithTimeout(n.context, 30*time.Second)
+	defer ccf()
+	resp, err := n.client.Txn(c).If(
+		etcd.Compare(etcd.ModRevision(root), "<", rev+1),
+		etcd.Compare(etcd.ModRevision(root+"/"), "<", rev+1).WithPrefix(),
+	).Then(ops...).Commit()
+	if err == nil && !resp.Succeeded {
+		err = fmt.Errorf("config changed after revision %d", rev)
+	}
+	if err != nil {
+		log.Printf("[broccoli] [engine.Rollback] 回滚失败，configpath: %s，version: %d，err: %s\n", n.entry.ConfigPath, version, err)
+		return err
+	}
+	log.Printf("[broccoli] [engine.Rollback] 已回滚，configpath: %s，version: %d，revision: %d\n", n.entry.ConfigPath, version, v.Revision)
+	return nil
+}
+
+// Subscribe 监听 ConfigPath 和 CommonPath 配置树的变化
+// 监听出错时从最近的revision重新监听，revision被压缩时全量重新加载，直到收到cancelC
 func (n *ng) Subscribe(changes chan interface{}, cancelC chan struct{}) error {
-	watcher := etcd.NewWatcher(n.client)
-	defer watcher.Close()
-	defer close(cancelC)
+	ctx, cancel := context.WithCancel(n.context)
+	defer cancel()
+	go func() {
+		select {
+		case <-cancelC:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
 	log.Printf("[broccoli] [engine.Subscribe] Begin watching etcd configpath: %s\n", n.entry.ConfigPath)
-	rch := watcher.Watch(n.context, n.entry.ConfigPath, etcd.WithPrefix(), etcd.WithPrevKV())
-	for wresp := range rch {
-		if wresp.Canceled {
-			log.Println("[broccoli] [engine.Subscribe] Stop watching: graceful shutdown")
+	retryDelay := defaultRetryDelay
+	for {
+		err := n.watch(ctx, changes)
+		if ctx.Err() != nil {
+			log.Printf("[broccoli] [engine.Subscribe] cancel watch config: %s\n", n.entry.ConfigPath)
 			return nil
 		}
+		n.setWatchError(err)
+		if err == rpctypes.ErrCompacted {
+			log.Printf("[broccoli] [engine.Subscribe] revision %d has been compacted, reload all config\n", n.revision+1)
+			if err = n.reloadAll(changes); err == nil {
+				retryDelay = defaultRetryDelay
+				continue
+			}
+		}
+		log.Printf("[broccoli] [engine.Subscribe] watch error: %s, rewatch from revision %d after %s\n", err, n.revision+1, retryDelay)
+		select {
+		case <-time.After(retryDelay):
+		case <-ctx.Done():
+			log.Printf("[broccoli] [engine.Subscribe] cancel watch config: %s\n", n.entry.ConfigPath)
+			return nil
+		}
+		if retryDelay *= 2; retryDelay > maxRetryDelay {
+			retryDelay = maxRetryDelay
+		}
+	}
+}
+
+// watch 从 n.revision+1 开始监听，直到出错或ctx结束
+func (n *ng) watch(ctx context.Context, changes chan interface{}) error {
+	// 没有leader时立即返回错误，避免网络分区时静默地收不到变化
+	wctx, wcancel := context.WithCancel(etcd.WithRequireLeader(ctx))
+	defer wcancel()
+	watcher := etcd.NewWatcher(n.client)
+	defer watcher.Close()
+
+	opts := []etcd.OpOption{etcd.WithPrefix(), etcd.WithPrevKV(), etcd.WithRev(n.revision + 1)}
+	rch := watcher.Watch(wctx, n.entry.ConfigPath, opts...)
+	var cch etcd.WatchChan // 未配置公共路径时为nil，不会被选中
+	if !utils.IsEmptyString(n.entry.CommonPath) {
+		cch = watcher.Watch(wctx, n.entry.CommonPath, opts...)
+	}
+	for {
+		var wresp etcd.WatchResponse
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case wresp, ok = <-rch:
+		case wresp, ok = <-cch:
+		}
+		if !ok {
+			return errors.New("watch channel closed")
+		}
 		if err := wresp.Err(); err != nil {
-			log.Printf("[broccoli] [engine.Subscribe] Stop watching: error: %v\n", err)
 			return err
 		}
+		if wresp.Canceled {
+			return errors.New("watch canceled by server")
+		}
+		n.setWatchOK(wresp.Header.Revision, len(wresp.Events) > 0)
+		changed := false
 		for _, ev := range wresp.Events {
-			if string(ev.Kv.Value) == string(ev.PrevKv.Value) {
-				log.Println("[zeus] [engine.Subscribe] config content no changed")
-				continue
-			}
-			change, err := n.parseChange(ev)
-			if err != nil {
-				log.Printf("[broccoli] [engine.Subscribe] ignore '%s', error: %s\n", eventToString(ev), err)
-				continue
-			}
-			if change != nil {
-				log.Printf("[broccoli] [engine.Subscribe] configPath: %s change\n", n.entry.ConfigPath)
-				select {
-				case changes <- change:
-				case <-cancelC:
-					log.Printf("[broccoli] [engine.Subscribe] cancel watch config: %s\n", n.entry.ConfigPath)
-					return nil
-				default: // 防止忘记消费changes导致一直阻塞
-					log.Printf("[broccoli] [engine.Subscribe] channel is blocked, can not push change into changes")
-				}
+			if n.applyEvent(ev) {
+				changed = true
 			}
 		}
+		if !changed {
+			continue
+		}
+		if err := n.refreshTree(); err != nil {
+			log.Printf("[broccoli] [engine.Subscribe] ignore change of %s, error: %s\n", n.entry.ConfigPath, err)
+			continue
+		}
+		log.Printf("[broccoli] [engine.Subscribe] configPath: %s change\n", n.entry.ConfigPath)
+		n.push(changes)
+	}
+}
+
+// reloadAll 全量读取配置树，有变化时刷新配置
+func (n *ng) reloadAll(changes chan interface{}) error {
+	kvs, rev, err := n.getTree(n.entry.ConfigPath)
+	if err != nil {
+		return err
+	}
+	commonKvs := make(map[string][]byte)
+	if !utils.IsEmptyString(n.entry.CommonPath) {
+		if commonKvs, _, err = n.getTree(n.entry.CommonPath); err != nil {
+			return err
+		}
+	}
+	n.setWatchOK(rev, false)
+	if reflect.DeepEqual(kvs, n.kvs) && reflect.DeepEqual(commonKvs, n.commonKvs) {
+		return nil
 	}
+	n.kvs, n.commonKvs = kvs, commonKvs
+	if err = n.refreshTree(); err != nil {
+		log.Printf("[broccoli] [engine.Subscribe] ignore change of %s, error: %s\n", n.entry.ConfigPath, err)
+		return nil
+	}
+	log.Printf("[broccoli] [engine.Subscribe] configPath: %s change\n", n.entry.ConfigPath)
+	n.push(changes)
 	return nil
 }
 
-func eventToString(e *etcd.Event) string {
-	return fmt.Sprintf("%s: %v -> %v", e.Type, e.PrevKv, e.Kv)
+func (n *ng) push(changes chan interface{}) {
+	select {
+	case changes <- n.configer:
+	default: // 防止忘记消费changes导致一直阻塞
+		log.Printf("[broccoli] [engine.Subscribe] channel is blocked, can not push change into changes")
+	}
 }
 
-// MatcherFn 匹配事件操作
-type MatcherFn func(*etcd.Event) (interface{}, error)
+// WatchHealth 监听状态
+func (n *ng) WatchHealth() engine.WatchHealth {
+	n.healthMu.RLock()
+	defer n.healthMu.RUnlock()
+	return n.health
+}
 
-func (n *ng) parseChange(e *etcd.Event) (interface{}, error) {
-	matchers := []MatcherFn{
-		n.parseConfigChange,
+func (n *ng) setWatchOK(revision int64, event bool) {
+	n.healthMu.Lock()
+	defer n.healthMu.Unlock()
+	if revision > n.revision {
+		n.revision = revision
 	}
-	for _, matcher := range matchers {
-		m, err := matcher(e)
-		if m != nil || err != nil {
-			return m, err
-		}
+	n.health.Healthy = true
+	n.health.Revision = n.revision
+	n.health.Retries = 0
+	if event {
+		n.health.LastEvent = time.Now()
 	}
-	return nil, nil
 }
 
-func (n *ng) parseConfigChange(e *etcd.Event) (interface{}, error) {
-	if string(e.Kv.Key) == n.entry.ConfigPath {
-		switch e.Type {
-		case etcd.EventTypePut:
-			err := n.refreshConfig(e.Kv.Value)
-			if err != nil {
-				return e, err
-			}
-			// TODO: 考虑放在service处理
-			// 重新加载容器组件
-			// n.container.Reload(n.configer.Get())
-			return n.configer, nil
-		case etcd.EventTypeDelete:
-			return nil, nil
+func (n *ng) setWatchError(err error) {
+	n.healthMu.Lock()
+	defer n.healthMu.Unlock()
+	n.health.Healthy = false
+	n.health.Retries++
+	if err != nil {
+		n.health.LastError = err.Error()
+	}
+	n.health.ErrorTime = time.Now()
+}
+
+func eventToString(e *etcd.Event) string {
+	return fmt.Sprintf("%s: %v -> %v", e.Type, e.PrevKv, e.Kv)
+}
+
+// applyEvent 将事件应用到对应的配置树，返回配置树是否发生变化
+func (n *ng) applyEvent(e *etcd.Event) bool {
+	key := string(e.Kv.Key)
+	var tree map[string][]byte
+	switch {
+	case config.InTree(n.entry.ConfigPath, key):
+		tree = n.kvs
+	case !utils.IsEmptyString(n.entry.CommonPath) && config.InTree(n.entry.CommonPath, key):
+		tree = n.commonKvs
+	default:
+		return false
+	}
+	switch e.Type {
+	case etcd.EventTypePut:
+		if e.PrevKv != nil && string(e.Kv.Value) == string(e.PrevKv.Value) {
+			log.Println("[broccoli] [engine.Subscribe] config content no changed")
+			return false
+		}
+		tree[key] = e.Kv.Value
+		return true
+	case etcd.EventTypeDelete:
+		if _, ok := tree[key]; !ok {
+			return false
 		}
-		return nil, fmt.Errorf("unsupported action on the: %v %v", e.Kv.Key, e.Type)
+		delete(tree, key)
+		return true
 	}
-	return nil, nil
+	log.Printf("[broccoli] [engine.Subscribe] ignore '%s', unsupported action\n", eventToString(e))
+	return false
 }
@@ -0,0 +1,211 @@
+package etcd
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/embed"
+
+	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/engine"
+)
+
+var (
+	_ engine.Historian           = (*ng)(nil)
+	_ engine.WatchHealthReporter = (*ng)(nil)
+)
+
+const configPath = "/config/app"
+
+// startEtcd 启动单节点的嵌入式etcd，返回客户端地址
+func startEtcd(t *testing.T) (endpoint string, stop func()) {
+	dir, err := ioutil.TempDir("", "etcdng")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cu, pu := freeURL(t), freeURL(t)
+	cfg.LCUrls, cfg.ACUrls = []url.URL{cu}, []url.URL{cu}
+	cfg.LPUrls, cfg.APUrls = []url.URL{pu}, []url.URL{pu}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		e.Close()
+		os.RemoveAll(dir)
+		t.Fatal("etcd not ready")
+	}
+	return cu.String(), func() {
+		e.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func freeURL(t *testing.T) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+// newEngine 写入初始配置后创建engine
+func newEngine(t *testing.T, endpoint, host string) *ng {
+	entry := &config.Entry{
+		EngineType:   "etcd",
+		ConfigPath:   configPath,
+		ConfigFormat: config.FormatJSON,
+		EndPoints:    []string{endpoint},
+	}
+	e, err := New(entry, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := e.(*ng)
+	put(t, n, host)
+	if err = n.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// put 写入配置，返回写入后的revision
+func put(t *testing.T, n *ng, host string) int64 {
+	t.Helper()
+	resp, err := n.client.Put(context.Background(), configPath, `{"redis":{"host":"`+host+`"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Header.Revision
+}
+
+// subscribe 开始监听，返回的next等待推送的配置直到host为指定值
+func subscribe(t *testing.T, n *ng) (next func(host string) config.Configer, stop func()) {
+	changes := make(chan interface{}, 10)
+	cancelC := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		n.Subscribe(changes, cancelC)
+		close(exited)
+	}()
+	next = func(host string) config.Configer {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case c := <-changes:
+				if c.(config.Configer).Get().Redis.Host == host {
+					return c.(config.Configer)
+				}
+			case <-timeout:
+				t.Fatalf("no change with host %s received", host)
+				return nil
+			}
+		}
+	}
+	stop = func() {
+		close(cancelC)
+		select {
+		case <-exited:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Subscribe did not exit after cancel")
+		}
+		n.client.Close()
+	}
+	return
+}
+
+func TestSubscribeResume(t *testing.T) {
+	endpoint, stopEtcd := startEtcd(t)
+	defer stopEtcd()
+	n := newEngine(t, endpoint, "a")
+	if h := n.WatchHealth(); !h.Healthy || h.Revision == 0 {
+		t.Fatalf("init health = %+v", h)
+	}
+
+	// 未监听期间的修改，从上次的revision继续监听时收到
+	put(t, n, "b")
+	rev := put(t, n, "c")
+	next, stop := subscribe(t, n)
+	defer stop()
+	next("c")
+	if h := n.WatchHealth(); !h.Healthy || h.Revision < rev || h.LastEvent.IsZero() {
+		t.Errorf("health = %+v, want healthy at revision %d with event", h, rev)
+	}
+
+	rev = put(t, n, "d")
+	next("d")
+	if h := n.WatchHealth(); h.Revision < rev {
+		t.Errorf("revision = %d, want %d", h.Revision, rev)
+	}
+}
+
+func TestSubscribeCompacted(t *testing.T) {
+	endpoint, stopEtcd := startEtcd(t)
+	defer stopEtcd()
+	n := newEngine(t, endpoint, "a")
+
+	// 上次的revision已被压缩，全量重新加载
+	put(t, n, "b")
+	rev := put(t, n, "c")
+	if _, err := n.client.Compact(context.Background(), rev); err != nil {
+		t.Fatal(err)
+	}
+	next, stop := subscribe(t, n)
+	defer stop()
+	next("c")
+	h := n.WatchHealth()
+	if !h.Healthy || h.Revision < rev || h.Retries != 0 {
+		t.Errorf("health = %+v, want healthy at revision %d", h, rev)
+	}
+	if !strings.Contains(h.LastError, "compacted") {
+		t.Errorf("last error = %q, want compacted", h.LastError)
+	}
+
+	// 重新加载后继续监听
+	put(t, n, "d")
+	next("d")
+}
+
+func TestRollback(t *testing.T) {
+	endpoint, stopEtcd := startEtcd(t)
+	defer stopEtcd()
+	n := newEngine(t, endpoint, "a")
+	next, stop := subscribe(t, n)
+	defer stop()
+	put(t, n, "b")
+	next("b")
+
+	// 回滚写回etcd，由监听生效
+	if err := n.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	next("a")
+	if c, _ := n.GetConfiger(); c.Get().Redis.Host != "a" {
+		t.Errorf("rollback host = %q, want a", c.Get().Redis.Host)
+	}
+}
+
+func TestClientConfig(t *testing.T) {
+	c, err := clientConfig(&config.Entry{EndPoints: []string{"127.0.0.1:2379"}})
+	if err != nil || c.TLS != nil {
+		t.Errorf("plain config = %+v, %v", c.TLS, err)
+	}
+	// 证书文件不存在时返回错误
+	if _, err = clientConfig(&config.Entry{CertFile: "missing.crt", KeyFile: "missing.key"}); err == nil {
+		t.Error("missing cert file should fail")
+	}
+}
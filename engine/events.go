@@ -1,3 +1,290 @@
 package engine
 
-// type LogConfUpdated struct {}
\ No newline at end of file
+import (
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/elvisNg/broccoli/config"
+)
+
+// 配置分区，与AppConf的json字段名一致
+const (
+	SectionLogConf       = "log_conf"
+	SectionRedis         = "redis"
+	SectionRedisSource   = "redis_source"
+	SectionMongoDB       = "mongodb"
+	SectionMongoDBSource = "mongodb_source"
+	SectionMysql         = "mysql"
+	SectionMysqlSource   = "mysql_source"
+	SectionBroker        = "broker"
+	SectionBrokerSource  = "broker_source"
+	SectionEBus          = "ebus"
+	SectionExt           = "ext"
+	SectionTrace         = "trace"
+	SectionObs           = "obs"
+	SectionGoMicro       = "go_micro"
+	SectionComponents    = "components"
+	SectionHealth        = "health"
+	SectionBusIdSpIdMap  = "current_busid_spid_map"
+)
+
+// Event 配置分区变化事件
+type Event interface {
+	Section() string
+}
+
+type LogConfChanged struct{ Old, New config.LogConf }
+
+type RedisChanged struct{ Old, New config.Redis }
+
+// RedisSourceChanged 新增时Old为nil，删除时New为nil
+type RedisSourceChanged struct {
+	Name     string
+	Old, New *config.Redis
+}
+
+type MongoDBChanged struct{ Old, New config.MongoDB }
+
+// MongoDBSourceChanged 新增时Old为nil，删除时New为nil
+type MongoDBSourceChanged struct {
+	Name     string
+	Old, New *config.MongoDB
+}
+
+type MysqlChanged struct{ Old, New config.Mysql }
+
+// MysqlSourceChanged 新增时Old为nil，删除时New为nil
+type MysqlSourceChanged struct {
+	Name     string
+	Old, New *config.Mysql
+}
+
+type BrokerChanged struct{ Old, New config.Broker }
+
+// BrokerSourceChanged 新增时Old为nil，删除时New为nil
+type BrokerSourceChanged struct {
+	Name     string
+	Old, New *config.Broker
+}
+
+type EBusChanged struct{ Old, New config.EBus }
+
+type TraceChanged struct{ Old, New config.Trace }
+
+type ObsChanged struct{ Old, New config.Obs }
+
+type GoMicroChanged struct{ Old, New config.GoMicro }
+
+// ExtKeyChanged Ext中单个key的变化，新增时Old为nil，删除时New为nil
+type ExtKeyChanged struct {
+	Key      string
+	Old, New interface{}
+}
+
+type HealthChanged struct{ Old, New config.Health }
+
+type BusIdSpIdMapChanged struct{ Old, New map[string]string }
+
+// ComponentChanged Components中单个组件配置的变化，新增时Old为nil，删除时New为nil
+type ComponentChanged struct {
+	Key      string
+	Old, New interface{}
+}
+
+func (LogConfChanged) Section() string       { return SectionLogConf }
+func (RedisChanged) Section() string         { return SectionRedis }
+func (RedisSourceChanged) Section() string   { return SectionRedisSource }
+func (MongoDBChanged) Section() string       { return SectionMongoDB }
+func (MongoDBSourceChanged) Section() string { return SectionMongoDBSource }
+func (MysqlChanged) Section() string         { return SectionMysql }
+func (MysqlSourceChanged) Section() string   { return SectionMysqlSource }
+func (BrokerChanged) Section() string        { return SectionBroker }
+func (BrokerSourceChanged) Section() string  { return SectionBrokerSource }
+func (EBusChanged) Section() string          { return SectionEBus }
+func (TraceChanged) Section() string         { return SectionTrace }
+func (ObsChanged) Section() string           { return SectionObs }
+func (GoMicroChanged) Section() string       { return SectionGoMicro }
+func (ExtKeyChanged) Section() string        { return SectionExt }
+func (ComponentChanged) Section() string     { return SectionComponents }
+func (HealthChanged) Section() string        { return SectionHealth }
+func (BusIdSpIdMapChanged) Section() string  { return SectionBusIdSpIdMap }
+
+// DiffEvents 比较新旧配置，返回各分区的变化事件
+func DiffEvents(old, new *config.AppConf) (events []Event) {
+	if old == nil {
+		old = &config.AppConf{}
+	}
+	if new == nil {
+		new = &config.AppConf{}
+	}
+	changed := func(a, b interface{}) bool {
+		return !reflect.DeepEqual(a, b)
+	}
+	if changed(old.LogConf, new.LogConf) {
+		events = append(events, LogConfChanged{Old: old.LogConf, New: new.LogConf})
+	}
+	if changed(old.Redis, new.Redis) {
+		events = append(events, RedisChanged{Old: old.Redis, New: new.Redis})
+	}
+	for _, name := range unionKeys(old.RedisSource, new.RedisSource) {
+		o, ook := old.RedisSource[name]
+		n, nok := new.RedisSource[name]
+		if ook && nok && !changed(o, n) {
+			continue
+		}
+		ev := RedisSourceChanged{Name: name}
+		if ook {
+			ev.Old = &o
+		}
+		if nok {
+			ev.New = &n
+		}
+		events = append(events, ev)
+	}
+	if changed(old.MongoDB, new.MongoDB) {
+		events = append(events, MongoDBChanged{Old: old.MongoDB, New: new.MongoDB})
+	}
+	for _, name := range unionKeys(old.MongoDBSource, new.MongoDBSource) {
+		o, ook := old.MongoDBSource[name]
+		n, nok := new.MongoDBSource[name]
+		if ook && nok && !changed(o, n) {
+			continue
+		}
+		ev := MongoDBSourceChanged{Name: name}
+		if ook {
+			ev.Old = &o
+		}
+		if nok {
+			ev.New = &n
+		}
+		events = append(events, ev)
+	}
+	if changed(old.Mysql, new.Mysql) {
+		events = append(events, MysqlChanged{Old: old.Mysql, New: new.Mysql})
+	}
+	for _, name := range unionKeys(old.MysqlSource, new.MysqlSource) {
+		o, ook := old.MysqlSource[name]
+		n, nok := new.MysqlSource[name]
+		if ook && nok && !changed(o, n) {
+			continue
+		}
+		ev := MysqlSourceChanged{Name: name}
+		if ook {
+			ev.Old = &o
+		}
+		if nok {
+			ev.New = &n
+		}
+		events = append(events, ev)
+	}
+	if changed(old.Broker, new.Broker) {
+		events = append(events, BrokerChanged{Old: old.Broker, New: new.Broker})
+	}
+	for _, name := range unionKeys(old.BrokerSource, new.BrokerSource) {
+		o, ook := old.BrokerSource[name]
+		n, nok := new.BrokerSource[name]
+		if ook && nok && !changed(o, n) {
+			continue
+		}
+		ev := BrokerSourceChanged{Name: name}
+		if ook {
+			ev.Old = &o
+		}
+		if nok {
+			ev.New = &n
+		}
+		events = append(events, ev)
+	}
+	if changed(old.EBus, new.EBus) {
+		events = append(events, EBusChanged{Old: old.EBus, New: new.EBus})
+	}
+	if changed(old.Trace, new.Trace) {
+		events = append(events, TraceChanged{Old: old.Trace, New: new.Trace})
+	}
+	if changed(old.Obs, new.Obs) {
+		events = append(events, ObsChanged{Old: old.Obs, New: new.Obs})
+	}
+	if changed(old.GoMicro, new.GoMicro) {
+		events = append(events, GoMicroChanged{Old: old.GoMicro, New: new.GoMicro})
+	}
+	if changed(old.Health, new.Health) {
+		events = append(events, HealthChanged{Old: old.Health, New: new.Health})
+	}
+	// nil和空map视为相同
+	if (len(old.CurrentBusIdSpIdMap) > 0 || len(new.CurrentBusIdSpIdMap) > 0) && changed(old.CurrentBusIdSpIdMap, new.CurrentBusIdSpIdMap) {
+		events = append(events, BusIdSpIdMapChanged{Old: old.CurrentBusIdSpIdMap, New: new.CurrentBusIdSpIdMap})
+	}
+	for _, key := range unionKeys(old.Ext, new.Ext) {
+		o, ook := old.Ext[key]
+		n, nok := new.Ext[key]
+		if ook && nok && !changed(o, n) {
+			continue
+		}
+		events = append(events, ExtKeyChanged{Key: key, Old: o, New: n})
+	}
+	for _, key := range unionKeys(old.Components, new.Components) {
+		o, ook := old.Components[key]
+		n, nok := new.Components[key]
+		if ook && nok && !changed(o, n) {
+			continue
+		}
+		events = append(events, ComponentChanged{Key: key, Old: o, New: n})
+	}
+	return
+}
+
+// unionKeys 两个map键的并集，已排序
+func unionKeys(a, b interface{}) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range []interface{}{a, b} {
+		for _, k := range reflect.ValueOf(m).MapKeys() {
+			if !seen[k.String()] {
+				seen[k.String()] = true
+				keys = append(keys, k.String())
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// EventHandler 配置事件处理函数
+type EventHandler func(ev Event)
+
+// EventBus 按分区分发配置事件
+type EventBus struct {
+	rw       sync.RWMutex
+	handlers map[string][]EventHandler
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		handlers: make(map[string][]EventHandler),
+	}
+}
+
+// Subscribe 订阅指定分区的事件，section为空则订阅所有事件
+func (b *EventBus) Subscribe(section string, fn EventHandler) {
+	if fn == nil {
+		return
+	}
+	b.rw.Lock()
+	defer b.rw.Unlock()
+	b.handlers[section] = append(b.handlers[section], fn)
+}
+
+// Publish 按顺序同步分发事件，处理函数在锁外调用，可在其中 Subscribe
+func (b *EventBus) Publish(events ...Event) {
+	for _, ev := range events {
+		b.rw.RLock()
+		handlers := make([]EventHandler, 0, len(b.handlers[ev.Section()])+len(b.handlers[""]))
+		handlers = append(handlers, b.handlers[ev.Section()]...)
+		handlers = append(handlers, b.handlers[""]...)
+		b.rw.RUnlock()
+		for _, fn := range handlers {
+			fn(ev)
+		}
+	}
+}
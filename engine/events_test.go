@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/elvisNg/broccoli/config"
+)
+
+func TestDiffEvents(t *testing.T) {
+	old := &config.AppConf{
+		Redis:       config.Redis{Host: "a"},
+		RedisSource: map[string]config.Redis{"cache": {Host: "c1"}, "gone": {Host: "g"}},
+		Broker:      config.Broker{Hosts: []string{"h1"}},
+		Ext:         map[string]interface{}{"same": 1, "k": "v1"},
+		Components:  map[string]interface{}{"es": map[string]interface{}{"url": "a"}},
+	}
+	new := &config.AppConf{
+		Redis:       config.Redis{Host: "b"},
+		RedisSource: map[string]config.Redis{"cache": {Host: "c1"}, "added": {Host: "n"}},
+		Broker:      config.Broker{Hosts: []string{"h1", "h2"}},
+		Ext:         map[string]interface{}{"same": 1, "k": "v2"},
+		Components:  map[string]interface{}{"es": map[string]interface{}{"url": "b"}},
+	}
+	events := DiffEvents(old, new)
+	var sections []string
+	for _, ev := range events {
+		sections = append(sections, ev.Section())
+	}
+	want := []string{SectionRedis, SectionRedisSource, SectionRedisSource, SectionBroker, SectionExt, SectionComponents}
+	if !reflect.DeepEqual(sections, want) {
+		t.Fatalf("DiffEvents() sections = %v, want %v", sections, want)
+	}
+	if ev := events[1].(RedisSourceChanged); ev.Name != "added" || ev.Old != nil || ev.New.Host != "n" {
+		t.Errorf("added source event = %+v", ev)
+	}
+	if ev := events[2].(RedisSourceChanged); ev.Name != "gone" || ev.New != nil || ev.Old.Host != "g" {
+		t.Errorf("removed source event = %+v", ev)
+	}
+	if ev := events[4].(ExtKeyChanged); ev.Key != "k" || ev.Old != "v1" || ev.New != "v2" {
+		t.Errorf("ext event = %+v", ev)
+	}
+	if ev := events[5].(ComponentChanged); ev.Key != "es" || ev.Old == nil || ev.New == nil {
+		t.Errorf("component event = %+v", ev)
+	}
+	if events := DiffEvents(old, old); len(events) != 0 {
+		t.Errorf("DiffEvents() on same config = %v", events)
+	}
+
+	next := &config.AppConf{
+		Health:              config.Health{Timeout: 500},
+		CurrentBusIdSpIdMap: map[string]string{"1": "2"},
+	}
+	sections = nil
+	for _, ev := range DiffEvents(&config.AppConf{CurrentBusIdSpIdMap: map[string]string{}}, next) {
+		sections = append(sections, ev.Section())
+	}
+	if want := []string{SectionHealth, SectionBusIdSpIdMap}; !reflect.DeepEqual(sections, want) {
+		t.Errorf("DiffEvents() sections = %v, want %v", sections, want)
+	}
+	if events := DiffEvents(&config.AppConf{CurrentBusIdSpIdMap: map[string]string{}}, &config.AppConf{}); len(events) != 0 {
+		t.Errorf("DiffEvents() empty map to nil = %v", events)
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	b := NewEventBus()
+	var redis, all int
+	b.Subscribe(SectionRedis, func(ev Event) { redis++ })
+	b.Subscribe("", func(ev Event) { all++ })
+	b.Publish(RedisChanged{}, MysqlChanged{}, ExtKeyChanged{Key: "k"})
+	if redis != 1 || all != 3 {
+		t.Errorf("redis = %d, all = %d, want 1, 3", redis, all)
+	}
+
+	// 处理函数中可以订阅，新订阅从下一个事件开始生效
+	var late int
+	b.Subscribe(SectionMysql, func(ev Event) {
+		b.Subscribe(SectionMysql, func(ev Event) { late++ })
+	})
+	b.Publish(MysqlChanged{}, MysqlChanged{})
+	if late != 1 {
+		t.Errorf("late = %d, want 1", late)
+	}
+}
@@ -3,16 +3,28 @@ package file
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
+
 	"github.com/elvisNg/broccoli/config"
 	"github.com/elvisNg/broccoli/engine"
 	"github.com/elvisNg/broccoli/plugin/zcontainer"
 	"github.com/elvisNg/broccoli/utils"
 )
 
+const (
+	defaultDebounce = 300 * time.Millisecond
+	// rollbackTimeout 回滚时等待changes可写入的最长时间
+	rollbackTimeout = 5 * time.Second
+)
+
 // ng fileengine
 type ng struct {
 	entry                *config.Entry
@@ -22,30 +34,60 @@ type ng struct {
 	context              context.Context
 	cancelFunc           context.CancelFunc
 	options              *Options
+
+	path     string // 配置文件的绝对路径
+	realPath string // 配置文件软链接解析后的路径
+
+	mu      sync.RWMutex
+	history *config.History
+	pinned  bool             // 已回滚到历史版本，配置文件再次变化时解除
+	changes chan interface{} // Subscribe 传入的changes，用于推送回滚
 }
 
 type Options struct {
-	context context.Context
+	context  context.Context
+	debounce time.Duration
 }
 
 type Option func(o *Options)
 
+// WithDebounce 文件变化的合并时间窗口，窗口内的多次变化只触发一次重新加载
+func WithDebounce(d time.Duration) Option {
+	return func(o *Options) {
+		o.debounce = d
+	}
+}
+
 func New(entry *config.Entry, container zcontainer.Container, opts ...Option) (engine.Engine, error) {
 	n := &ng{
 		entry:     entry,
 		container: container,
-		options:   &Options{},
+		options: &Options{
+			debounce: defaultDebounce,
+		},
+		history: config.NewHistory(config.DefaultHistorySize),
 	}
 	for _, o := range opts {
 		o(n.options)
 	}
-
+	if utils.IsEmptyString(entry.ConfigPath) {
+		msg := "[broccoli] [engine.New] 配置路径不能为空"
+		log.Println(msg)
+		return nil, errors.New(msg)
+	}
+	path, err := filepath.Abs(entry.ConfigPath)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	n.path = path
+	n.context, n.cancelFunc = context.WithCancel(context.Background())
 	return n, nil
 }
 
 func (n *ng) Init() (err error) {
-	// ��ȡ����
-	d, err := ioutil.ReadFile(n.entry.ConfigPath)
+	// 读取配置
+	d, err := ioutil.ReadFile(n.path)
 	if err != nil {
 		return
 	}
@@ -53,86 +95,223 @@ func (n *ng) Init() (err error) {
 	if err = n.refreshConfig(d); err != nil {
 		return
 	}
-
+	n.resolveRealPath()
 	return nil
 }
 
+// Subscribe 监听配置文件及其所在目录的变化
+// 监听目录以兼容编辑器的原子替换（写临时文件后rename）以及k8s ConfigMap的软链接切换
 func (n *ng) Subscribe(changes chan interface{}, cancelC chan struct{}) error {
-	for {
-		time.Sleep(10 * time.Second)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("[broccoli] [engine.Subscribe] new watcher error: %s\n", err)
+		return err
+	}
+	defer watcher.Close()
+	defer n.cancelFunc()
+	n.mu.Lock()
+	n.changes = changes
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		n.changes = nil
+		n.mu.Unlock()
+	}()
 
-		d, err := ioutil.ReadFile(n.entry.ConfigPath)
-		if err != nil {
-			log.Printf("[zeus] [engine.Subscribe] error: %s\n", err)
-			continue
+	watchedDirs := make(map[string]bool)
+	watchDirs := func() error {
+		dirs := make(map[string]bool)
+		for _, p := range []string{n.path, n.realPath} {
+			if !utils.IsEmptyString(p) {
+				dirs[filepath.Dir(p)] = true
+			}
 		}
-		// TODO: ����ļ������޸�
-		if string(d) == "" || string(n.prevRawConfigContent) == string(d) {
-			continue
+		for dir := range dirs {
+			if watchedDirs[dir] {
+				continue
+			}
+			if err := watcher.Add(dir); err != nil {
+				return err
+			}
+			watchedDirs[dir] = true
 		}
-		if err = n.refreshConfig(d); err != nil {
-			log.Printf("[zeus] [engine.Subscribe] ignore '%s', error: %s\n", string(d), err)
-			continue
+		// 软链接切换后不再监听原来指向的目录，目录可能已被删除，忽略错误
+		for dir := range watchedDirs {
+			if !dirs[dir] {
+				watcher.Remove(dir)
+				delete(watchedDirs, dir)
+			}
 		}
-		if n.configer != nil {
-			log.Printf("[zeus] [engine.Subscribe] configPath: %s change\n", n.entry.ConfigPath)
+		return nil
+	}
+	if err = watchDirs(); err != nil {
+		log.Printf("[broccoli] [engine.Subscribe] watch dir error: %s\n", err)
+		return err
+	}
+	log.Printf("[broccoli] [engine.Subscribe] Begin watching file configpath: %s\n", n.path)
+
+	debounce := time.NewTimer(n.options.debounce)
+	if !debounce.Stop() {
+		<-debounce.C
+	}
+	for {
+		select {
+		case <-cancelC:
+			log.Printf("[broccoli] [engine.Subscribe] cancel watch config: %s\n", n.path)
+			debounce.Stop()
+			return nil
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !n.relevant(ev) {
+				continue
+			}
+			debounce.Reset(n.options.debounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("[broccoli] [engine.Subscribe] watcher error: %s\n", err)
+		case <-debounce.C:
+			changed, err := n.reload()
+			if err != nil {
+				log.Printf("[broccoli] [engine.Subscribe] ignore change, error: %s\n", err)
+				continue
+			}
+			// 软链接切换后需要监听新的目录
+			if err := watchDirs(); err != nil {
+				log.Printf("[broccoli] [engine.Subscribe] watch dir error: %s\n", err)
+			}
+			if !changed {
+				continue
+			}
+			log.Printf("[broccoli] [engine.Subscribe] configPath: %s change\n", n.path)
 			select {
-			case changes <- n.configer:
+			case changes <- n.currentConfiger():
 			case <-cancelC:
-				log.Printf("[zeus] [engine.Subscribe] cancel watch config: %s\n", n.entry.ConfigPath)
+				log.Printf("[broccoli] [engine.Subscribe] cancel watch config: %s\n", n.path)
 				return nil
-			default: // ��ֹ��������changes����һֱ����
-				log.Printf("[zeus] [engine.Subscribe] channel is blocked, can not push change into changes")
+			default: // 防止忘记消费changes导致一直阻塞
+				log.Printf("[broccoli] [engine.Subscribe] channel is blocked, can not push change into changes")
 			}
 		}
 	}
-	return nil
+}
+
+// relevant 判断事件是否与配置文件相关
+// ConfigMap 通过替换目录下以".."开头的软链接完成更新
+func (n *ng) relevant(ev fsnotify.Event) bool {
+	name := filepath.Clean(ev.Name)
+	if name == n.path || name == n.realPath {
+		return true
+	}
+	return strings.HasPrefix(filepath.Base(name), "..")
+}
+
+// reload 重新读取配置文件，内容无变化时不刷新
+func (n *ng) reload() (changed bool, err error) {
+	d, err := ioutil.ReadFile(n.path)
+	if err != nil {
+		return
+	}
+	n.resolveRealPath()
+	n.mu.RLock()
+	same := string(n.prevRawConfigContent) == string(d)
+	n.mu.RUnlock()
+	if utils.IsEmptyString(string(d)) || same {
+		return
+	}
+	if err = n.refreshConfig(d); err != nil {
+		return
+	}
+	changed = true
+	return
+}
+
+func (n *ng) resolveRealPath() {
+	realPath, err := filepath.EvalSymlinks(n.path)
+	if err != nil {
+		return
+	}
+	n.realPath = realPath
 }
 
 func (n *ng) GetConfiger() (config.Configer, error) {
-	return n.configer, nil
+	return n.currentConfiger(), nil
+}
+
+func (n *ng) currentConfiger() config.Configer {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.configer
 }
 
 func (n *ng) GetContainer() zcontainer.Container {
 	return n.container
 }
 
-// refreshConfig ˢ�����ã�ʧ������ԭ�����ã���Ӱ�쵱ǰ������
-func (n *ng) refreshConfig(content []byte) (err error) {
-	log.Printf("[zeus] [engine.refreshConfig] configpath: %s��configcontent: %s\n", n.entry.ConfigPath, string(content))
-	configFormat := n.entry.ConfigFormat
-	if utils.IsEmptyString(configFormat) {
-		configFormat = "json"
-	}
-	var configer config.Configer
-	switch configFormat {
-	case "json":
-		jsoner := &config.Jsoner{}
-		err = jsoner.Init(content)
-		if err != nil {
-			msg := "[zeus] [engine.refreshConfig] jsoner ��������ʧ�ܣ�configpath: " + n.entry.ConfigPath
-			log.Println(msg)
-			return
+func (n *ng) History() *config.History {
+	return n.history
+}
+
+// Rollback 在本地锁定指定版本，不修改配置文件
+// 配置文件再次变化时解除锁定，以文件内容为准
+// 监听中时推送到changes，rollbackTimeout 内无法推送时返回错误，不锁定
+func (n *ng) Rollback(version int) error {
+	v, err := n.history.Get(version)
+	if err != nil {
+		return err
+	}
+	n.mu.RLock()
+	configer, err := config.Load(n.entry, v.Content, n.configer)
+	changes := n.changes
+	n.mu.RUnlock()
+	if err != nil {
+		log.Printf("[broccoli] [engine.Rollback] 回滚失败，configpath: %s，version: %d，err: %s\n", n.entry.ConfigPath, version, err)
+		return err
+	}
+	if changes != nil {
+		select {
+		case changes <- configer:
+		case <-time.After(rollbackTimeout):
+			log.Printf("[broccoli] [engine.Rollback] channel is blocked, can not push change into changes")
+			return fmt.Errorf("rollback to version %d: changes channel is blocked", version)
 		}
-		configer = jsoner
-	case "toml":
-		msg := "[zeus] [engine.refreshConfig] toml:��֧�ֵ����ø�ʽ��configpath: " + n.entry.ConfigPath
-		log.Println(msg)
-		err = errors.New(msg)
-		return
-	default:
-		msg := "[zeus] [engine.refreshConfig] " + configFormat + ":��֧�ֵ����ø�ʽ��configpath: " + n.entry.ConfigPath
-		log.Println(msg)
-		err = errors.New(msg)
-		return
 	}
+	n.mu.Lock()
+	n.configer = configer
+	n.pinned = true
+	n.history.Add(config.Version{
+		Format:  n.entry.ConfigFormat,
+		Content: v.Content,
+		Pinned:  true,
+	}, configer.Get())
+	n.mu.Unlock()
+	log.Printf("[broccoli] [engine.Rollback] 已回滚并锁定，configpath: %s，version: %d\n", n.entry.ConfigPath, version)
+	return nil
+}
 
-	if configer != nil {
-		log.Printf("[zeus] [engine.refreshConfig] ˢ�����óɹ���configpath: %s\n", n.entry.ConfigPath)
-		n.configer = configer
-		n.prevRawConfigContent = content
+// refreshConfig 刷新配置，失败则保留原来配置，不影响当前的运行
+func (n *ng) refreshConfig(content []byte) (err error) {
+	log.Printf("[broccoli] [engine.refreshConfig] configpath: %s，configcontent: %s\n", n.entry.ConfigPath, config.Redact(content))
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	configer, err := config.Load(n.entry, content, n.configer)
+	if err != nil {
+		log.Printf("[broccoli] [engine.refreshConfig] 刷新配置失败，保留原来配置，configpath: %s，err: %s\n", n.entry.ConfigPath, err)
 		return
 	}
-	log.Printf("[zeus] [engine.refreshConfig] ˢ������ʧ�ܣ�����ԭ�����ã�configpath: %s\n", n.entry.ConfigPath)
+	log.Printf("[broccoli] [engine.refreshConfig] 刷新配置成功，configpath: %s\n", n.entry.ConfigPath)
+	if n.pinned {
+		log.Printf("[broccoli] [engine.refreshConfig] 配置文件已变化，解除回滚锁定，configpath: %s\n", n.entry.ConfigPath)
+		n.pinned = false
+	}
+	n.configer = configer
+	n.prevRawConfigContent = content
+	n.history.Add(config.Version{
+		Format:  n.entry.ConfigFormat,
+		Content: content,
+	}, configer.Get())
 	return
 }
@@ -0,0 +1,201 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/engine"
+)
+
+var _ engine.Historian = (*ng)(nil)
+
+const testDebounce = 50 * time.Millisecond
+
+// watch 创建engine并开始监听，返回的next等待下一次推送的配置，quiet确认没有多余的推送
+func watch(t *testing.T, path string) (n *ng, next func() config.Configer, quiet func(), stop func()) {
+	e, err := New(&config.Entry{EngineType: "file", ConfigPath: path, ConfigFormat: config.FormatJSON}, nil, WithDebounce(testDebounce))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	changes := make(chan interface{}, 10)
+	cancelC := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		e.Subscribe(changes, cancelC)
+		close(exited)
+	}()
+	// 等待开始监听
+	time.Sleep(testDebounce)
+
+	next = func() config.Configer {
+		t.Helper()
+		select {
+		case c := <-changes:
+			return c.(config.Configer)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no change received")
+		}
+		return nil
+	}
+	quiet = func() {
+		t.Helper()
+		select {
+		case c := <-changes:
+			t.Fatalf("unexpected change, host = %q", c.(config.Configer).Get().Redis.Host)
+		case <-time.After(5 * testDebounce):
+		}
+	}
+	stop = func() {
+		close(cancelC)
+		<-exited
+	}
+	return e.(*ng), next, quiet, stop
+}
+
+func conf(host string) []byte {
+	return []byte(`{"redis":{"host":"` + host + `"}}`)
+}
+
+func write(t *testing.T, path string, content []byte) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "fileng")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// macOS 的临时目录是软链接
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestSubscribeWrite(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	path := filepath.Join(dir, "app.json")
+	write(t, path, conf("a"))
+	_, next, quiet, stop := watch(t, path)
+	defer stop()
+
+	// 合并时间窗口内的多次写入只推送一次
+	for _, host := range []string{"b", "c", "d"} {
+		write(t, path, conf(host))
+	}
+	if c := next(); c.Get().Redis.Host != "d" {
+		t.Errorf("host = %q, want d", c.Get().Redis.Host)
+	}
+	quiet()
+
+	// 内容不变不推送
+	write(t, path, conf("d"))
+	quiet()
+}
+
+func TestSubscribeAtomicRename(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	path := filepath.Join(dir, "app.json")
+	write(t, path, conf("a"))
+	_, next, quiet, stop := watch(t, path)
+	defer stop()
+
+	// 编辑器写临时文件后rename
+	for _, host := range []string{"b", "c"} {
+		tmp := filepath.Join(dir, ".app.json.swp")
+		write(t, tmp, conf(host))
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+		if c := next(); c.Get().Redis.Host != host {
+			t.Errorf("host = %q, want %s", c.Get().Redis.Host, host)
+		}
+		quiet()
+	}
+}
+
+func TestSubscribeSymlinkSwap(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	// k8s ConfigMap 的目录结构: app.json -> ..data/app.json，..data -> ..<version>
+	version := func(name, host string) {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		write(t, filepath.Join(dir, name, "app.json"), conf(host))
+	}
+	swap := func(name string) {
+		tmp := filepath.Join(dir, "..data_tmp")
+		if err := os.Symlink(name, tmp); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	version("..v1", "a")
+	swap("..v1")
+	path := filepath.Join(dir, "app.json")
+	if err := os.Symlink(filepath.Join("..data", "app.json"), path); err != nil {
+		t.Fatal(err)
+	}
+	n, next, quiet, stop := watch(t, path)
+	defer stop()
+
+	for _, v := range []struct{ name, host string }{{"..v2", "b"}, {"..v3", "c"}} {
+		version(v.name, v.host)
+		swap(v.name)
+		if c := next(); c.Get().Redis.Host != v.host {
+			t.Errorf("host = %q, want %s", c.Get().Redis.Host, v.host)
+		}
+		quiet()
+		if want := filepath.Join(dir, v.name, "app.json"); n.realPath != want {
+			t.Errorf("realPath = %s, want %s", n.realPath, want)
+		}
+	}
+}
+
+func TestRollback(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	path := filepath.Join(dir, "app.json")
+	write(t, path, conf("a"))
+	n, next, quiet, stop := watch(t, path)
+	defer stop()
+
+	write(t, path, conf("b"))
+	next()
+
+	// 回滚到版本1，锁定直到文件再次变化
+	if err := n.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	if c := next(); c.Get().Redis.Host != "a" {
+		t.Errorf("rollback host = %q, want a", c.Get().Redis.Host)
+	}
+	quiet()
+	if v := n.History().Latest(); !v.Pinned {
+		t.Errorf("latest version %d should be pinned", v.Version)
+	}
+
+	write(t, path, conf("c"))
+	if c := next(); c.Get().Redis.Host != "c" {
+		t.Errorf("host = %q, want c", c.Get().Redis.Host)
+	}
+	quiet()
+	if v := n.History().Latest(); v.Pinned {
+		t.Errorf("file change should unpin, latest = %+v", v)
+	}
+}
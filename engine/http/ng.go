@@ -0,0 +1,504 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/engine"
+	"github.com/elvisNg/broccoli/plugin/zcontainer"
+	"github.com/elvisNg/broccoli/utils"
+)
+
+const (
+	defaultPollInterval = 30 * time.Second
+	defaultTimeout      = 30 * time.Second
+	defaultRetryDelay   = time.Second
+	maxRetryDelay       = 30 * time.Second
+	minLongPollDelay    = time.Second // 长轮询的响应远早于等待时间返回时，两次请求的最小间隔
+	waitParam           = "wait"      // 长轮询时传给配置服务的最长等待秒数
+)
+
+// Entry.Ext 中可选的http配置项
+const (
+	extToken        = "http_token"         // Bearer token，未配置时使用 Entry.Password
+	extPollInterval = "http_poll_interval" // 轮询间隔，如 30s
+	extLongPoll     = "http_long_poll"     // 长轮询的最长等待时间，如 60s，配置后使用长轮询代替定时轮询
+)
+
+// ng httpengine，从配置服务拉取 ConfigPath 对应的配置
+// 请求带上 If-None-Match，配置服务返回304表示配置无变化
+type ng struct {
+	entry      *config.Entry
+	url        string
+	client     *http.Client
+	container  zcontainer.Container
+	context    context.Context
+	cancelFunc context.CancelFunc
+	options    *Options
+
+	mu       sync.RWMutex
+	configer config.Configer
+	etag     string
+	prevRaw  []byte
+	history  *config.History
+	pinned   bool             // 已回滚到历史版本，配置服务的配置再次变化时解除
+	changes  chan interface{} // Subscribe 传入的changes，用于推送回滚
+
+	healthMu sync.RWMutex
+	health   engine.WatchHealth
+}
+
+type Options struct {
+	context      context.Context
+	client       *http.Client
+	pollInterval time.Duration
+	longPoll     time.Duration
+}
+
+type Option func(o *Options)
+
+// WithPollInterval 定时轮询的间隔
+func WithPollInterval(d time.Duration) Option {
+	return func(o *Options) {
+		o.pollInterval = d
+	}
+}
+
+// WithLongPoll 使用长轮询，wait为配置服务挂起请求的最长时间
+// 配置服务需支持 wait 参数（秒），在配置变化或超时后返回
+func WithLongPoll(wait time.Duration) Option {
+	return func(o *Options) {
+		o.longPoll = wait
+	}
+}
+
+// WithClient 自定义http client，设置后忽略 Entry 中的TLS配置
+func WithClient(c *http.Client) Option {
+	return func(o *Options) {
+		o.client = c
+	}
+}
+
+// New ConfigPath 为完整的url，或者与 EndPoints[0] 拼接的路径
+func New(entry *config.Entry, container zcontainer.Container, opts ...Option) (engine.Engine, error) {
+	n := &ng{
+		entry:     entry,
+		container: container,
+		options: &Options{
+			pollInterval: defaultPollInterval,
+		},
+		history: config.NewHistory(config.DefaultHistorySize),
+	}
+	if err := n.extOptions(); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	for _, o := range opts {
+		o(n.options)
+	}
+	u, err := configURL(entry)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	n.url = u
+	if n.client, err = n.newClient(); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	n.context, n.cancelFunc = context.WithCancel(context.Background())
+	return n, nil
+}
+
+// extOptions 读取 Entry.Ext 中的轮询配置，可被Option覆盖
+func (n *ng) extOptions() error {
+	if v, ok := n.entry.Ext[extPollInterval]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("[broccoli] [engine.New] invalid %s: %s", extPollInterval, v)
+		}
+		n.options.pollInterval = d
+	}
+	if v, ok := n.entry.Ext[extLongPoll]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			return fmt.Errorf("[broccoli] [engine.New] invalid %s: %s", extLongPoll, v)
+		}
+		n.options.longPoll = d
+	}
+	return nil
+}
+
+func configURL(entry *config.Entry) (string, error) {
+	if utils.IsEmptyString(entry.ConfigPath) {
+		return "", errors.New("[broccoli] [engine.New] 配置路径不能为空")
+	}
+	raw := entry.ConfigPath
+	if !strings.Contains(raw, "://") {
+		if len(entry.EndPoints) == 0 {
+			return "", fmt.Errorf("[broccoli] [engine.New] 配置路径 %s 不是完整的url，且未配置endpoints", raw)
+		}
+		endpoint := strings.TrimSuffix(entry.EndPoints[0], "/")
+		if !strings.Contains(endpoint, "://") {
+			scheme := "http://"
+			if entry.TLSEnabled() {
+				scheme = "https://"
+			}
+			endpoint = scheme + endpoint
+		}
+		raw = endpoint + "/" + strings.TrimPrefix(raw, "/")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("[broccoli] [engine.New] 配置路径 %s 不合法: %s", raw, err)
+	}
+	return u.String(), nil
+}
+
+func (n *ng) newClient() (*http.Client, error) {
+	if n.options.client != nil {
+		return n.options.client, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if n.entry.TLSEnabled() {
+		tlsConfig, err := n.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	// 超时由每次请求的context控制，长轮询的请求时间较长
+	return &http.Client{Transport: transport}, nil
+}
+
+func (n *ng) tlsConfig() (*tls.Config, error) {
+	c := &tls.Config{ServerName: n.entry.ServerName}
+	if !utils.IsEmptyString(n.entry.CAFile) {
+		b, err := ioutil.ReadFile(n.entry.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("[broccoli] [engine.New] read ca file failed: %s", err)
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("[broccoli] [engine.New] invalid ca file: %s", n.entry.CAFile)
+		}
+	}
+	if !utils.IsEmptyString(n.entry.CertFile) || !utils.IsEmptyString(n.entry.KeyFile) {
+		cert, err := tls.LoadX509KeyPair(n.entry.CertFile, n.entry.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("[broccoli] [engine.New] load client cert failed: %s", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// fetch 拉取配置，配置无变化（304）时 content 为nil
+func (n *ng) fetch(ctx context.Context, wait time.Duration) (content []byte, etag, contentType string, err error) {
+	u := n.url
+	if wait > 0 {
+		sep := "?"
+		if strings.Contains(u, "?") {
+			sep = "&"
+		}
+		u = fmt.Sprintf("%s%s%s=%d", u, sep, waitParam, int(wait/time.Second))
+	}
+	// 长轮询时在等待时间之外留出请求本身的超时
+	ctx, cancel := context.WithTimeout(ctx, wait+defaultTimeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return
+	}
+	req = req.WithContext(ctx)
+	n.setAuth(req)
+	n.mu.RLock()
+	if !utils.IsEmptyString(n.etag) {
+		req.Header.Set("If-None-Match", n.etag)
+	}
+	n.mu.RUnlock()
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, resp.Header.Get("ETag"), "", nil
+	default:
+		ioutil.ReadAll(resp.Body)
+		return nil, "", "", fmt.Errorf("fetch %s failed, status: %s", n.url, resp.Status)
+	}
+	if content, err = ioutil.ReadAll(resp.Body); err != nil {
+		return
+	}
+	return content, resp.Header.Get("ETag"), resp.Header.Get("Content-Type"), nil
+}
+
+// setAuth Ext中的 http_token 或 Password 作为Bearer token，同时配置了UserName时使用Basic认证
+func (n *ng) setAuth(req *http.Request) {
+	if token, ok := n.entry.Ext[extToken]; ok && !utils.IsEmptyString(token) {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return
+	}
+	if utils.IsEmptyString(n.entry.Password) {
+		return
+	}
+	if !utils.IsEmptyString(n.entry.UserName) {
+		req.SetBasicAuth(n.entry.UserName, n.entry.Password)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+n.entry.Password)
+}
+
+// loadConfig 加载初始化配置，失败则程序退出
+func (n *ng) loadConfig() (err error) {
+	log.Printf("[broccoli] [engine.loadConfig] Begin: 加载配置，configpath: %s\n", n.url)
+	content, etag, contentType, err := n.fetch(n.context, 0)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if utils.IsEmptyString(string(content)) {
+		msg := "[broccoli] [engine.loadConfig] " + n.url + " " + "配置信息为空"
+		log.Println(msg)
+		err = errors.New(msg)
+		return
+	}
+	if err = n.refreshConfig(content, etag, contentType); err != nil {
+		log.Println(err)
+		return
+	}
+	n.setWatchOK(false)
+	log.Printf("[broccoli] [engine.loadConfig] End: 加载配置成功，configpath: %s\n", n.url)
+	return
+}
+
+// refreshConfig 刷新配置，失败则保留原来配置，不影响当前的运行
+// 未配置 config_format 时优先根据 Content-Type 识别格式
+func (n *ng) refreshConfig(content []byte, etag, contentType string) (err error) {
+	log.Printf("[broccoli] [engine.refreshConfig] configpath: %s，configcontent: %s\n", n.url, config.Redact(content))
+	entry := *n.entry
+	if utils.IsEmptyString(entry.ConfigFormat) {
+		entry.ConfigFormat = formatOf(contentType)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	configer, err := config.Load(&entry, content, n.configer)
+	if err != nil {
+		log.Printf("[broccoli] [engine.refreshConfig] 刷新配置失败，保留原来配置，configpath: %s，err: %s\n", n.url, err)
+		// 记录etag，配置服务的配置未修正前不再重复加载
+		n.etag = etag
+		return
+	}
+	log.Printf("[broccoli] [engine.refreshConfig] 刷新配置成功，configpath: %s\n", n.url)
+	if n.pinned {
+		log.Printf("[broccoli] [engine.refreshConfig] 配置已变化，解除回滚锁定，configpath: %s\n", n.url)
+		n.pinned = false
+	}
+	n.configer = configer
+	n.etag = etag
+	n.prevRaw = content
+	n.history.Add(config.Version{
+		Format:  entry.ConfigFormat,
+		Content: content,
+	}, configer.Get())
+	return
+}
+
+func formatOf(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case strings.HasSuffix(mt, "json"):
+		return config.FormatJSON
+	case strings.HasSuffix(mt, "yaml"), strings.HasSuffix(mt, "yml"):
+		return config.FormatYAML
+	case strings.HasSuffix(mt, "toml"):
+		return config.FormatTOML
+	}
+	return ""
+}
+
+func (n *ng) Init() (err error) {
+	return n.loadConfig()
+}
+
+func (n *ng) GetConfiger() (config.Configer, error) {
+	return n.currentConfiger(), nil
+}
+
+func (n *ng) currentConfiger() config.Configer {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.configer
+}
+
+func (n *ng) GetContainer() zcontainer.Container {
+	return n.container
+}
+
+func (n *ng) History() *config.History {
+	return n.history
+}
+
+// Rollback 配置服务只读，在本地锁定指定版本，配置服务的配置再次变化时解除锁定
+func (n *ng) Rollback(version int) error {
+	v, err := n.history.Get(version)
+	if err != nil {
+		return err
+	}
+	entry := *n.entry
+	entry.ConfigFormat = v.Format
+	n.mu.Lock()
+	configer, err := config.Load(&entry, v.Content, n.configer)
+	if err != nil {
+		n.mu.Unlock()
+		log.Printf("[broccoli] [engine.Rollback] 回滚失败，configpath: %s，version: %d，err: %s\n", n.url, version, err)
+		return err
+	}
+	n.configer = configer
+	n.pinned = true
+	n.history.Add(config.Version{
+		Format:  v.Format,
+		Content: v.Content,
+		Pinned:  true,
+	}, configer.Get())
+	changes := n.changes
+	n.mu.Unlock()
+	log.Printf("[broccoli] [engine.Rollback] 已回滚并锁定，configpath: %s，version: %d\n", n.url, version)
+
+	if changes == nil {
+		return nil
+	}
+	select {
+	case changes <- configer:
+	default: // 防止忘记消费changes导致一直阻塞
+		log.Printf("[broccoli] [engine.Rollback] channel is blocked, can not push change into changes")
+	}
+	return nil
+}
+
+// Subscribe 长轮询或定时轮询配置服务，请求失败时退避重试
+func (n *ng) Subscribe(changes chan interface{}, cancelC chan struct{}) error {
+	defer n.cancelFunc()
+	// cancelC 触发时中断正在进行的请求
+	go func() {
+		select {
+		case <-cancelC:
+			n.cancelFunc()
+		case <-n.context.Done():
+		}
+	}()
+	n.mu.Lock()
+	n.changes = changes
+	n.mu.Unlock()
+
+	log.Printf("[broccoli] [engine.Subscribe] Begin watching http configpath: %s\n", n.url)
+	retryDelay := defaultRetryDelay
+	var delay time.Duration
+	if n.options.longPoll <= 0 {
+		delay = n.options.pollInterval
+	}
+	for {
+		select {
+		case <-time.After(delay):
+		case <-n.context.Done():
+			log.Printf("[broccoli] [engine.Subscribe] cancel watch config: %s\n", n.url)
+			return nil
+		}
+		start := time.Now()
+		content, etag, contentType, err := n.fetch(n.context, n.options.longPoll)
+		if n.context.Err() != nil {
+			log.Printf("[broccoli] [engine.Subscribe] cancel watch config: %s\n", n.url)
+			return nil
+		}
+		if err != nil {
+			n.setWatchError(err)
+			log.Printf("[broccoli] [engine.Subscribe] watch error: %s, retry after %s\n", err, retryDelay)
+			delay = retryDelay
+			if retryDelay *= 2; retryDelay > maxRetryDelay {
+				retryDelay = maxRetryDelay
+			}
+			continue
+		}
+		retryDelay = defaultRetryDelay
+		if n.options.longPoll > 0 {
+			delay = 0
+			// 配置服务不支持wait参数或代理直接返回时，避免连续请求
+			if elapsed := time.Since(start); elapsed < n.options.longPoll/2 && elapsed < minLongPollDelay {
+				delay = minLongPollDelay - elapsed
+			}
+		} else {
+			delay = n.options.pollInterval
+		}
+
+		n.mu.RLock()
+		same := content == nil || string(content) == string(n.prevRaw)
+		n.mu.RUnlock()
+		if same {
+			n.setWatchOK(false)
+			continue
+		}
+		err = n.refreshConfig(content, etag, contentType)
+		n.setWatchOK(true)
+		if err != nil {
+			log.Printf("[broccoli] [engine.Subscribe] ignore '%s', error: %s\n", n.url, err)
+			continue
+		}
+		log.Printf("[broccoli] [engine.Subscribe] configPath: %s change\n", n.url)
+		select {
+		case changes <- n.currentConfiger():
+		case <-n.context.Done():
+			log.Printf("[broccoli] [engine.Subscribe] cancel watch config: %s\n", n.url)
+			return nil
+		default: // 防止忘记消费changes导致一直阻塞
+			log.Printf("[broccoli] [engine.Subscribe] channel is blocked, can not push change into changes")
+		}
+	}
+}
+
+// WatchHealth 监听状态，Revision 为已生效的配置版本号
+func (n *ng) WatchHealth() engine.WatchHealth {
+	n.healthMu.RLock()
+	defer n.healthMu.RUnlock()
+	return n.health
+}
+
+func (n *ng) setWatchOK(event bool) {
+	n.healthMu.Lock()
+	defer n.healthMu.Unlock()
+	n.health.Healthy = true
+	n.health.Retries = 0
+	if v := n.history.Latest(); v != nil {
+		n.health.Revision = int64(v.Version)
+	}
+	if event {
+		n.health.LastEvent = time.Now()
+	}
+}
+
+func (n *ng) setWatchError(err error) {
+	n.healthMu.Lock()
+	defer n.healthMu.Unlock()
+	n.health.Healthy = false
+	n.health.Retries++
+	n.health.LastError = err.Error()
+	n.health.ErrorTime = time.Now()
+}
@@ -0,0 +1,163 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/engine"
+	"github.com/elvisNg/broccoli/engine/enginetest"
+)
+
+var (
+	_ engine.Historian           = (*ng)(nil)
+	_ engine.WatchHealthReporter = (*ng)(nil)
+)
+
+// configServer 模拟配置服务，支持 If-None-Match 和 wait 参数的长轮询
+type configServer struct {
+	*enginetest.Store
+	ignoreWait bool  // 忽略wait参数，配置未变化时立即返回304
+	requests   int32 // 收到的请求数
+}
+
+func newConfigServer(content string) *configServer {
+	return &configServer{Store: enginetest.NewStore(content, 1)}
+}
+
+func (s *configServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&s.requests, 1)
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.Failing() {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	content, version := s.Get()
+	if r.Header.Get("If-None-Match") == fmt.Sprintf(`"%d"`, version) {
+		wait, _ := strconv.Atoi(r.URL.Query().Get("wait"))
+		if s.ignoreWait {
+			wait = 0
+		}
+		if !s.Wait(r.Context(), version, time.Duration(wait)*time.Second) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		content, version = s.Get()
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`"%d"`, version))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// subscribe 创建engine并开始监听，返回的next等待下一次推送的配置
+func subscribe(t *testing.T, cs *configServer, longPoll time.Duration) (n engine.Engine, next func() config.Configer, stop func()) {
+	srv := httptest.NewServer(cs)
+	entry := &config.Entry{
+		EngineType: "http",
+		ConfigPath: "/config/app",
+		EndPoints:  []string{srv.URL},
+		Ext:        map[string]string{extToken: "secret"},
+	}
+	n, err := New(entry, nil, WithLongPoll(longPoll))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = n.Init(); err != nil {
+		t.Fatal(err)
+	}
+	changes := make(chan interface{}, 1)
+	cancelC := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		n.Subscribe(changes, cancelC)
+		close(exited)
+	}()
+	next = func() config.Configer {
+		t.Helper()
+		select {
+		case c := <-changes:
+			return c.(config.Configer)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no change received")
+		}
+		return nil
+	}
+	stop = func() {
+		close(cancelC)
+		select {
+		case <-exited:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Subscribe did not exit after cancel")
+		}
+		srv.Close()
+	}
+	return
+}
+
+func TestSubscribeLongPoll(t *testing.T) {
+	cs := newConfigServer(`{"redis":{"host":"a"}}`)
+	ng, next, stop := subscribe(t, cs, time.Second)
+	defer stop()
+	if c, _ := ng.GetConfiger(); c.Get().Redis.Host != "a" {
+		t.Fatalf("init host = %q, want a", c.Get().Redis.Host)
+	}
+
+	cs.Set(`{"redis":{"host":"b"}}`, 0)
+	if c := next(); c.Get().Redis.Host != "b" {
+		t.Errorf("host = %q, want b", c.Get().Redis.Host)
+	}
+
+	// 配置服务异常时退避重试，恢复后继续监听
+	cs.Fail(1)
+	cs.Set(`{"redis":{"host":"c"}}`, 0)
+	if c := next(); c.Get().Redis.Host != "c" {
+		t.Errorf("host = %q, want c", c.Get().Redis.Host)
+	}
+	if h := ng.(engine.WatchHealthReporter).WatchHealth(); !h.Healthy || h.Revision != 3 {
+		t.Errorf("health = %+v, want healthy at revision 3", h)
+	}
+}
+
+func TestSubscribeLongPollIgnored(t *testing.T) {
+	// 配置服务忽略wait参数，未变化时立即返回304，按 minLongPollDelay 限制轮询频率
+	cs := newConfigServer(`{"redis":{"host":"a"}}`)
+	cs.ignoreWait = true
+	_, next, stop := subscribe(t, cs, time.Minute)
+	time.Sleep(1500 * time.Millisecond)
+	if n := atomic.LoadInt32(&cs.requests) - 1; n > 3 {
+		t.Errorf("%d polls in 1.5s, want at most 3", n)
+	}
+
+	// 变化仍能收到
+	cs.Set(`{"redis":{"host":"b"}}`, 0)
+	if c := next(); c.Get().Redis.Host != "b" {
+		t.Errorf("host = %q, want b", c.Get().Redis.Host)
+	}
+	stop()
+}
+
+func TestConfigURL(t *testing.T) {
+	tests := []struct {
+		entry config.Entry
+		want  string
+	}{
+		{config.Entry{ConfigPath: "http://conf/app.json"}, "http://conf/app.json"},
+		{config.Entry{ConfigPath: "/app.json", EndPoints: []string{"conf:8080/"}}, "http://conf:8080/app.json"},
+		{config.Entry{ConfigPath: "app.json", EndPoints: []string{"conf"}, CAFile: "ca.pem"}, "https://conf/app.json"},
+		{config.Entry{ConfigPath: "app.json"}, ""},
+	}
+	for _, tt := range tests {
+		got, err := configURL(&tt.entry)
+		if (err != nil) != (tt.want == "") || got != tt.want {
+			t.Errorf("configURL(%+v) = %q, %v, want %q", tt.entry, got, err, tt.want)
+		}
+	}
+}
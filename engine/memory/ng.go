@@ -0,0 +1,209 @@
+package memory
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/plugin/zcontainer"
+	"github.com/elvisNg/broccoli/utils"
+)
+
+const defaultConfigPath = "memory"
+
+// Engine 内存engine，配置由调用方通过 Set/SetRaw 设置，用于测试和嵌入式场景
+// 配置同样经过 config.Load，与其他engine一样应用覆盖项、解密和校验
+type Engine struct {
+	entry     *config.Entry
+	container zcontainer.Container
+	options   *Options
+	history   *config.History
+
+	setMu    sync.Mutex // 串行化 SetRaw 的加载和推送，保证推送顺序与加载顺序一致
+	mu       sync.RWMutex
+	configer config.Configer
+	missed   config.Configer // 没有订阅时设置的配置，Subscribe 时推送
+	changes  chan interface{}
+	done     chan struct{} // Subscribe 退出时关闭
+}
+
+// change 推送的配置变化，处理方重新加载后调用 Ack
+type change struct {
+	config.Configer
+	once  sync.Once
+	acked chan struct{}
+}
+
+func (c *change) Ack() {
+	c.once.Do(func() { close(c.acked) })
+}
+
+type Options struct {
+	raw    []byte
+	format string
+}
+
+type Option func(o *Options)
+
+// WithRaw 初始配置的原始内容，format为空时自动识别
+func WithRaw(content []byte, format string) Option {
+	return func(o *Options) {
+		o.raw = content
+		o.format = format
+	}
+}
+
+// WithConfig 初始配置
+func WithConfig(conf *config.AppConf) Option {
+	return func(o *Options) {
+		b, err := utils.Marshal(conf)
+		if err != nil {
+			log.Printf("[broccoli] [memory.WithConfig] marshal config err: %s\n", err)
+			return
+		}
+		o.raw = b
+		o.format = config.FormatJSON
+	}
+}
+
+// New entry 可为nil
+func New(entry *config.Entry, container zcontainer.Container, opts ...Option) (*Engine, error) {
+	if entry == nil {
+		entry = &config.Entry{EngineType: "memory", ConfigPath: defaultConfigPath}
+	}
+	e := &Engine{
+		entry:     entry,
+		container: container,
+		options:   &Options{},
+		history:   config.NewHistory(config.DefaultHistorySize),
+	}
+	for _, o := range opts {
+		o(e.options)
+	}
+	return e, nil
+}
+
+// Init 加载初始配置，未设置时使用空配置
+func (e *Engine) Init() error {
+	raw, format := e.options.raw, e.options.format
+	if len(raw) == 0 {
+		raw, format = []byte("{}"), config.FormatJSON
+	}
+	_, err := e.load(raw, format)
+	return err
+}
+
+// Set 设置配置，同步推送到changes，处理方 Ack 后返回，见 SetRaw
+func (e *Engine) Set(conf *config.AppConf) error {
+	if conf == nil {
+		return errors.New("config is nil")
+	}
+	b, err := utils.Marshal(conf)
+	if err != nil {
+		return err
+	}
+	return e.SetRaw(b, config.FormatJSON)
+}
+
+// SetRaw 按原始内容设置配置，format为空时自动识别
+// 同步推送到changes，处理方重新加载容器组件并 Ack 后返回
+// 没有订阅时只更新配置，下次 Subscribe 时推送最新的配置
+// 配置加载或校验失败时返回错误，保留原来的配置
+func (e *Engine) SetRaw(content []byte, format string) error {
+	e.setMu.Lock()
+	defer e.setMu.Unlock()
+	configer, err := e.load(content, format)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	changes, done := e.changes, e.done
+	if changes == nil {
+		e.missed = configer
+	}
+	e.mu.Unlock()
+	if changes == nil {
+		return nil
+	}
+	c := &change{Configer: configer, acked: make(chan struct{})}
+	select {
+	case changes <- c:
+	case <-done:
+		return nil
+	}
+	select {
+	case <-c.acked:
+	case <-done:
+	}
+	return nil
+}
+
+func (e *Engine) load(content []byte, format string) (config.Configer, error) {
+	entry := *e.entry
+	entry.ConfigFormat = format
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	configer, err := config.Load(&entry, content, e.configer)
+	if err != nil {
+		log.Printf("[broccoli] [memory.load] 刷新配置失败，保留原来配置，err: %s\n", err)
+		return nil, err
+	}
+	e.configer = configer
+	e.history.Add(config.Version{Format: format, Content: content}, configer.Get())
+	return configer, nil
+}
+
+// Subscribe 阻塞直到收到cancelC，期间 Set/SetRaw 的配置推送到changes
+// 之前没有订阅时设置过配置的，先推送最新的配置
+func (e *Engine) Subscribe(changes chan interface{}, cancelC chan struct{}) error {
+	done := make(chan struct{})
+	e.setMu.Lock()
+	e.mu.Lock()
+	e.changes, e.done = changes, done
+	missed := e.missed
+	e.missed = nil
+	e.mu.Unlock()
+	cancelled := false
+	if missed != nil {
+		select {
+		case changes <- &change{Configer: missed, acked: make(chan struct{})}:
+		case <-cancelC:
+			cancelled = true
+		}
+	}
+	e.setMu.Unlock()
+
+	if !cancelled {
+		<-cancelC
+	}
+
+	e.mu.Lock()
+	e.changes, e.done = nil, nil
+	e.mu.Unlock()
+	close(done)
+	return nil
+}
+
+func (e *Engine) GetConfiger() (config.Configer, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.configer, nil
+}
+
+func (e *Engine) GetContainer() zcontainer.Container {
+	return e.container
+}
+
+func (e *Engine) History() *config.History {
+	return e.history
+}
+
+// Rollback 重新设置指定版本的配置
+func (e *Engine) Rollback(version int) error {
+	v, err := e.history.Get(version)
+	if err != nil {
+		return err
+	}
+	return e.SetRaw(v.Content, v.Format)
+}
@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/engine"
+)
+
+var (
+	_ engine.Engine    = (*Engine)(nil)
+	_ engine.Historian = (*Engine)(nil)
+	_ engine.Acker     = (*change)(nil)
+)
+
+func TestEngineSet(t *testing.T) {
+	e, err := New(nil, nil, WithConfig(&config.AppConf{Redis: config.Redis{Host: "a"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	// 没有订阅时 Set 只更新配置，不阻塞
+	if err = e.Set(&config.AppConf{Redis: config.Redis{Host: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := e.GetConfiger(); c.Get().Redis.Host != "b" {
+		t.Errorf("current host = %q, want b", c.Get().Redis.Host)
+	}
+
+	changes := make(chan interface{}, 1)
+	cancelC := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		e.Subscribe(changes, cancelC)
+		close(exited)
+	}()
+	// Subscribe 时推送订阅前设置的配置
+	if c := (<-changes).(config.Configer); c.Get().Redis.Host != "b" {
+		t.Errorf("missed host = %q, want b", c.Get().Redis.Host)
+	}
+	// 处理方记录应用的配置后 Ack
+	var mu sync.Mutex
+	var applied []string
+	go func() {
+		for ev := range changes {
+			mu.Lock()
+			applied = append(applied, ev.(config.Configer).Get().Redis.Host)
+			mu.Unlock()
+			ev.(engine.Acker).Ack()
+		}
+	}()
+	appliedHosts := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), applied...)
+	}
+	// 并发 Set 按加载的顺序推送，Set 返回时处理方已应用
+	var wg sync.WaitGroup
+	for _, host := range []string{"c", "d", "e"} {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			if err := e.Set(&config.AppConf{Redis: config.Redis{Host: host}}); err != nil {
+				t.Error(err)
+			}
+		}(host)
+	}
+	wg.Wait()
+	hosts := appliedHosts()
+	if len(hosts) != 3 {
+		t.Fatalf("applied = %v, want 3 changes", hosts)
+	}
+	if c, _ := e.GetConfiger(); c.Get().Redis.Host != hosts[2] {
+		t.Errorf("current host = %q, last applied %q", c.Get().Redis.Host, hosts[2])
+	}
+	for i, host := range hosts {
+		v, err := e.History().Get(i + 3)
+		if err != nil || v.Conf().Redis.Host != host {
+			t.Errorf("version %d = %v, %v, applied %q", i+3, v, err, host)
+		}
+	}
+
+	// 校验失败时保留原来的配置，不推送
+	if err = e.SetRaw([]byte(`{"redis":{"enable":true}}`), ""); err == nil {
+		t.Errorf("SetRaw() invalid config should fail")
+	}
+	if c, _ := e.GetConfiger(); c.Get().Redis.Host != hosts[2] {
+		t.Errorf("current host = %q, want %s", c.Get().Redis.Host, hosts[2])
+	}
+
+	if err = e.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	if hosts = appliedHosts(); len(hosts) != 4 || hosts[3] != "a" {
+		t.Errorf("applied = %v, want a applied last", hosts)
+	}
+
+	cancelC <- struct{}{}
+	<-exited
+	if err = e.Set(&config.AppConf{}); err != nil {
+		t.Errorf("Set() after Subscribe exit = %v", err)
+	}
+	close(changes)
+}
@@ -0,0 +1,116 @@
+// Package health 聚合各组件的健康检查结果，用于存活和就绪检查
+package health
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+
+	// DefaultTimeout 单个检查的默认超时时间
+	DefaultTimeout = 2 * time.Second
+)
+
+// CheckFunc 检查函数，返回nil表示可用
+type CheckFunc func(ctx context.Context) error
+
+// Check 单个组件的检查
+type Check struct {
+	Name string
+	// Critical 不可用时整体不就绪，非关键组件只在结果中体现
+	Critical bool
+	Fn       CheckFunc
+}
+
+// Result 单个组件的检查结果
+type Result struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Critical bool   `json:"critical"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report 检查报告，任一关键组件不可用时 Status 为down
+type Report struct {
+	Status     string   `json:"status"`
+	Components []Result `json:"components"`
+}
+
+// Up 是否就绪
+func (r Report) Up() bool {
+	return r.Status == StatusUp
+}
+
+// Component 指定组件的检查结果
+func (r Report) Component(name string) (Result, bool) {
+	for _, c := range r.Components {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Result{}, false
+}
+
+// Run 并发执行检查，每个检查超过timeout视为不可用，timeout<=0时使用 DefaultTimeout
+func Run(ctx context.Context, timeout time.Duration, checks []Check) Report {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c Check) {
+			defer wg.Done()
+			results[i] = run(ctx, timeout, c)
+		}(i, c)
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	report := Report{Status: StatusUp, Components: results}
+	for _, r := range results {
+		if r.Critical && r.Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+func run(ctx context.Context, timeout time.Duration, c Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	errC := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errC <- errors.New("health check panic")
+			}
+		}()
+		errC <- c.Fn(ctx)
+	}()
+	var err error
+	select {
+	case err = <-errC:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	res := Result{
+		Name:     c.Name,
+		Status:   StatusUp,
+		Critical: c.Critical,
+		Duration: time.Since(start).String(),
+	}
+	if err != nil {
+		res.Status = StatusDown
+		res.Error = err.Error()
+	}
+	return res
+}
@@ -0,0 +1,42 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	fail := func(ctx context.Context) error { return errors.New("refused") }
+	slow := func(ctx context.Context) error {
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	}
+	tests := []struct {
+		name   string
+		checks []Check
+		want   string
+	}{
+		{"all up", []Check{{"redis", true, ok}, {"mysql", true, ok}}, StatusUp},
+		{"critical down", []Check{{"redis", true, ok}, {"mysql", true, fail}}, StatusDown},
+		{"non-critical down", []Check{{"redis", true, ok}, {"es", false, fail}}, StatusUp},
+		{"timeout", []Check{{"mongodb", true, slow}}, StatusDown},
+		{"no checks", nil, StatusUp},
+	}
+	for _, tt := range tests {
+		r := Run(context.Background(), 50*time.Millisecond, tt.checks)
+		if r.Status != tt.want {
+			t.Errorf("%s: Run() = %+v, want %s", tt.name, r, tt.want)
+		}
+	}
+
+	r := Run(context.Background(), 0, []Check{{"es", false, fail}, {"redis", true, ok}})
+	if r.Components[0].Name != "es" || r.Components[0].Error != "refused" {
+		t.Errorf("components = %+v, want sorted with error detail", r.Components)
+	}
+	if c, found := r.Component("redis"); !found || c.Status != StatusUp {
+		t.Errorf("Component(redis) = %+v, %v", c, found)
+	}
+}
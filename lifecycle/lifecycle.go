@@ -0,0 +1,110 @@
+// Package lifecycle 进程的停止信号和按顺序执行的停止步骤
+// 进程内只安装一次 SIGTERM/SIGINT 处理，服务、订阅等通过 Done 等待停止
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	notifyOnce   sync.Once
+	shutdownOnce sync.Once
+	done         = make(chan struct{})
+)
+
+// Done 第一次收到 SIGTERM/SIGINT 或调用 Shutdown 后关闭
+// 收到信号后恢复默认的信号处理，再次收到信号时进程直接退出
+func Done() <-chan struct{} {
+	notifyOnce.Do(func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT)
+		go func() {
+			select {
+			case sig := <-ch:
+				log.Printf("[broccoli] [lifecycle] received signal %s, shutting down\n", sig)
+			case <-done:
+			}
+			signal.Stop(ch)
+			Shutdown()
+		}()
+	})
+	return done
+}
+
+// Shutdown 主动触发停止，与收到信号相同，可重复调用
+func Shutdown() {
+	shutdownOnce.Do(func() {
+		close(done)
+	})
+}
+
+// Hook 停止步骤，ctx 在 Timeout 后结束，步骤应尽快返回
+type Hook struct {
+	Name    string
+	Timeout time.Duration // 不大于0时等待步骤完成
+	Fn      func(ctx context.Context) error
+}
+
+// Manager 按添加顺序执行停止步骤
+type Manager struct {
+	mu    sync.Mutex
+	hooks []Hook
+}
+
+// Add 添加停止步骤
+func (m *Manager) Add(name string, timeout time.Duration, fn func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, Hook{Name: name, Timeout: timeout, Fn: fn})
+}
+
+// Stop 依次执行停止步骤，某一步失败或超时不影响后续步骤，返回第一个错误
+// 步骤超时后不再等待，继续执行后续步骤
+func (m *Manager) Stop() (err error) {
+	m.mu.Lock()
+	hooks := append([]Hook(nil), m.hooks...)
+	m.mu.Unlock()
+	for _, h := range hooks {
+		start := time.Now()
+		herr := run(h)
+		if herr != nil {
+			log.Printf("[broccoli] [lifecycle.Stop] %s err: %s\n", h.Name, herr)
+			if err == nil {
+				err = fmt.Errorf("%s: %s", h.Name, herr)
+			}
+			continue
+		}
+		log.Printf("[broccoli] [lifecycle.Stop] %s done in %s\n", h.Name, time.Since(start))
+	}
+	return
+}
+
+func run(h Hook) error {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if h.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+	}
+	defer cancel()
+	errC := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errC <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		errC <- h.Fn(ctx)
+	}()
+	select {
+	case err := <-errC:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,53 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestManagerStop(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	step := func(name string, err error) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return err
+		}
+	}
+	var m Manager
+	m.Add("deregister", 0, step("deregister", nil))
+	m.Add("http", time.Second, step("http", errors.New("drain failed")))
+	m.Add("grpc", 20*time.Millisecond, func(ctx context.Context) error {
+		step("grpc", nil)(ctx)
+		select {} // 不响应ctx的步骤
+	})
+	m.Add("container", time.Second, step("container", nil))
+
+	err := m.Stop()
+	if err == nil || err.Error() != "http: drain failed" {
+		t.Errorf("Stop err = %v", err)
+	}
+	// 失败或超时的步骤不影响后续步骤
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"deregister", "http", "grpc", "container"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	d := Done()
+	Shutdown()
+	Shutdown()
+	select {
+	case <-d:
+	case <-time.After(time.Second):
+		t.Fatal("Done not closed after Shutdown")
+	}
+}
@@ -1,6 +1,7 @@
 package log
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -24,6 +25,7 @@ type LogBuilder struct {
 	conf      config.LogConf
 	formatter logrus.Formatter
 	m         sync.Map
+	outputs   []*rotatelogs.RotateLogs // 文件输出，Close 时关闭
 }
 
 // New logbuilder
@@ -108,6 +110,7 @@ func (l *LogBuilder) setOutput() (err error) {
 				return
 			}
 			rotateFileOutput[ll] = o
+			l.outputs = append(l.outputs, o)
 		}
 		var h logrus.Hook
 		if h, err = hook.NewRotateFileHook(logrus.AllLevels, l.formatter, rotateFileOutput); err != nil {
@@ -122,6 +125,19 @@ func (l *LogBuilder) setOutput() (err error) {
 	return
 }
 
+// Close 关闭日志文件，控制台输出无需关闭
+func (l *LogBuilder) Close(ctx context.Context) (err error) {
+	for _, o := range l.outputs {
+		if o == nil {
+			continue
+		}
+		if e := o.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
 func newRotateFileOutput(logConf *config.LogConf, level logrus.Level) (rl *rotatelogs.RotateLogs, err error) {
 	filename := filepath.Join(logConf.LogDir, "%Y%m%d"+"."+strings.ToLower(level.String())+"_"+"%H"+".log")
 	duration := time.Hour
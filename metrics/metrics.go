@@ -0,0 +1,159 @@
+// Package metrics 服务的prometheus指标，注册到 prometheus.DefaultRegisterer，由 /metrics 输出
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/elvisNg/broccoli/config"
+)
+
+const (
+	namespace = "broccoli"
+
+	// Path 指标的http路径
+	Path = "/metrics"
+
+	ResultOK    = "ok"
+	ResultError = "error"
+)
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by source (gin/gateway), method, path, status and errcode.",
+	}, []string{"source", "method", "path", "status", "errcode"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"source", "method", "path"})
+
+	rpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "requests_total",
+		Help:      "go-micro requests by side (server/client), service, endpoint and errcode.",
+	}, []string{"side", "service", "endpoint", "errcode"})
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "request_duration_seconds",
+		Help:      "go-micro request latency.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"side", "service", "endpoint"})
+
+	pubsubMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "pubsub",
+		Name:      "messages_total",
+		Help:      "Published and consumed broker messages by topic and result.",
+	}, []string{"direction", "topic", "result"})
+	pubsubDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "pubsub",
+		Name:      "duration_seconds",
+		Help:      "Broker publish and handler latency.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"direction", "topic"})
+
+	mongoCommands = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "mongo",
+		Name:      "commands_total",
+		Help:      "Mongo commands by command name and result.",
+	}, []string{"command", "result"})
+	mongoDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "mongo",
+		Name:      "command_duration_seconds",
+		Help:      "Mongo command latency.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"command"})
+	mongoInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "mongo",
+		Name:      "commands_in_flight",
+		Help:      "Mongo commands waiting for reply, an upper bound of checked out connections.",
+	})
+
+	configRejected = prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "config",
+		Name:      "rejected_total",
+		Help:      "Config changes rejected by validation.",
+	}, func() float64 { return float64(config.RejectedCount()) })
+)
+
+func init() {
+	prometheus.MustRegister(
+		httpRequests, httpDuration,
+		rpcRequests, rpcDuration,
+		pubsubMessages, pubsubDuration,
+		mongoCommands, mongoDuration, mongoInFlight,
+		configRejected,
+	)
+}
+
+// Handler 输出 prometheus.DefaultGatherer 中的指标，业务使用默认注册的指标同样输出
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveHTTP 记录一次http请求，source 为gin或gateway，path 应为路由或handler名，避免路径参数导致标签过多
+func ObserveHTTP(source, method, path string, status int, errcode int32, d time.Duration) {
+	httpRequests.WithLabelValues(source, method, path, strconv.Itoa(status), strconv.Itoa(int(errcode))).Inc()
+	httpDuration.WithLabelValues(source, method, path).Observe(d.Seconds())
+}
+
+// ObserveRPC 记录一次go-micro请求，side 为server或client
+func ObserveRPC(side, service, endpoint string, errcode int32, d time.Duration) {
+	rpcRequests.WithLabelValues(side, service, endpoint, strconv.Itoa(int(errcode))).Inc()
+	rpcDuration.WithLabelValues(side, service, endpoint).Observe(d.Seconds())
+}
+
+// ObservePublish 记录一次消息发布
+func ObservePublish(topic string, err error, d time.Duration) {
+	observePubsub("publish", topic, err, d)
+}
+
+// ObserveConsume 记录一次消息处理
+func ObserveConsume(topic string, err error, d time.Duration) {
+	observePubsub("consume", topic, err, d)
+}
+
+func observePubsub(direction, topic string, err error, d time.Duration) {
+	pubsubMessages.WithLabelValues(direction, topic, result(err)).Inc()
+	pubsubDuration.WithLabelValues(direction, topic).Observe(d.Seconds())
+}
+
+// MongoCommandStarted mongo命令开始，与 MongoCommandFinished 成对调用
+func MongoCommandStarted() {
+	mongoInFlight.Inc()
+}
+
+// MongoCommandFinished mongo命令结束，ok 为false表示命令失败
+func MongoCommandFinished(command string, ok bool, d time.Duration) {
+	mongoInFlight.Dec()
+	res := ResultOK
+	if !ok {
+		res = ResultError
+	}
+	mongoCommands.WithLabelValues(command, res).Inc()
+	mongoDuration.WithLabelValues(command).Observe(d.Seconds())
+}
+
+func result(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return ResultOK
+}
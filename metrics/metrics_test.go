@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveRPC(t *testing.T) {
+	ObserveRPC("server", "svc", "Greeter.Hello", 0, time.Millisecond)
+	ObserveRPC("server", "svc", "Greeter.Hello", 0, time.Millisecond)
+	ObserveRPC("server", "svc", "Greeter.Hello", 10001, time.Millisecond)
+	if n := testutil.ToFloat64(rpcRequests.WithLabelValues("server", "svc", "Greeter.Hello", "0")); n != 2 {
+		t.Errorf("ok requests = %v, want 2", n)
+	}
+	if n := testutil.ToFloat64(rpcRequests.WithLabelValues("server", "svc", "Greeter.Hello", "10001")); n != 1 {
+		t.Errorf("failed requests = %v, want 1", n)
+	}
+}
+
+func TestRegisterPoolStats(t *testing.T) {
+	fn := func() []PoolStats {
+		return []PoolStats{{Kind: "redis", Open: 3, InUse: 1, Idle: 2}}
+	}
+	if err := RegisterPoolStats(fn); err != nil {
+		t.Fatal(err)
+	}
+	// 多次初始化服务时重复注册
+	if err := RegisterPoolStats(fn); err != nil {
+		t.Errorf("re-register err = %v", err)
+	}
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "broccoli_pool_open_connections" {
+			if v := mf.GetMetric()[0].GetGauge().GetValue(); v != 3 {
+				t.Errorf("open connections = %v, want 3", v)
+			}
+			return
+		}
+	}
+	t.Error("broccoli_pool_open_connections not gathered")
+}
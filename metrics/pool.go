@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PoolStats 连接池状态，不支持的字段为0
+type PoolStats struct {
+	Kind         string // redis/mysql
+	Name         string // RedisSource/MysqlSource 中的名称，默认client为空
+	Instance     string // mysql 为 primary 或从库的host
+	Open         int
+	InUse        int
+	Idle         int
+	WaitCount    int64
+	WaitDuration time.Duration
+	Hits         uint64
+	Misses       uint64
+	Timeouts     uint64
+}
+
+// PoolStatsFunc 采集时调用，返回当前所有连接池的状态，配置重新加载后的client在下次采集时生效
+type PoolStatsFunc func() []PoolStats
+
+var (
+	poolLabels      = []string{"kind", "name", "instance"}
+	poolConnections = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pool", "connections"),
+		"Connections in the pool by state (in_use/idle).", append(poolLabels, "state"), nil)
+	poolOpen = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pool", "open_connections"),
+		"Open connections in the pool.", poolLabels, nil)
+	poolWaits = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pool", "wait_total"),
+		"Times waited for a connection.", poolLabels, nil)
+	poolWaitSeconds = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pool", "wait_seconds_total"),
+		"Total time waited for a connection.", poolLabels, nil)
+	poolHits = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pool", "hits_total"),
+		"Free connection found in the pool.", poolLabels, nil)
+	poolMisses = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pool", "misses_total"),
+		"Free connection not found in the pool.", poolLabels, nil)
+	poolTimeouts = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pool", "timeouts_total"),
+		"Wait for a connection timed out.", poolLabels, nil)
+)
+
+type poolCollector struct {
+	fn PoolStatsFunc
+}
+
+// RegisterPoolStats 注册连接池状态的采集，进程内只生效一次，重复注册返回nil
+func RegisterPoolStats(fn PoolStatsFunc) error {
+	err := prometheus.Register(&poolCollector{fn: fn})
+	if _, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		return nil
+	}
+	return err
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{poolConnections, poolOpen, poolWaits, poolWaitSeconds, poolHits, poolMisses, poolTimeouts} {
+		ch <- d
+	}
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.fn() {
+		ch <- prometheus.MustNewConstMetric(poolConnections, prometheus.GaugeValue, float64(s.InUse), s.Kind, s.Name, s.Instance, "in_use")
+		ch <- prometheus.MustNewConstMetric(poolConnections, prometheus.GaugeValue, float64(s.Idle), s.Kind, s.Name, s.Instance, "idle")
+		ch <- prometheus.MustNewConstMetric(poolOpen, prometheus.GaugeValue, float64(s.Open), s.Kind, s.Name, s.Instance)
+		ch <- prometheus.MustNewConstMetric(poolWaits, prometheus.CounterValue, float64(s.WaitCount), s.Kind, s.Name, s.Instance)
+		ch <- prometheus.MustNewConstMetric(poolWaitSeconds, prometheus.CounterValue, s.WaitDuration.Seconds(), s.Kind, s.Name, s.Instance)
+		ch <- prometheus.MustNewConstMetric(poolHits, prometheus.CounterValue, float64(s.Hits), s.Kind, s.Name, s.Instance)
+		ch <- prometheus.MustNewConstMetric(poolMisses, prometheus.CounterValue, float64(s.Misses), s.Kind, s.Name, s.Instance)
+		ch <- prometheus.MustNewConstMetric(poolTimeouts, prometheus.CounterValue, float64(s.Timeouts), s.Kind, s.Name, s.Instance)
+	}
+}
@@ -0,0 +1,27 @@
+package gomicro
+
+import (
+	"context"
+
+	"github.com/micro/go-micro/server"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// HealthCheckFn 返回服务的状态，service为空表示整个服务，否则为组件名
+type HealthCheckFn func(ctx context.Context, service string) grpc_health_v1.HealthCheckResponse_ServingStatus
+
+// Health 标准gRPC健康检查服务 grpc.health.v1.Health 的实现，只支持 Check
+// go-micro 按结构体名路由，/grpc.health.v1.Health/Check 路由到 Health.Check
+type Health struct {
+	check HealthCheckFn
+}
+
+func (h *Health) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest, rsp *grpc_health_v1.HealthCheckResponse) error {
+	rsp.Status = h.check(ctx, req.GetService())
+	return nil
+}
+
+// RegisterHealthHandler 在server上注册 grpc.health.v1.Health 服务
+func RegisterHealthHandler(s server.Server, fn HealthCheckFn) error {
+	return s.Handle(s.NewHandler(&Health{check: fn}))
+}
@@ -0,0 +1,65 @@
+package gomicro
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/micro/go-micro/client"
+	gmerrors "github.com/micro/go-micro/errors"
+	"github.com/micro/go-micro/server"
+
+	"github.com/elvisNg/broccoli/engine"
+	broccolierrors "github.com/elvisNg/broccoli/errors"
+	"github.com/elvisNg/broccoli/metrics"
+	"github.com/elvisNg/broccoli/utils"
+)
+
+// GenerateServerMetricsWrap 记录go-micro服务端请求数、耗时和errcode
+func GenerateServerMetricsWrap(ng engine.Engine) func(fn server.HandlerFunc) server.HandlerFunc {
+	return func(fn server.HandlerFunc) server.HandlerFunc {
+		return func(ctx context.Context, req server.Request, rsp interface{}) (err error) {
+			start := time.Now()
+			err = fn(ctx, req, rsp)
+			metrics.ObserveRPC("server", req.Service(), req.Endpoint(), errCode(err), time.Since(start))
+			return
+		}
+	}
+}
+
+// GenerateClientMetricsWrap 记录go-micro客户端调用数、耗时和errcode
+func GenerateClientMetricsWrap(ng engine.Engine) func(c client.Client) client.Client {
+	return func(c client.Client) client.Client {
+		return &clientMetricsWrap{
+			Client: c,
+		}
+	}
+}
+
+type clientMetricsWrap struct {
+	client.Client
+}
+
+func (m *clientMetricsWrap) Call(ctx context.Context, req client.Request, rsp interface{}, opts ...client.CallOption) (err error) {
+	start := time.Now()
+	err = m.Client.Call(ctx, req, rsp, opts...)
+	metrics.ObserveRPC("client", req.Service(), req.Endpoint(), errCode(err), time.Since(start))
+	return
+}
+
+// errCode 错误对应的errcode，非broccoli和gomicro错误记为 ECodeSystem
+func errCode(err error) int32 {
+	if err == nil || utils.IsBlank(reflect.ValueOf(err)) {
+		return int32(broccolierrors.ECodeSuccessed)
+	}
+	var broccoliErr *broccolierrors.Error
+	if errors.As(err, &broccoliErr) {
+		return int32(broccoliErr.ErrCode)
+	}
+	var gmErr *gmerrors.Error
+	if errors.As(err, &gmErr) {
+		return gmErr.Code
+	}
+	return int32(broccolierrors.ECodeSystem)
+}
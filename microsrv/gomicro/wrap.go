@@ -82,6 +82,18 @@ func GenerateServerLogWrap(ng engine.Engine) func(fn server.HandlerFunc) server.
 			if ng.GetContainer().GetMongo() != nil {
 				c = broccolictx.MongoToContext(c, ng.GetContainer().GetMongo())
 			}
+			if rs := ng.GetContainer().GetRedisSource(); len(rs) > 0 {
+				c = broccolictx.RedisSourceToContext(c, rs)
+			}
+			if ms := ng.GetContainer().GetMongoSource(); len(ms) > 0 {
+				c = broccolictx.MongoSourceToContext(c, ms)
+			}
+			if ng.GetContainer().GetMysql() != nil {
+				c = broccolictx.MysqlToContext(c, ng.GetContainer().GetMysql())
+			}
+			if ms := ng.GetContainer().GetMysqlSource(); len(ms) > 0 {
+				c = broccolictx.MysqlSourceToContext(c, ms)
+			}
 			err = fn(c, req, rsp)
 			if err != nil && !utils.IsBlank(reflect.ValueOf(err)) {
 				span.SetTag("grpc server answer error", err)
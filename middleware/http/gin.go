@@ -7,6 +7,7 @@ import (
 	broccolictx "github.com/elvisNg/broccoli/context"
 	"github.com/elvisNg/broccoli/engine"
 	broccolierrors "github.com/elvisNg/broccoli/errors"
+	"github.com/elvisNg/broccoli/metrics"
 	"github.com/elvisNg/broccoli/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/golang/protobuf/jsonpb"
@@ -17,6 +18,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"reflect"
+	"time"
 )
 
 const BROCCOLI_CTX = "broccolictx"
@@ -78,6 +80,7 @@ func Access(ng engine.Engine) gin.HandlerFunc {
 		logger := ng.GetContainer().GetLogger()
 		ctx := c.Request.Context()
 		l := logger.WithFields(logrus.Fields{"tag": "gin"})
+		start := time.Now()
 		////// zipkin begin
 		cfg, err := ng.GetConfiger()
 		if err != nil {
@@ -137,11 +140,34 @@ func Access(ng engine.Engine) gin.HandlerFunc {
 		if ng.GetContainer().GetMysql() != nil {
 			ctx = broccolictx.MysqlToContext(ctx, ng.GetContainer().GetMysql())
 		}
+		if rs := ng.GetContainer().GetRedisSource(); len(rs) > 0 {
+			ctx = broccolictx.RedisSourceToContext(ctx, rs)
+		}
+		if ms := ng.GetContainer().GetMongoSource(); len(ms) > 0 {
+			ctx = broccolictx.MongoSourceToContext(ctx, ms)
+		}
+		if ms := ng.GetContainer().GetMysqlSource(); len(ms) > 0 {
+			ctx = broccolictx.MysqlSourceToContext(ctx, ms)
+		}
 		c.Set(BROCCOLI_CTX, ctx)
 		l.Debugln("access start", c.Request.URL.Path)
 		c.Next()
 		l.Debugln("access end", c.Request.URL.Path)
+		observeAccess(c, time.Since(start))
+	}
+}
+
+// observeAccess 记录请求的数量、耗时和错误码，按handler名称统计，避免路径参数导致标签过多
+func observeAccess(c *gin.Context, d time.Duration) {
+	errcode := broccolierrors.ECodeSuccessed
+	if v, ok := c.Get(BROCCOLI_HTTP_ERR); ok {
+		if err, ok := v.(error); ok {
+			if e := assertError(err); e != nil {
+				errcode = e.ErrCode
+			}
+		}
 	}
+	metrics.ObserveHTTP("gin", c.Request.Method, c.HandlerName(), c.Writer.Status(), int32(errcode), d)
 }
 
 func ExtractLogger(c *gin.Context) *logrus.Entry {
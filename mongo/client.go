@@ -8,13 +8,31 @@ import (
 	"sync"
 	"time"
 
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/metrics"
 )
 
-const defaultClient = "default"
+const (
+	defaultClient = "default"
+	drainTimeout  = 30 * time.Second // Reload 后等待旧连接归还的最长时间
+)
+
+// commandMonitor 记录mongo命令的数量、耗时和等待回复的数量，驱动未提供连接池状态
+var commandMonitor = &event.CommandMonitor{
+	Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+		metrics.MongoCommandStarted()
+	},
+	Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+		metrics.MongoCommandFinished(e.CommandName, true, time.Duration(e.DurationNanos))
+	},
+	Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+		metrics.MongoCommandFinished(e.CommandName, false, time.Duration(e.DurationNanos))
+	},
+}
 
 type lconfig struct {
 	Name            string
@@ -70,16 +88,24 @@ func newLocal(conf *lconfig) (c *Client, err error) {
 		options.Client().ApplyURI(uri),
 		options.Client().SetMaxConnIdleTime(conf.MaxConnIdleTime),
 		options.Client().SetMaxPoolSize(conf.MaxPoolSize),
+		options.Client().SetMonitor(commandMonitor),
 	)
 	if err != nil {
 		log.Printf("mongo new client failed: %s\n", err.Error())
 		return
 	}
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 	if err = cl.Connect(ctx); err != nil {
 		log.Printf("mongo connect failed: %s\n", err.Error())
 		return
 	}
+	// Connect 不等待连接建立，ping 确认可用
+	if err = cl.Ping(ctx, nil); err != nil {
+		log.Printf("mongo ping failed: %s\n", err.Error())
+		cl.Disconnect(context.Background())
+		return
+	}
 	tmp.C = cl
 	c = tmp
 	return
@@ -91,6 +117,11 @@ func (c *Client) DB(name string, opts ...*options.DatabaseOptions) *mongo.Databa
 	return c.C.Database(name, opts...)
 }
 
+// Close 等待使用中的连接归还后断开，ctx 结束时不再等待
+func (c *Client) Close(ctx context.Context) error {
+	return c.C.Disconnect(ctx)
+}
+
 func newMgr() *ClientMgr {
 	cs := make(map[string]*Client)
 	return &ClientMgr{
@@ -114,67 +145,197 @@ func (mgr *ClientMgr) Get(name string) *Client {
 	return nil
 }
 
+// Remove 从mgr中移除client，不断开连接
+func (mgr *ClientMgr) Remove(name string) *Client {
+	mgr.rw.Lock()
+	defer mgr.rw.Unlock()
+	c := mgr.clients[name]
+	delete(mgr.clients, name)
+	return c
+}
+
 var DefaultMgoMgr *ClientMgr
 
 var onceDefaultInit sync.Once
 
-func InitDefalut(conf *config.MongoDB) *ClientMgr {
+// swap 替换client，返回原来的client
+func (mgr *ClientMgr) swap(name string, c *Client) *Client {
+	mgr.rw.Lock()
+	defer mgr.rw.Unlock()
+	old := mgr.clients[name]
+	mgr.clients[name] = c
+	return old
+}
+
+func defaultMgr() *ClientMgr {
 	onceDefaultInit.Do(func() {
 		// init mongo mgr
 		DefaultMgoMgr = newMgr()
-		if mgoCli, err := New(conf); err != nil {
-			panic(err)
-		} else {
-			DefaultMgoMgr.Add(defaultClient, mgoCli)
-		}
 	})
-	log.Println("init default mongo client")
 	return DefaultMgoMgr
 }
 
+// InitDefalut 初始化默认client，已初始化时不重复创建
+func InitDefalut(conf *config.MongoDB) *ClientMgr {
+	mgr := defaultMgr()
+	if mgr.Get(defaultClient) != nil {
+		return mgr
+	}
+	if mgoCli, err := New(conf); err != nil {
+		panic(err)
+	} else {
+		mgr.Add(defaultClient, mgoCli)
+	}
+	log.Println("init default mongo client")
+	return mgr
+}
+
+// ReloadDefault 使用新配置创建client后替换，旧client在使用中的连接归还后断开
 func ReloadDefault(conf *config.MongoDB) *ClientMgr {
 	if DefaultMgoMgr == nil {
 		log.Println("DefaultMgoMgr未初始化")
 		return nil
 	}
+	log.Println("mongo client ReloadDefault host: ", conf.Host)
+	if _, err := reload(defaultClient, conf); err != nil {
+		panic(err)
+	}
+	return DefaultMgoMgr
+}
 
-	DefaultMgoMgr.rw.Lock()
-	defer DefaultMgoMgr.rw.Unlock()
+// InitSource 初始化 MongoDBSource 中指定名称的client，加入 DefaultMgoMgr
+func InitSource(name string, conf *config.MongoDB) (*Client, error) {
+	if name == defaultClient {
+		return nil, fmt.Errorf("mongodb_source name %q is reserved", defaultClient)
+	}
+	mgr := defaultMgr()
+	if c := mgr.Get(name); c != nil {
+		return c, nil
+	}
+	c, err := New(conf)
+	if err != nil {
+		return nil, err
+	}
+	mgr.Add(name, c)
+	log.Printf("init mongo client %s\n", name)
+	return c, nil
+}
 
-	// 释放客户端
-	if dc, ok := DefaultMgoMgr.clients[defaultClient]; ok {
-		if err := dc.C.Disconnect(context.Background()); err != nil {
-			log.Println("mongo client ReloadDefault Disconnect err: ", err)
-			return DefaultMgoMgr
-		}
+// ReloadSource 使用新配置替换指定名称的client，旧client在使用中的连接归还后断开
+func ReloadSource(name string, conf *config.MongoDB) (*Client, error) {
+	if name == defaultClient {
+		return nil, fmt.Errorf("mongodb_source name %q is reserved", defaultClient)
 	}
+	defaultMgr()
+	log.Printf("mongo client ReloadSource %s host: %s\n", name, conf.Host)
+	return reload(name, conf)
+}
 
-	log.Println("mongo client ReloadDefault appConf.MongoDB: ", conf)
-	// init mongo mgr
-	tmp := newMgr()
-	if mgoCli, err := New(conf); err != nil {
-		panic(err)
-	} else {
-		tmp.Add(defaultClient, mgoCli)
+// RemoveSource 从 DefaultMgoMgr 移除指定名称的client，不断开连接，由调用方关闭
+func RemoveSource(name string) *Client {
+	if DefaultMgoMgr == nil {
+		return nil
+	}
+	return DefaultMgoMgr.Remove(name)
+}
+
+// CloseSource 移除并断开指定名称的client
+func CloseSource(ctx context.Context, name string) error {
+	if DefaultMgoMgr == nil {
+		return errors.New("DefaultMgoMgr未初始化")
+	}
+	c := RemoveSource(name)
+	if c == nil {
+		return nil
+	}
+	log.Printf("release mongo client %s\n", name)
+	return c.Close(ctx)
+}
+
+func reload(name string, conf *config.MongoDB) (*Client, error) {
+	mgoCli, err := New(conf)
+	if err != nil {
+		return nil, err
+	}
+	old := DefaultMgoMgr.swap(name, mgoCli)
+	if old != nil {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+			defer cancel()
+			if err := old.Close(ctx); err != nil {
+				log.Printf("mongo client %s Disconnect err: %s\n", name, err)
+			}
+		}()
+	}
+	return mgoCli, nil
+}
+
+// Replace 使用新配置创建指定名称的client后替换，返回新client和被替换的client，被替换的client由调用方关闭
+func Replace(name string, conf *config.MongoDB) (c, old *Client, err error) {
+	mgr := defaultMgr()
+	if c, err = New(conf); err != nil {
+		return nil, nil, err
+	}
+	old = mgr.swap(name, c)
+	log.Printf("replace mongo client %s host: %s\n", name, conf.Host)
+	return c, old, nil
+}
+
+// Release 断开client，client 仍是指定名称的当前client时先从 DefaultMgoMgr 移除
+func Release(ctx context.Context, name string, c *Client) error {
+	if c == nil {
+		return nil
+	}
+	if DefaultMgoMgr != nil {
+		DefaultMgoMgr.removeIf(name, c)
+	}
+	return c.Close(ctx)
+}
+
+// ReplaceDefault 使用新配置替换默认client，被替换的client由调用方关闭
+func ReplaceDefault(conf *config.MongoDB) (c, old *Client, err error) {
+	return Replace(defaultClient, conf)
+}
+
+// ReleaseDefault 断开默认client，仍是当前默认client时先移除
+func ReleaseDefault(ctx context.Context, c *Client) error {
+	return Release(ctx, defaultClient, c)
+}
+
+// removeIf client 是指定名称的当前client时移除
+func (mgr *ClientMgr) removeIf(name string, c *Client) {
+	mgr.rw.Lock()
+	defer mgr.rw.Unlock()
+	if mgr.clients[name] == c {
+		delete(mgr.clients, name)
 	}
-	DefaultMgoMgr.clients = tmp.clients
-	return DefaultMgoMgr
 }
 
 func DefaultClient() (*Client, error) {
 	if DefaultMgoMgr == nil {
 		return nil, errors.New("DefaultMgoMgr未初始化")
 	}
-	return DefaultMgoMgr.Get(defaultClient), nil
+	c := DefaultMgoMgr.Get(defaultClient)
+	if c == nil {
+		return nil, errors.New("default mongo client未初始化或已释放")
+	}
+	return c, nil
+}
+
+// RemoveDefault 从 DefaultMgoMgr 移除默认client，不断开连接，由调用方关闭
+func RemoveDefault() *Client {
+	return RemoveSource(defaultClient)
+}
+
+// CloseDefault 移除并断开默认client，再次 InitDefalut 时重新创建
+func CloseDefault(ctx context.Context) error {
+	return CloseSource(ctx, defaultClient)
 }
 
 func DefaultClientRelease() {
-	if DefaultMgoMgr == nil {
-		log.Println("DefaultMgoMgr未初始化")
-		return
-	}
-	if err := DefaultMgoMgr.Get(defaultClient).C.Disconnect(context.Background()); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := CloseDefault(ctx); err != nil {
 		log.Println("mongo DefaultClientRelease err: ", err)
 	}
-	log.Println("release default mongo client")
 }
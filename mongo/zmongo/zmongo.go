@@ -1,10 +1,13 @@
 package zmongo
 
 import (
+	"context"
+
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type Mongo interface {
 	DB(name string, opts ...*options.DatabaseOptions) *mongo.Database
+	Close(ctx context.Context) error
 }
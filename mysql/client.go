@@ -1,15 +1,26 @@
 package mysqlclient
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	conf "github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/mysql/zmysql"
 	"github.com/jinzhu/gorm"
 	"log"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const driverName = "mysql"
+const (
+	driverName   = "mysql"
+	pingInterval = 2 * time.Second
+	pingTimeout  = time.Second      // 单次ping的超时，避免一个从库阻塞其他从库的摘除和恢复
+	drainTimeout = 30 * time.Second // Reload 后等待旧连接上的查询完成的最长时间
+)
 
 type DataSource struct {
 	Host            string
@@ -23,26 +34,259 @@ type DataSource struct {
 	MaxOpenConns    int
 }
 
+// Client 主库用于写操作和事务，配置了从库时读操作在健康的从库间轮询
 type Client struct {
-	client *gorm.DB
-	rw     sync.RWMutex
+	cluster *cluster
+	rw      sync.RWMutex
+}
+
+// cluster 一份配置对应的主从连接，Reload 时整体替换
+type cluster struct {
+	primary  *gorm.DB
+	replicas []*replica
+	next     uint32
+	cancel   context.CancelFunc // 停止健康检查协程
 }
 
-func (dbs *Client) Reload(cfg *conf.Mysql) {
+type replica struct {
+	host    string
+	mu      sync.RWMutex
+	db      *gorm.DB // 启动时连接失败为nil，由健康检查重新连接
+	healthy int32    // 1 健康，健康检查失败时置0，不再分发读操作
+}
+
+func (r *replica) get() *gorm.DB {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.db
+}
+
+// Reload 使用新配置创建连接后替换，旧连接在进行中的查询完成后关闭
+// 新配置的主库连接失败时返回错误，继续使用旧连接
+func (dbs *Client) Reload(cfg *conf.Mysql) error {
+	c, err := newCluster(cfg)
+	if err != nil {
+		return err
+	}
 	dbs.rw.Lock()
-	defer dbs.rw.Unlock()
-	if err := dbs.client.Close(); err != nil {
-		log.Printf("redis close failed: %s\n", err.Error())
-		return
+	old := dbs.cluster
+	dbs.cluster = c
+	dbs.rw.Unlock()
+	log.Printf("[mysql.Reload] mysqlclient reload with new conf, host: %s, replicas: %s\n", cfg.Host, cfg.Replicas)
+	if old == nil {
+		return nil
 	}
-	log.Printf("[redis.Reload] redisclient reload with new conf: %+v\n", cfg)
-	dbs.client = newMysqlClient(cfg)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		if err := old.close(ctx); err != nil {
+			log.Printf("[mysql.Reload] close old client failed: %s\n", err)
+		}
+	}()
+	return nil
 }
 
+// InitClient 创建客户端，主库连接失败时panic，需要处理错误时使用 NewClient
 func InitClient(sqlconf *conf.Mysql) *Client {
-	rds := new(Client)
-	rds.client = newMysqlClient(sqlconf)
-	return rds
+	dbs, err := NewClient(sqlconf)
+	if err != nil {
+		panic(fmt.Sprintf("mysql gorm init  failed:%s", err.Error()))
+	}
+	return dbs
+}
+
+// NewClient 创建客户端，主库连接失败时返回错误，从库连接失败时不分发读操作，由健康检查重新连接
+func NewClient(sqlconf *conf.Mysql) (*Client, error) {
+	c, err := newCluster(sqlconf)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{cluster: c}, nil
+}
+
+// Close 停止健康检查，等待进行中的查询完成后关闭主从连接，ctx 结束时不再等待
+func (dbs *Client) Close(ctx context.Context) error {
+	dbs.rw.Lock()
+	c := dbs.cluster
+	dbs.cluster = nil
+	dbs.rw.Unlock()
+	if c == nil {
+		return errors.New("mysql client already closed")
+	}
+	return c.close(ctx)
+}
+
+func (dbs *Client) current() *cluster {
+	dbs.rw.RLock()
+	defer dbs.rw.RUnlock()
+	return dbs.cluster
+}
+
+// GetCli 主库，写操作和事务使用
+func (dbs *Client) GetCli() *gorm.DB {
+	c := dbs.current()
+	if c == nil {
+		return nil
+	}
+	return c.primary
+}
+
+// GetReadCli 读操作使用，在健康的从库间轮询
+// 未配置从库、从库都不可用或ctx通过 zmysql.WithPrimary 指定时返回主库
+func (dbs *Client) GetReadCli(ctx context.Context) *gorm.DB {
+	c := dbs.current()
+	if c == nil {
+		return nil
+	}
+	if ctx != nil && zmysql.IsPrimary(ctx) {
+		return c.primary
+	}
+	return c.read()
+}
+
+// Stats 连接池状态，key 为 primary 或从库的host
+func (dbs *Client) Stats() map[string]sql.DBStats {
+	c := dbs.current()
+	if c == nil {
+		return nil
+	}
+	m := map[string]sql.DBStats{"primary": c.primary.DB().Stats()}
+	for _, r := range c.replicas {
+		if db := r.get(); db != nil {
+			m[r.host] = db.DB().Stats()
+		}
+	}
+	return m
+}
+
+func newCluster(cfg *conf.Mysql) (*cluster, error) {
+	primary, err := openMysql(cfg, cfg.Host)
+	if err != nil {
+		log.Printf("[mysql.newCluster] open primary %s failed: %s\n", cfg.Host, err)
+		return nil, err
+	}
+	c := &cluster{primary: primary}
+	for _, host := range strings.Split(cfg.Replicas, ",") {
+		if host = strings.TrimSpace(host); host == "" {
+			continue
+		}
+		db, err := openMysql(cfg, host)
+		if err != nil {
+			// 从库不可用不影响启动，读操作使用其他从库或主库，由健康检查重新连接
+			log.Printf("[mysql.newCluster] open replica %s failed: %s\n", host, err)
+			c.replicas = append(c.replicas, &replica{host: host})
+			continue
+		}
+		c.replicas = append(c.replicas, &replica{host: host, db: db, healthy: 1})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	go c.healthCheck(ctx, cfg)
+	return c, nil
+}
+
+func (c *cluster) read() *gorm.DB {
+	n := len(c.replicas)
+	if n == 0 {
+		return c.primary
+	}
+	start := atomic.AddUint32(&c.next, 1)
+	for i := 0; i < n; i++ {
+		r := c.replicas[(int(start)+i)%n]
+		if atomic.LoadInt32(&r.healthy) == 1 {
+			if db := r.get(); db != nil {
+				return db
+			}
+		}
+	}
+	return c.primary
+}
+
+// healthCheck 定时ping主从库，修复BadConnections，从库ping失败时摘除，恢复后重新加入
+// 启动时连接失败的从库在这里重新连接
+func (c *cluster) healthCheck(ctx context.Context, cfg *conf.Mysql) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		if err := ping(ctx, c.primary); err != nil && ctx.Err() == nil {
+			log.Printf("[mysql.healthCheck] primary ping err: %s\n", err)
+		}
+		for _, r := range c.replicas {
+			err := r.check(ctx, cfg)
+			if ctx.Err() != nil {
+				return
+			}
+			healthy := int32(1)
+			if err != nil {
+				healthy = 0
+			}
+			if atomic.SwapInt32(&r.healthy, healthy) != healthy {
+				if err != nil {
+					log.Printf("[mysql.healthCheck] replica %s ejected, err: %s\n", r.host, err)
+				} else {
+					log.Printf("[mysql.healthCheck] replica %s recovered\n", r.host)
+				}
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check 未连接时重新连接，否则ping
+func (r *replica) check(ctx context.Context, cfg *conf.Mysql) error {
+	if db := r.get(); db != nil {
+		return ping(ctx, db)
+	}
+	db, err := openMysql(cfg, r.host)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ctx.Err() != nil {
+		// 已开始关闭，close 不会再关闭这个连接
+		db.Close()
+		return ctx.Err()
+	}
+	r.db = db
+	return nil
+}
+
+func ping(ctx context.Context, db *gorm.DB) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	return db.DB().PingContext(ctx)
+}
+
+// close sql.DB.Close 会等待已开始的查询完成
+func (c *cluster) close(ctx context.Context) error {
+	c.cancel()
+	done := make(chan error, 1)
+	go func() {
+		err := c.primary.Close()
+		for _, r := range c.replicas {
+			r.mu.Lock()
+			db := r.db
+			r.mu.Unlock()
+			if db == nil {
+				continue
+			}
+			if e := db.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("mysql close timeout: %s", ctx.Err())
+	}
 }
 
 /*func New(sqlconf *conf.Mysql) DataSource {
@@ -69,14 +313,9 @@ func InitClient(sqlconf *conf.Mysql) *Client {
 	return ds
 }*/
 
-func (dbs *Client) GetCli() *gorm.DB {
-	return dbs.client
-}
-
-func newMysqlClient(cfg *conf.Mysql) *gorm.DB {
+func openMysql(cfg *conf.Mysql, host string) (*gorm.DB, error) {
 	url := "%v:%v@(%v)/%v?charset=%v&parseTime=%v&loc=Local"
 	//user:password@/dbname?charset=utf8&parseTime=True&loc=Local
-	host := cfg.Host
 	userName := cfg.User
 	passWord := cfg.Pwd
 	dbName := cfg.DataSourceName
@@ -84,25 +323,13 @@ func newMysqlClient(cfg *conf.Mysql) *gorm.DB {
 	parseTime := cfg.ParseTime
 	url = fmt.Sprintf(url, userName, passWord, host, dbName, charSet, parseTime)
 	_db, err := gorm.Open(driverName, url)
-
 	if err != nil {
-		println("mysql gorm init err(%+v) ", err)
-		panic(fmt.Sprintf("mysql gorm init  failed:%s", err.Error()))
+		return nil, err
 	}
 	//全局禁用表名复数
 	_db.SingularTable(true) //如果设置为true,`User`的默认表名为`user`,使用`TableName`设置的表名不受影响
 	_db.DB().SetMaxOpenConns(cfg.MaxOpenConns)
 	_db.DB().SetMaxIdleConns(cfg.MaxIdleConns)
 	_db.DB().SetConnMaxLifetime(cfg.ConnMaxLifetime)
-
-	// 监听修复BadConnections
-	go func() {
-		for {
-			if err := _db.DB().Ping(); err != nil {
-				println("mysql gorm ping err(%+v) ", err)
-			}
-			time.Sleep(2 * time.Second)
-		}
-	}()
-	return _db
+	return _db, nil
 }
@@ -0,0 +1,47 @@
+package mysqlclient
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/elvisNg/broccoli/mysql/zmysql"
+)
+
+func TestGetReadCli(t *testing.T) {
+	primary, r1, r2 := &gorm.DB{}, &gorm.DB{}, &gorm.DB{}
+	c := &Client{cluster: &cluster{
+		primary: primary,
+		replicas: []*replica{
+			{host: "r1", db: r1, healthy: 1},
+			{host: "r2", db: r2, healthy: 1},
+		},
+	}}
+	ctx := context.Background()
+
+	got := map[*gorm.DB]int{}
+	for i := 0; i < 4; i++ {
+		got[c.GetReadCli(ctx)]++
+	}
+	if got[r1] != 2 || got[r2] != 2 {
+		t.Errorf("reads not balanced across replicas: r1=%d r2=%d", got[r1], got[r2])
+	}
+	if c.GetReadCli(zmysql.WithPrimary(ctx)) != primary {
+		t.Errorf("WithPrimary should read from primary")
+	}
+
+	c.cluster.replicas[0].healthy = 0
+	for i := 0; i < 3; i++ {
+		if db := c.GetReadCli(ctx); db != r2 {
+			t.Fatalf("ejected replica r1 should not serve reads")
+		}
+	}
+	c.cluster.replicas[1].healthy = 0
+	if c.GetReadCli(ctx) != primary {
+		t.Errorf("no healthy replica should fall back to primary")
+	}
+	if c.GetCli() != primary {
+		t.Errorf("GetCli should return primary")
+	}
+}
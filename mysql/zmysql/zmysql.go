@@ -1,11 +1,34 @@
 package zmysql
 
 import (
+	"context"
+	"database/sql"
+
 	"github.com/elvisNg/broccoli/config"
 	"github.com/jinzhu/gorm"
 )
 
 type Mysql interface {
-	Reload(cfg *config.Mysql)
+	// Reload 新配置连接失败时返回错误，继续使用原连接
+	Reload(cfg *config.Mysql) error
+	// GetCli 主库，写操作和事务使用
 	GetCli() *gorm.DB
+	// GetReadCli 读操作使用，配置了从库时在健康的从库间轮询，ctx 通过 WithPrimary 指定时使用主库
+	GetReadCli(ctx context.Context) *gorm.DB
+	// Stats 连接池状态，key 为 primary 或从库的host
+	Stats() map[string]sql.DBStats
+	Close(ctx context.Context) error
+}
+
+type ctxPrimaryMarker struct{}
+
+// WithPrimary 返回的ctx上的读操作使用主库，用于写后立即读等不能接受从库延迟的场景
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxPrimaryMarker{}, true)
+}
+
+// IsPrimary ctx 是否指定读操作使用主库
+func IsPrimary(ctx context.Context) bool {
+	v, _ := ctx.Value(ctxPrimaryMarker{}).(bool)
+	return v
 }
@@ -0,0 +1,667 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/opentracing/opentracing-go"
+
+	"github.com/elvisNg/broccoli/config"
+	broccolilog "github.com/elvisNg/broccoli/log"
+	broccolimongo "github.com/elvisNg/broccoli/mongo"
+	broccolimysql "github.com/elvisNg/broccoli/mysql"
+	"github.com/elvisNg/broccoli/mysql/zmysql"
+	"github.com/elvisNg/broccoli/plugin/component"
+	zbroker "github.com/elvisNg/broccoli/pubsub/broker"
+	zpub "github.com/elvisNg/broccoli/pubsub/pub"
+	zsub "github.com/elvisNg/broccoli/pubsub/sub"
+	broccoliredis "github.com/elvisNg/broccoli/redis"
+	"github.com/elvisNg/broccoli/redis/zredis"
+	tracing "github.com/elvisNg/broccoli/trace"
+	"github.com/elvisNg/broccoli/trace/zipkin"
+)
+
+// 内置组件名，可通过 Container.GetComponent/Component 获取
+const (
+	ComponentRedis       = "redis"
+	ComponentRedisSource = "redis_source"
+	ComponentLogger      = "logger"
+	ComponentTracer      = "tracer"
+	ComponentMongo       = "mongodb"
+	ComponentMongoSource = "mongodb_source"
+	ComponentMysql       = "mysql"
+	ComponentMysqlSource = "mysql_source"
+	// 由 AppConf.Broker 的 enable_pub/enable_sub 启用
+	ComponentPublisher        = "publisher"
+	ComponentSubscriber       = "subscriber"
+	ComponentPublisherSource  = "publisher_source"
+	ComponentSubscriberSource = "subscriber_source"
+)
+
+// builtinFactories 内置组件，按初始化顺序
+func builtinFactories() []component.Factory {
+	return []component.Factory{
+		{
+			Name:    ComponentRedis,
+			Section: "redis",
+			Init: func(conf *config.AppConf) (interface{}, error) {
+				if !conf.Redis.Enable {
+					return nil, nil
+				}
+				return newRedis(&conf.Redis)
+			},
+			Required: func(conf *config.AppConf) bool { return conf.Redis.Required },
+			Reload: func(old interface{}, conf *config.AppConf) (interface{}, error) {
+				if !conf.Redis.Enable {
+					return nil, nil
+				}
+				return old, old.(zredis.Redis).Reload(&conf.Redis)
+			},
+			Close: func(ctx context.Context, instance interface{}) error {
+				return instance.(zredis.Redis).Close(ctx)
+			},
+			Health: func(ctx context.Context, instance interface{}) error {
+				return pingRedis(ctx, instance)
+			},
+		},
+		{
+			Name:    ComponentRedisSource,
+			Section: "redis_source",
+			Init: func(conf *config.AppConf) (interface{}, error) {
+				s := &source{
+					kind:     "redis",
+					enabled:  func(cfg interface{}) bool { return cfg.(config.Redis).Enable },
+					required: func(cfg interface{}) bool { return cfg.(config.Redis).Required },
+					init: func(name string, cfg interface{}) (interface{}, error) {
+						c := cfg.(config.Redis)
+						return newRedis(&c)
+					},
+					reload: func(name string, cli, cfg interface{}) (interface{}, error) {
+						c := cfg.(config.Redis)
+						return cli, cli.(zredis.Redis).Reload(&c)
+					},
+					close: func(ctx context.Context, name string, cli interface{}) error {
+						return cli.(zredis.Redis).Close(ctx)
+					},
+					health: pingRedis,
+				}
+				s.apply(conf.RedisSource, true)
+				return s, nil
+			},
+			Reload: func(old interface{}, conf *config.AppConf) (interface{}, error) {
+				old.(*source).apply(conf.RedisSource, false)
+				return old, nil
+			},
+			Close: func(ctx context.Context, instance interface{}) error {
+				return instance.(*source).closeAll(ctx)
+			},
+			Health: func(ctx context.Context, instance interface{}) error {
+				return instance.(*source).healthAll(ctx)
+			},
+		},
+		{
+			Name:    ComponentLogger,
+			Section: "log_conf",
+			Init: func(conf *config.AppConf) (interface{}, error) {
+				return broccolilog.New(&conf.LogConf)
+			},
+			Close: func(ctx context.Context, instance interface{}) error {
+				return instance.(*broccolilog.LogBuilder).Close(ctx)
+			},
+		},
+		{
+			Name:    ComponentTracer,
+			Section: "trace",
+			Init: func(conf *config.AppConf) (interface{}, error) {
+				tracer, collector, err := zipkin.NewTracer(&conf.Trace)
+				if err != nil {
+					return nil, err
+				}
+				opentracing.SetGlobalTracer(tracer)
+				return tracing.NewTracerWrap(tracer, collector), nil
+			},
+			Close: func(ctx context.Context, instance interface{}) error {
+				return instance.(*tracing.TracerWrap).Close(ctx)
+			},
+		},
+		{
+			Name:    ComponentMongo,
+			Section: "mongodb",
+			Init: func(conf *config.AppConf) (interface{}, error) {
+				if !conf.MongoDB.Enable {
+					return nil, nil
+				}
+				cli, old, err := broccolimongo.ReplaceDefault(&conf.MongoDB)
+				if err != nil {
+					return nil, err
+				}
+				if old != nil {
+					release(ComponentMongo, old.Close)
+				}
+				return cli, nil
+			},
+			Required: func(conf *config.AppConf) bool { return conf.MongoDB.Required },
+			// 替换后旧client由容器在后台断开
+			Reload: func(old interface{}, conf *config.AppConf) (interface{}, error) {
+				if !conf.MongoDB.Enable {
+					// 先移除，重新启用时 InitDefalut 不会取到正在释放的client
+					broccolimongo.RemoveDefault()
+					return nil, nil
+				}
+				cli, _, err := broccolimongo.ReplaceDefault(&conf.MongoDB)
+				if err != nil {
+					return nil, err
+				}
+				return cli, nil
+			},
+			Close: func(ctx context.Context, instance interface{}) error {
+				return broccolimongo.ReleaseDefault(ctx, instance.(*broccolimongo.Client))
+			},
+			Health: func(ctx context.Context, instance interface{}) error {
+				return instance.(*broccolimongo.Client).C.Ping(ctx, nil)
+			},
+		},
+		{
+			Name:    ComponentMongoSource,
+			Section: "mongodb_source",
+			Init: func(conf *config.AppConf) (interface{}, error) {
+				s := &source{
+					kind:     "mongo",
+					enabled:  func(cfg interface{}) bool { return cfg.(config.MongoDB).Enable },
+					required: func(cfg interface{}) bool { return cfg.(config.MongoDB).Required },
+					init: func(name string, cfg interface{}) (interface{}, error) {
+						c := cfg.(config.MongoDB)
+						return broccolimongo.InitSource(name, &c)
+					},
+					reload: func(name string, cli, cfg interface{}) (interface{}, error) {
+						c := cfg.(config.MongoDB)
+						n, _, err := broccolimongo.Replace(name, &c)
+						if err != nil {
+							return nil, err
+						}
+						return n, nil
+					},
+					detach: func(name string, cli interface{}) {
+						broccolimongo.RemoveSource(name)
+					},
+					close: func(ctx context.Context, name string, cli interface{}) error {
+						return broccolimongo.Release(ctx, name, cli.(*broccolimongo.Client))
+					},
+					health: func(ctx context.Context, cli interface{}) error {
+						return cli.(*broccolimongo.Client).C.Ping(ctx, nil)
+					},
+				}
+				s.apply(conf.MongoDBSource, true)
+				return s, nil
+			},
+			Reload: func(old interface{}, conf *config.AppConf) (interface{}, error) {
+				old.(*source).apply(conf.MongoDBSource, false)
+				return old, nil
+			},
+			Close: func(ctx context.Context, instance interface{}) error {
+				return instance.(*source).closeAll(ctx)
+			},
+			Health: func(ctx context.Context, instance interface{}) error {
+				return instance.(*source).healthAll(ctx)
+			},
+		},
+		{
+			Name:    ComponentMysql,
+			Section: "mysql",
+			Init: func(conf *config.AppConf) (interface{}, error) {
+				if !conf.Mysql.Enable {
+					return nil, nil
+				}
+				return newMysql(&conf.Mysql)
+			},
+			Required: func(conf *config.AppConf) bool { return conf.Mysql.Required },
+			Reload: func(old interface{}, conf *config.AppConf) (interface{}, error) {
+				if !conf.Mysql.Enable {
+					return nil, nil
+				}
+				return old, old.(zmysql.Mysql).Reload(&conf.Mysql)
+			},
+			Close: func(ctx context.Context, instance interface{}) error {
+				return instance.(zmysql.Mysql).Close(ctx)
+			},
+			Health: func(ctx context.Context, instance interface{}) error {
+				return pingMysql(ctx, instance)
+			},
+		},
+		{
+			Name:    ComponentMysqlSource,
+			Section: "mysql_source",
+			Init: func(conf *config.AppConf) (interface{}, error) {
+				s := &source{
+					kind:     "mysql",
+					enabled:  func(cfg interface{}) bool { return cfg.(config.Mysql).Enable },
+					required: func(cfg interface{}) bool { return cfg.(config.Mysql).Required },
+					init: func(name string, cfg interface{}) (interface{}, error) {
+						c := cfg.(config.Mysql)
+						return newMysql(&c)
+					},
+					reload: func(name string, cli, cfg interface{}) (interface{}, error) {
+						c := cfg.(config.Mysql)
+						return cli, cli.(zmysql.Mysql).Reload(&c)
+					},
+					close: func(ctx context.Context, name string, cli interface{}) error {
+						return cli.(zmysql.Mysql).Close(ctx)
+					},
+					health: pingMysql,
+				}
+				s.apply(conf.MysqlSource, true)
+				return s, nil
+			},
+			Reload: func(old interface{}, conf *config.AppConf) (interface{}, error) {
+				old.(*source).apply(conf.MysqlSource, false)
+				return old, nil
+			},
+			Close: func(ctx context.Context, instance interface{}) error {
+				return instance.(*source).closeAll(ctx)
+			},
+			Health: func(ctx context.Context, instance interface{}) error {
+				return instance.(*source).healthAll(ctx)
+			},
+		},
+		{
+			Name:    ComponentPublisher,
+			Section: "broker",
+			Init: func(conf *config.AppConf) (interface{}, error) {
+				if !conf.Broker.EnablePub {
+					return nil, nil
+				}
+				return newPublisher(&conf.Broker)
+			},
+			Required: func(conf *config.AppConf) bool { return conf.Broker.Required },
+			Reload: func(old interface{}, conf *config.AppConf) (interface{}, error) {
+				if !conf.Broker.EnablePub {
+					return nil, nil
+				}
+				return old, old.(zpub.Publisher).Reload(&conf.Broker)
+			},
+			Close: func(ctx context.Context, instance interface{}) error {
+				return instance.(zpub.Publisher).Close(ctx)
+			},
+			Health: brokerHealth,
+		},
+		{
+			Name:    ComponentSubscriber,
+			Section: "broker",
+			Init: func(conf *config.AppConf) (interface{}, error) {
+				if !conf.Broker.EnableSub {
+					return nil, nil
+				}
+				return newSubscriber(&conf.Broker)
+			},
+			Required: func(conf *config.AppConf) bool { return conf.Broker.Required },
+			Reload: func(old interface{}, conf *config.AppConf) (interface{}, error) {
+				if !conf.Broker.EnableSub {
+					return nil, nil
+				}
+				return old, old.(zsub.Subscriber).Reload(&conf.Broker)
+			},
+			Close: func(ctx context.Context, instance interface{}) error {
+				return instance.(zsub.Subscriber).Close(ctx)
+			},
+			Health: brokerHealth,
+		},
+		brokerSourceFactory(ComponentPublisherSource, "publisher",
+			func(cfg config.Broker) bool { return cfg.EnablePub },
+			func(cfg *config.Broker) (interface{}, error) { return newPublisher(cfg) },
+			func(cli interface{}, cfg *config.Broker) error { return cli.(zpub.Publisher).Reload(cfg) },
+			func(ctx context.Context, cli interface{}) error { return cli.(zpub.Publisher).Close(ctx) },
+		),
+		brokerSourceFactory(ComponentSubscriberSource, "subscriber",
+			func(cfg config.Broker) bool { return cfg.EnableSub },
+			func(cfg *config.Broker) (interface{}, error) { return newSubscriber(cfg) },
+			func(cli interface{}, cfg *config.Broker) error { return cli.(zsub.Subscriber).Reload(cfg) },
+			func(ctx context.Context, cli interface{}) error { return cli.(zsub.Subscriber).Close(ctx) },
+		),
+	}
+}
+
+// brokerSourceFactory BrokerSource 中按名称管理的发布者或订阅者
+func brokerSourceFactory(name, kind string, enabled func(cfg config.Broker) bool,
+	init func(cfg *config.Broker) (interface{}, error),
+	reload func(cli interface{}, cfg *config.Broker) error,
+	close func(ctx context.Context, cli interface{}) error) component.Factory {
+	return component.Factory{
+		Name:    name,
+		Section: "broker_source",
+		Init: func(conf *config.AppConf) (interface{}, error) {
+			s := &source{
+				kind:     kind,
+				enabled:  func(cfg interface{}) bool { return enabled(cfg.(config.Broker)) },
+				required: func(cfg interface{}) bool { return cfg.(config.Broker).Required },
+				init: func(name string, cfg interface{}) (interface{}, error) {
+					c := cfg.(config.Broker)
+					return init(&c)
+				},
+				reload: func(name string, cli, cfg interface{}) (interface{}, error) {
+					c := cfg.(config.Broker)
+					return cli, reload(cli, &c)
+				},
+				close: func(ctx context.Context, name string, cli interface{}) error {
+					return close(ctx, cli)
+				},
+				health: brokerHealth,
+			}
+			s.apply(conf.BrokerSource, true)
+			return s, nil
+		},
+		Reload: func(old interface{}, conf *config.AppConf) (interface{}, error) {
+			old.(*source).apply(conf.BrokerSource, false)
+			return old, nil
+		},
+		Close: func(ctx context.Context, instance interface{}) error {
+			return instance.(*source).closeAll(ctx)
+		},
+		Health: func(ctx context.Context, instance interface{}) error {
+			return instance.(*source).healthAll(ctx)
+		},
+	}
+}
+
+// brokerHealth 连接broker失败时初始化失败并在后台重试，已关闭、重新订阅失败或broker连接断开的不可用
+func brokerHealth(ctx context.Context, instance interface{}) error {
+	switch v := instance.(type) {
+	case zpub.Publisher:
+		cli := v.GetClient()
+		if cli == nil {
+			return errors.New("publisher closed")
+		}
+		return zbroker.Ping(ctx, cli.Options().Broker)
+	case zsub.Subscriber:
+		srv := v.GetServer()
+		if srv == nil {
+			return errors.New("subscriber not running")
+		}
+		return zbroker.Ping(ctx, srv.Options().Broker)
+	}
+	return nil
+}
+
+func newPublisher(cfg *config.Broker) (interface{}, error) {
+	p, err := zpub.NewPublisher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func newSubscriber(cfg *config.Broker) (interface{}, error) {
+	s, err := zsub.NewSubscriber(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// newRedis/newMysql 出错时返回nil接口，避免包含nil指针的实例
+func newRedis(cfg *config.Redis) (interface{}, error) {
+	cli, err := broccoliredis.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return cli, nil
+}
+
+func newMysql(cfg *config.Mysql) (interface{}, error) {
+	cli, err := broccolimysql.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return cli, nil
+}
+
+func pingRedis(ctx context.Context, cli interface{}) error {
+	rdc := cli.(zredis.Redis).GetCli()
+	if rdc == nil {
+		return errors.New("redis client is nil")
+	}
+	return rdc.WithContext(ctx).Ping().Err()
+}
+
+func pingMysql(ctx context.Context, cli interface{}) error {
+	db := cli.(zmysql.Mysql).GetCli()
+	if db == nil {
+		return errors.New("mysql client is nil")
+	}
+	return db.DB().PingContext(ctx)
+}
+
+// source 按名称管理的一组client，如 RedisSource，每个名称独立初始化、重新加载和释放
+type source struct {
+	kind     string
+	enabled  func(cfg interface{}) bool
+	required func(cfg interface{}) bool
+	init     func(name string, cfg interface{}) (interface{}, error)
+	// reload 返回的client与原client不同时，原client在后台释放
+	reload func(name string, cli, cfg interface{}) (interface{}, error)
+	// detach 删除或禁用时同步调用，可为nil，如从 DefaultMgoMgr 移除，避免重新启用时取到正在释放的client
+	detach func(name string, cli interface{})
+	close  func(ctx context.Context, name string, cli interface{}) error
+	health func(ctx context.Context, cli interface{}) error
+
+	mu      sync.RWMutex
+	cfgs    map[string]interface{}
+	clis    map[string]interface{}
+	pending map[string]*pending // 初始化失败、正在后台重试的client
+	waiting []*pending          // 启动时 required 的client的重试，由 await 等待
+}
+
+// apply 新增或启用的初始化，配置变化的重新加载，删除或禁用的释放，cfgs 为 map[string]T
+// 初始化失败时在后台重试，启动时 required 的client由 await 等待可用
+func (s *source) apply(cfgs interface{}, startup bool) {
+	next := make(map[string]interface{})
+	if v := reflect.ValueOf(cfgs); v.Kind() == reflect.Map {
+		iter := v.MapRange()
+		for iter.Next() {
+			next[iter.Key().String()] = iter.Value().Interface()
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clis == nil {
+		s.clis = make(map[string]interface{})
+	}
+	if s.pending == nil {
+		s.pending = make(map[string]*pending)
+	}
+	for name, p := range s.pending {
+		if cfg, ok := next[name]; !ok || !s.enabled(cfg) || !reflect.DeepEqual(s.cfgs[name], cfg) {
+			close(p.stop)
+			delete(s.pending, name)
+		}
+	}
+	for name, cli := range s.clis {
+		if cfg, ok := next[name]; !ok || !s.enabled(cfg) {
+			log.Printf("[Container.source] release %s %s\n", s.kind, name)
+			if s.detach != nil {
+				s.detach(name, cli)
+			}
+			s.release(name, cli)
+			delete(s.clis, name)
+		}
+	}
+	s.waiting = nil
+	// 重新加载失败的client保留原配置，相同配置再次推送时重试
+	applied := make(map[string]interface{}, len(next))
+	for name, cfg := range next {
+		applied[name] = cfg
+		if !s.enabled(cfg) {
+			continue
+		}
+		if _, ok := s.pending[name]; ok {
+			continue
+		}
+		cli, ok := s.clis[name]
+		if ok && reflect.DeepEqual(s.cfgs[name], cfg) {
+			continue
+		}
+		var n interface{}
+		var err error
+		if ok {
+			log.Printf("[Container.source] reload %s %s\n", s.kind, name)
+			n, err = s.reload(name, cli, cfg)
+		} else {
+			log.Printf("[Container.source] init %s %s\n", s.kind, name)
+			n, err = s.init(name, cfg)
+		}
+		if err != nil {
+			log.Printf("[Container.source] %s %s err: %s\n", s.kind, name, err)
+			if ok {
+				applied[name] = s.cfgs[name]
+			} else {
+				s.retry(name, cfg, err, startup && s.required != nil && s.required(cfg))
+			}
+			continue
+		}
+		if ok && !sameInstance(cli, n) {
+			s.release(name, cli)
+		}
+		s.clis[name] = n
+	}
+	s.cfgs = applied
+}
+
+// retry 在后台重试初始化失败的client，wait为true时由 await 等待，调用方持有s.mu
+func (s *source) retry(name string, cfg interface{}, err error, wait bool) {
+	p := newPending(err)
+	s.pending[name] = p
+	if wait {
+		log.Printf("[Container.source] %s %s is required, wait until it is available\n", s.kind, name)
+		s.waiting = append(s.waiting, p)
+	}
+	go func() {
+		var cli interface{}
+		ok := retry(p.stop, func() (err error) {
+			if cli, err = s.init(name, cfg); err != nil {
+				log.Printf("[Container.source] retry %s %s err: %s\n", s.kind, name, err)
+				p.setErr(err)
+			}
+			return
+		})
+		if !ok {
+			return
+		}
+		s.mu.Lock()
+		current := s.pending[name] == p
+		if current {
+			delete(s.pending, name)
+			s.clis[name] = cli
+		}
+		// 重试期间配置已变化，InitSource 等可能返回已在使用的client，此时不释放
+		stale := !current && s.clis[name] != cli
+		s.mu.Unlock()
+		if stale {
+			s.release(name, cli)
+			return
+		}
+		if current {
+			close(p.ready)
+		}
+		log.Printf("[Container.source] %s %s is available\n", s.kind, name)
+	}()
+}
+
+// await 等待启动时 required 的client可用，不持有s.mu，stop关闭或重试停止时返回false
+func (s *source) await(stop <-chan struct{}) bool {
+	s.mu.RLock()
+	waiting := s.waiting
+	s.mu.RUnlock()
+	for _, p := range waiting {
+		if !p.await(stop) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *source) release(name string, cli interface{}) {
+	release(s.kind+" "+name, func(ctx context.Context) error {
+		return s.close(ctx, name, cli)
+	})
+}
+
+// get 指定名称的client，未启用时返回nil
+func (s *source) get(name string) interface{} {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.clis[name]
+}
+
+// all 启用的client
+func (s *source) all() map[string]interface{} {
+	m := make(map[string]interface{})
+	if s == nil {
+		return m
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for name, cli := range s.clis {
+		m[name] = cli
+	}
+	return m
+}
+
+func sortedNames(m map[string]interface{}) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// closeAll 停止重试，关闭所有client，返回第一个错误
+func (s *source) closeAll(ctx context.Context) (err error) {
+	s.mu.Lock()
+	for name, p := range s.pending {
+		close(p.stop)
+		delete(s.pending, name)
+	}
+	s.mu.Unlock()
+	m := s.all()
+	for _, name := range sortedNames(m) {
+		if e := s.close(ctx, name, m[name]); e != nil {
+			log.Printf("[Container.source] close %s %s err: %s\n", s.kind, name, e)
+			if err == nil {
+				err = e
+			}
+		}
+	}
+	s.mu.Lock()
+	s.clis, s.cfgs = nil, nil
+	s.mu.Unlock()
+	return
+}
+
+// healthAll 检查所有client，返回第一个不可用的client的错误，正在重试的client不可用
+func (s *source) healthAll(ctx context.Context) error {
+	var err error
+	s.mu.RLock()
+	for name, p := range s.pending {
+		err = fmt.Errorf("%s %s: %s", s.kind, name, p.Err())
+		break
+	}
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	m := s.all()
+	for _, name := range sortedNames(m) {
+		if err := s.health(ctx, m[name]); err != nil {
+			return fmt.Errorf("%s %s: %s", s.kind, name, err)
+		}
+	}
+	return nil
+}
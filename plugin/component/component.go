@@ -0,0 +1,154 @@
+package component
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/utils"
+)
+
+// Factory 组件的生命周期函数，由容器在加载和重新加载配置时调用
+// 实例为nil表示组件未启用
+type Factory struct {
+	// Name 组件名，通过 Container.GetComponent/Component 获取实例
+	Name string
+
+	// Section 组件的配置分区，AppConf中"."分隔的json路径，如 redis、components.es、ext.es
+	// 该分区变化时调用 Reload，为空时每次重新加载配置都调用
+	Section string
+
+	// Init 创建实例，返回nil表示未启用
+	// 出错时提供 Health 的组件由容器在后台按指数退避重试，重试期间健康检查返回不可用
+	Init func(conf *config.AppConf) (interface{}, error)
+
+	// Required 返回true时启动阻塞直到 Init 成功，可为nil
+	Required func(conf *config.AppConf) bool
+
+	// Reload 配置分区变化时调用，old 不为nil
+	// 返回的实例与old不同时，容器在后台调用 Close 释放old；返回nil表示禁用；出错时继续使用old
+	// 为nil时调用 Init，成功后容器释放old
+	Reload func(old interface{}, conf *config.AppConf) (interface{}, error)
+
+	// Close 关闭实例，等待进行中的请求完成，ctx 结束时不再等待，可为nil
+	Close func(ctx context.Context, instance interface{}) error
+
+	// Health 检查实例是否可用，用于就绪检查，可为nil
+	Health func(ctx context.Context, instance interface{}) error
+}
+
+var (
+	mu        sync.RWMutex
+	factories []Factory
+)
+
+// Register 注册组件，通常在组件包的init中调用
+// 容器按注册顺序初始化，按相反顺序关闭，内置组件先于注册的组件初始化
+func Register(f Factory) error {
+	if utils.IsEmptyString(f.Name) {
+		return errors.New("component name is empty")
+	}
+	if f.Init == nil {
+		return fmt.Errorf("component %s: Init is nil", f.Name)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, r := range factories {
+		if r.Name == f.Name {
+			return fmt.Errorf("component %s already registered", f.Name)
+		}
+	}
+	factories = append(factories, f)
+	log.Printf("[broccoli] [component.Register] component %s registered, section: %s\n", f.Name, f.Section)
+	return nil
+}
+
+// MustRegister 注册失败时panic
+func MustRegister(f Factory) {
+	if err := Register(f); err != nil {
+		panic(err)
+	}
+}
+
+// Factories 已注册的组件，按注册顺序
+func Factories() []Factory {
+	mu.RLock()
+	defer mu.RUnlock()
+	fs := make([]Factory, len(factories))
+	copy(fs, factories)
+	return fs
+}
+
+// Section 获取配置分区的值，结构与json一致，分区不存在时返回nil
+func Section(conf *config.AppConf, path string) (interface{}, error) {
+	if conf == nil {
+		return nil, nil
+	}
+	b, err := utils.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	var root interface{}
+	if err = utils.Unmarshal(b, &root); err != nil {
+		return nil, err
+	}
+	return Lookup(root, path), nil
+}
+
+// Lookup 按"."分隔的路径获取json结构中的值，不存在时返回nil，path为空时返回root
+func Lookup(root interface{}, path string) interface{} {
+	if utils.IsEmptyString(path) {
+		return root
+	}
+	v := root
+	for _, seg := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if v, ok = m[seg]; !ok {
+			return nil
+		}
+	}
+	return v
+}
+
+// Decode 将配置分区解析到out，分区不存在时out保持不变
+func Decode(conf *config.AppConf, path string, out interface{}) error {
+	v, err := Section(conf, path)
+	if err != nil || v == nil {
+		return err
+	}
+	b, err := utils.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err = utils.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("decode %s failed: %s", path, err)
+	}
+	return nil
+}
+
+// Assign 将实例赋值给out，out为指向实例类型或其实现的接口类型的指针
+// 如 var es *elastic.Client; component.Assign(instance, &es)
+func Assign(instance interface{}, out interface{}) error {
+	ov := reflect.ValueOf(out)
+	if ov.Kind() != reflect.Ptr || ov.IsNil() {
+		return errors.New("out must be a non-nil pointer")
+	}
+	if instance == nil {
+		return errors.New("component not enabled")
+	}
+	iv := reflect.ValueOf(instance)
+	dst := ov.Elem()
+	if !iv.Type().AssignableTo(dst.Type()) {
+		return fmt.Errorf("component type %s is not assignable to %s", iv.Type(), dst.Type())
+	}
+	dst.Set(iv)
+	return nil
+}
@@ -0,0 +1,73 @@
+package component
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/elvisNg/broccoli/config"
+)
+
+func TestRegister(t *testing.T) {
+	initFn := func(conf *config.AppConf) (interface{}, error) { return nil, nil }
+	tests := []struct {
+		f       Factory
+		wantErr bool
+	}{
+		{Factory{Name: "test_es", Section: "components.es", Init: initFn}, false},
+		{Factory{Name: "test_es", Init: initFn}, true},
+		{Factory{Name: "", Init: initFn}, true},
+		{Factory{Name: "test_nil"}, true},
+	}
+	for _, tt := range tests {
+		if err := Register(tt.f); (err != nil) != tt.wantErr {
+			t.Errorf("Register(%q) err = %v, wantErr %v", tt.f.Name, err, tt.wantErr)
+		}
+	}
+	if fs := Factories(); len(fs) != 1 || fs[0].Name != "test_es" {
+		t.Errorf("Factories() = %+v", fs)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	conf := &config.AppConf{
+		Redis:      config.Redis{Host: "r"},
+		Ext:        map[string]interface{}{"obs": map[string]interface{}{"bucket": "b1"}},
+		Components: map[string]interface{}{"es": map[string]interface{}{"url": "http://es", "shards": 3}},
+	}
+	var es struct {
+		URL    string `json:"url"`
+		Shards int    `json:"shards"`
+	}
+	if err := Decode(conf, "components.es", &es); err != nil || es.URL != "http://es" || es.Shards != 3 {
+		t.Errorf("Decode(components.es) = %+v, %v", es, err)
+	}
+	var bucket string
+	if err := Decode(conf, "ext.obs.bucket", &bucket); err != nil || bucket != "b1" {
+		t.Errorf("Decode(ext.obs.bucket) = %q, %v", bucket, err)
+	}
+	if v, _ := Section(conf, "redis.host"); v != "r" {
+		t.Errorf("Section(redis.host) = %v", v)
+	}
+	if v, _ := Section(conf, "components.missing.url"); v != nil {
+		t.Errorf("Section(components.missing.url) = %v, want nil", v)
+	}
+}
+
+func TestAssign(t *testing.T) {
+	var f *os.File
+	if err := Assign(os.Stdout, &f); err != nil || f != os.Stdout {
+		t.Errorf("Assign(*os.File) = %v", err)
+	}
+	var w io.Writer
+	if err := Assign(os.Stdout, &w); err != nil || w != os.Stdout {
+		t.Errorf("Assign(io.Writer) = %v", err)
+	}
+	var s string
+	if err := Assign(os.Stdout, &s); err == nil {
+		t.Error("Assign to string: want error")
+	}
+	if err := Assign(nil, &f); err == nil {
+		t.Error("Assign(nil): want error")
+	}
+}
@@ -0,0 +1,56 @@
+package plugin
+
+import (
+	"github.com/elvisNg/broccoli/metrics"
+	"github.com/elvisNg/broccoli/mysql/zmysql"
+	"github.com/elvisNg/broccoli/redis/zredis"
+)
+
+// PoolStats redis和mysql启用的client的连接池状态，用于 metrics.RegisterPoolStats
+func (c *Container) PoolStats() []metrics.PoolStats {
+	var stats []metrics.PoolStats
+	addRedis := func(name string, r zredis.Redis) {
+		cli := r.GetCli()
+		if cli == nil {
+			return
+		}
+		ps := cli.PoolStats()
+		stats = append(stats, metrics.PoolStats{
+			Kind:     ComponentRedis,
+			Name:     name,
+			Open:     int(ps.TotalConns),
+			InUse:    int(ps.TotalConns) - int(ps.IdleConns),
+			Idle:     int(ps.IdleConns),
+			Hits:     uint64(ps.Hits),
+			Misses:   uint64(ps.Misses),
+			Timeouts: uint64(ps.Timeouts),
+		})
+	}
+	addMysql := func(name string, m zmysql.Mysql) {
+		for instance, s := range m.Stats() {
+			stats = append(stats, metrics.PoolStats{
+				Kind:         ComponentMysql,
+				Name:         name,
+				Instance:     instance,
+				Open:         s.OpenConnections,
+				InUse:        s.InUse,
+				Idle:         s.Idle,
+				WaitCount:    s.WaitCount,
+				WaitDuration: s.WaitDuration,
+			})
+		}
+	}
+	if r := c.GetRedisCli(); r != nil {
+		addRedis("", r)
+	}
+	for name, r := range c.GetRedisSource() {
+		addRedis(name, r)
+	}
+	if m := c.GetMysql(); m != nil {
+		addMysql("", m)
+	}
+	for name, m := range c.GetMysqlSource() {
+		addMysql(name, m)
+	}
+	return stats
+}
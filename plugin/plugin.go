@@ -1,41 +1,49 @@
 package plugin
 
 import (
+	"context"
+	"fmt"
 	"github.com/elvisNg/broccoli/mysql/zmysql"
 	"log"
 	"net/http"
+	"reflect"
+	"sync"
+	"time"
 
 	"github.com/micro/go-micro"
 	"github.com/micro/go-micro/client"
-	"github.com/opentracing/opentracing-go"
 	"github.com/sirupsen/logrus"
 
 	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/health"
+	"github.com/elvisNg/broccoli/lifecycle"
 	broccolilog "github.com/elvisNg/broccoli/log"
-	broccolimongo "github.com/elvisNg/broccoli/mongo"
 	"github.com/elvisNg/broccoli/mongo/zmongo"
-	broccolimysql "github.com/elvisNg/broccoli/mysql"
-	broccoliredis "github.com/elvisNg/broccoli/redis"
+	"github.com/elvisNg/broccoli/plugin/component"
+	zpub "github.com/elvisNg/broccoli/pubsub/pub"
+	zsub "github.com/elvisNg/broccoli/pubsub/sub"
 	"github.com/elvisNg/broccoli/redis/zredis"
 	"github.com/elvisNg/broccoli/sequence"
 	tracing "github.com/elvisNg/broccoli/trace"
-	"github.com/elvisNg/broccoli/trace/zipkin"
 )
 
+// releaseTimeout 禁用或替换组件后，等待旧实例上进行中的请求完成的最长时间
+const releaseTimeout = 30 * time.Second
+
 // Container contain comm obj, impl zcontainer
 type Container struct {
 	serviceID     string
-	appcfg        config.AppConf
-	redis         zredis.Redis
-	mongo         zmongo.Mongo
+	applied       map[string]interface{} // 组件名 -> 已生效的配置分区，重新加载失败时保留原分区，相同配置再次推送时重试
+	lifecycle     sync.Mutex // 串行化 Init/Reload/Close
+	mu            sync.RWMutex
+	factories     []component.Factory    // 内置组件和注册的组件，按初始化顺序
+	instances     map[string]interface{} // 组件名 -> 实例，未启用的组件不在其中
+	pending       map[string]*pending    // 初始化失败、正在后台重试的组件
 	gomicroClient client.Client
-	logger        *logrus.Logger
-	tracer        *tracing.TracerWrap
 	// http
 	httpHandler http.Handler
 	// gomicro grpc
 	gomicroService micro.Service
-	mysql          zmysql.Mysql
 
 	// dbPool          *sql.DB
 	// transport       *http.Transport
@@ -47,86 +55,225 @@ func NewContainer() *Container {
 	return &Container{}
 }
 
+// activeFactories 内置组件和 component.Register 注册的组件，重名的注册组件被忽略
+func activeFactories() []component.Factory {
+	fs := builtinFactories()
+	seen := make(map[string]bool, len(fs))
+	for _, f := range fs {
+		seen[f.Name] = true
+	}
+	for _, f := range component.Factories() {
+		if seen[f.Name] {
+			log.Printf("[Container] component %s conflicts with builtin, ignored\n", f.Name)
+			continue
+		}
+		seen[f.Name] = true
+		fs = append(fs, f)
+	}
+	return fs
+}
+
 func (c *Container) Init(appcfg *config.AppConf) {
 	log.Println("[Container.Init] start")
-	c.initRedis(&appcfg.Redis)
-	c.initLogger(&appcfg.LogConf)
-	c.initTracer(&appcfg.Trace)
-	c.initMongo(&appcfg.MongoDB)
-	c.initMysql(&appcfg.Mysql)
+	c.lifecycle.Lock()
+	defer c.lifecycle.Unlock()
+	factories := activeFactories()
+	c.mu.Lock()
+	c.factories = factories
+	c.instances = make(map[string]interface{}, len(factories))
+	c.pending = make(map[string]*pending)
+	c.mu.Unlock()
+	root, err := component.Section(appcfg, "")
+	if err != nil {
+		log.Println("[Container.Init] err:", err)
+	}
+	c.applied = make(map[string]interface{}, len(factories))
+	for _, f := range factories {
+		if c.start(f, appcfg, true) {
+			c.applied[f.Name] = component.Lookup(root, f.Section)
+		}
+		w := c.awaiter(f, appcfg)
+		if w == nil {
+			continue
+		}
+		// 等待 Required 的组件可用，等待期间不持有 c.lifecycle，收到停止信号或 Close 时放弃
+		c.lifecycle.Unlock()
+		ok := w.await(lifecycle.Done())
+		c.lifecycle.Lock()
+		if !ok {
+			log.Printf("[Container.Init] stop waiting for %s\n", f.Name)
+			return
+		}
+	}
 	log.Println("[Container.Init] finish")
-	c.appcfg = *appcfg
 }
 
+// Reload 按初始化顺序重新加载配置分区变化的组件
 func (c *Container) Reload(appcfg *config.AppConf) {
 	log.Println("[Container.Reload] start")
-	if c.appcfg.Redis != appcfg.Redis {
-		c.reloadRedis(&appcfg.Redis)
+	c.lifecycle.Lock()
+	defer c.lifecycle.Unlock()
+	next, err := component.Section(appcfg, "")
+	if err != nil {
+		log.Println("[Container.Reload] err:", err)
+		return
 	}
-	if c.appcfg.LogConf != appcfg.LogConf {
-		c.reloadLogger(&appcfg.LogConf)
+	for _, f := range c.factories {
+		section := component.Lookup(next, f.Section)
+		if prev, ok := c.applied[f.Name]; ok && f.Section != "" && reflect.DeepEqual(prev, section) {
+			continue
+		}
+		if c.reload(f, appcfg) {
+			c.applied[f.Name] = section
+		}
 	}
-	if c.appcfg.Trace != appcfg.Trace {
-		c.reloadTracer(&appcfg.Trace)
+	log.Println("[Container.Reload] finish")
+}
+
+// reload 未初始化或正在重试的按新配置初始化，否则调用 Reload，被替换或禁用的实例在后台释放
+// 出错时保留原实例并返回false
+func (c *Container) reload(f component.Factory, appcfg *config.AppConf) bool {
+	old, ok := c.GetComponent(f.Name)
+	if !ok {
+		c.cancelPending(f.Name)
+		return c.start(f, appcfg, false)
 	}
-	if c.appcfg.MongoDB != appcfg.MongoDB {
-		c.reloadMongo(&appcfg.MongoDB)
+	var instance interface{}
+	var err error
+	switch {
+	case f.Reload != nil:
+		instance, err = f.Reload(old, appcfg)
+	default:
+		instance, err = f.Init(appcfg)
 	}
-	if c.appcfg.Mysql != appcfg.Mysql {
-		c.reloadMysql(&appcfg.Mysql)
+	if err != nil {
+		log.Printf("[Container.Reload] reload %s err: %s\n", f.Name, err)
+		return false
 	}
-	log.Println("[Container.Reload] finish")
-	c.appcfg = *appcfg
+	c.set(f.Name, instance)
+	if !sameInstance(old, instance) && f.Close != nil {
+		release(f.Name, func(ctx context.Context) error {
+			return f.Close(ctx, old)
+		})
+	}
+	return true
 }
 
-// Redis
-func (c *Container) initRedis(cfg *config.Redis) {
-	if cfg.Enable {
-		c.redis = broccoliredis.InitClient(cfg)
+func (c *Container) set(name string, instance interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if instance == nil {
+		delete(c.instances, name)
+		return
 	}
+	c.instances[name] = instance
 }
 
-func (c *Container) reloadRedis(cfg *config.Redis) {
-	if cfg.Enable {
-		if c.redis != nil {
-			c.redis.Reload(cfg)
-		} else {
-			c.redis = broccoliredis.InitClient(cfg)
+// GetComponent 获取组件实例，未启用时返回false
+func (c *Container) GetComponent(name string) (interface{}, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	instance, ok := c.instances[name]
+	return instance, ok
+}
+
+// Component 获取组件实例并赋值给out，out为指向实例类型或其实现的接口类型的指针
+func (c *Container) Component(name string, out interface{}) error {
+	instance, ok := c.GetComponent(name)
+	if !ok {
+		return fmt.Errorf("component %s not enabled", name)
+	}
+	return component.Assign(instance, out)
+}
+
+// Health 检查启用的组件，返回组件名 -> 检查结果，未提供 Health 的组件不在其中
+func (c *Container) Health(ctx context.Context) map[string]error {
+	res := make(map[string]error)
+	for name, fn := range c.HealthChecks() {
+		res[name] = fn(ctx)
+	}
+	return res
+}
+
+// HealthChecks 启用的组件的检查函数，组件名 -> 检查函数，未提供 Health 的组件不在其中
+// 初始化失败、正在后台重试的组件返回不可用
+func (c *Container) HealthChecks() map[string]health.CheckFunc {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	checks := make(map[string]health.CheckFunc)
+	for name, p := range c.pending {
+		p := p
+		checks[name] = func(ctx context.Context) error {
+			return p.Err()
 		}
-	} else if c.redis != nil {
-		// 释放
-		// c.redis.Release()
-		c.redis = nil
 	}
+	for _, f := range c.factories {
+		instance, ok := c.instances[f.Name]
+		if !ok || f.Health == nil {
+			continue
+		}
+		fn := f.Health
+		checks[f.Name] = func(ctx context.Context) error {
+			return fn(ctx, instance)
+		}
+	}
+	return checks
 }
 
+// Redis
 func (c *Container) GetRedisCli() zredis.Redis {
-	return c.redis
+	if cli, ok := c.GetComponent(ComponentRedis); ok {
+		return cli.(zredis.Redis)
+	}
+	return nil
 }
 
-// Mysql
-func (c *Container) initMysql(cfg *config.Mysql) {
-	if cfg.Enable {
-		c.mysql = broccolimysql.InitClient(cfg)
+func (c *Container) source(name string) *source {
+	if s, ok := c.GetComponent(name); ok {
+		return s.(*source)
 	}
+	return nil
 }
 
-func (c *Container) reloadMysql(cfg *config.Mysql) {
-	if cfg.Enable {
-		if c.mysql != nil {
-			c.mysql.Reload(cfg)
-		} else {
-			c.mysql = broccolimysql.InitClient(cfg)
-		}
-	} else if c.mysql != nil {
-		// 释放
-		// c.mysql.Release()
-		c.mysql = nil
+// GetRedisCliByName 获取 RedisSource 中指定名称的client，未启用时返回nil
+func (c *Container) GetRedisCliByName(name string) zredis.Redis {
+	if cli := c.source(ComponentRedisSource).get(name); cli != nil {
+		return cli.(zredis.Redis)
+	}
+	return nil
+}
+
+// GetRedisSource RedisSource 中启用的client
+func (c *Container) GetRedisSource() map[string]zredis.Redis {
+	clis := c.source(ComponentRedisSource).all()
+	m := make(map[string]zredis.Redis, len(clis))
+	for name, cli := range clis {
+		m[name] = cli.(zredis.Redis)
 	}
+	return m
 }
 
+// Mysql
 func (c *Container) GetMyslCli() zmysql.Mysql {
-	return c.mysql
+	return c.GetMysql()
+}
+
+// GetMysqlByName 获取 MysqlSource 中指定名称的client，未启用时返回nil
+func (c *Container) GetMysqlByName(name string) zmysql.Mysql {
+	if cli := c.source(ComponentMysqlSource).get(name); cli != nil {
+		return cli.(zmysql.Mysql)
+	}
+	return nil
+}
+
+// GetMysqlSource MysqlSource 中启用的client
+func (c *Container) GetMysqlSource() map[string]zmysql.Mysql {
+	clis := c.source(ComponentMysqlSource).all()
+	m := make(map[string]zmysql.Mysql, len(clis))
+	for name, cli := range clis {
+		m[name] = cli.(zmysql.Mysql)
+	}
+	return m
 }
 
 // GoMicroClient
@@ -139,21 +286,11 @@ func (c *Container) GetGoMicroClient() client.Client {
 }
 
 // Logger
-func (c *Container) initLogger(cfg *config.LogConf) {
-	l, err := broccolilog.New(cfg)
-	if err != nil {
-		log.Println("initLogger err:", err)
-		return
-	}
-	c.logger = l.Logger
-}
-
-func (c *Container) reloadLogger(cfg *config.LogConf) {
-	c.initLogger(cfg)
-}
-
 func (c *Container) GetLogger() *logrus.Logger {
-	return c.logger
+	if l, ok := c.GetComponent(ComponentLogger); ok {
+		return l.(*broccolilog.LogBuilder).Logger
+	}
+	return nil
 }
 
 // func (c *Container) SetDBPool(p *sql.DB) {
@@ -196,33 +333,66 @@ func (c *Container) GetLogger() *logrus.Logger {
 // 	return c.mqProducer
 // }
 
-// func (c *Container) Release() {
-// if c.redisPool != nil {
-// 	c.redisPool.Close()
-// }
-
-// if c.dbPool != nil {
-// 	c.dbPool.Close()
-// }
-// }
-
-// Tracer
-func (c *Container) initTracer(cfg *config.Trace) (err error) {
-	err = zipkin.InitTracer(cfg)
-	if err != nil {
-		log.Println("initTracer err:", err)
-		return
+// Close 按初始化的相反顺序关闭所有组件，每个组件等待进行中的请求完成，ctx 结束时不再等待
+func (c *Container) Close(ctx context.Context) (err error) {
+	log.Println("[Container.Close] start")
+	c.lifecycle.Lock()
+	defer c.lifecycle.Unlock()
+	c.mu.Lock()
+	factories, instances := c.factories, c.instances
+	c.instances = make(map[string]interface{})
+	for name, p := range c.pending {
+		close(p.stop)
+		delete(c.pending, name)
 	}
-	c.tracer = tracing.NewTracerWrap(opentracing.GlobalTracer())
+	c.mu.Unlock()
+	for i := len(factories) - 1; i >= 0; i-- {
+		f := factories[i]
+		instance, ok := instances[f.Name]
+		if !ok || f.Close == nil {
+			continue
+		}
+		if e := f.Close(ctx, instance); e != nil {
+			log.Printf("[Container.Close] close %s err: %s\n", f.Name, e)
+			if err == nil {
+				err = e
+			}
+		}
+	}
+	log.Println("[Container.Close] finish")
 	return
 }
 
-func (c *Container) reloadTracer(cfg *config.Trace) (err error) {
-	return c.initTracer(cfg)
+// release 在后台关闭被禁用或替换的组件实例，不阻塞配置的重新加载
+// sameInstance 判断重新加载返回的是否为原实例，实例的类型不可比较时视为已替换
+func sameInstance(old, instance interface{}) bool {
+	if old == nil || instance == nil {
+		return old == nil && instance == nil
+	}
+	if t := reflect.TypeOf(old); t != reflect.TypeOf(instance) || !t.Comparable() {
+		return false
+	}
+	return old == instance
 }
 
+func release(name string, closeFn func(ctx context.Context) error) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+		defer cancel()
+		if err := closeFn(ctx); err != nil {
+			log.Printf("[Container.release] release %s err: %s\n", name, err)
+			return
+		}
+		log.Printf("[Container.release] %s released\n", name)
+	}()
+}
+
+// Tracer
 func (c *Container) GetTracer() *tracing.TracerWrap {
-	return c.tracer
+	if t, ok := c.GetComponent(ComponentTracer); ok {
+		return t.(*tracing.TracerWrap)
+	}
+	return nil
 }
 
 func (c *Container) SetServiceID(id string) {
@@ -251,44 +421,78 @@ func (c *Container) GetGoMicroService() micro.Service {
 }
 
 // Mongo
-func (c *Container) initMongo(cfg *config.MongoDB) {
-	var err error
-	if cfg.Enable {
-		broccolimongo.InitDefalut(cfg)
-		c.mongo, err = broccolimongo.DefaultClient()
-		if err != nil {
-			log.Println("mgoc.DefaultClient err: ", err)
-			return
-		}
+// GetMongo ...
+func (c *Container) GetMongo() zmongo.Mongo {
+	if cli, ok := c.GetComponent(ComponentMongo); ok {
+		return cli.(zmongo.Mongo)
 	}
+	return nil
 }
 
-func (c *Container) reloadMongo(cfg *config.MongoDB) {
-	var err error
-	if cfg.Enable {
-		if c.mongo != nil {
-			broccolimongo.ReloadDefault(cfg)
-			c.mongo, err = broccolimongo.DefaultClient()
-			if err != nil {
-				log.Println("mgoc.DefaultClient err: ", err)
-				return
-			}
-		} else {
-			c.initMongo(cfg)
-		}
-	} else if c.mongo != nil {
-		// 释放
-		broccolimongo.DefaultClientRelease()
-		c.mongo = nil
+// GetMongoByName 获取 MongoDBSource 中指定名称的client，未启用时返回nil
+func (c *Container) GetMongoByName(name string) zmongo.Mongo {
+	if cli := c.source(ComponentMongoSource).get(name); cli != nil {
+		return cli.(zmongo.Mongo)
 	}
+	return nil
 }
 
-// GetMongo ...
-func (c *Container) GetMongo() zmongo.Mongo {
-	return c.mongo
+// GetMongoSource MongoDBSource 中启用的client
+func (c *Container) GetMongoSource() map[string]zmongo.Mongo {
+	clis := c.source(ComponentMongoSource).all()
+	m := make(map[string]zmongo.Mongo, len(clis))
+	for name, cli := range clis {
+		m[name] = cli.(zmongo.Mongo)
+	}
+	return m
 }
 
 //GetMysql
 func (c *Container) GetMysql() zmysql.Mysql {
-	return c.mysql
+	if cli, ok := c.GetComponent(ComponentMysql); ok {
+		return cli.(zmysql.Mysql)
+	}
+	return nil
+}
+
+// GetPublisher 由 Broker 配置创建的发布者，未启用 enable_pub 时返回nil
+func (c *Container) GetPublisher() zpub.Publisher {
+	if p, ok := c.GetComponent(ComponentPublisher); ok {
+		return p.(zpub.Publisher)
+	}
+	return nil
+}
+
+// GetPublisherByName BrokerSource 中指定名称的发布者，未启用时返回nil
+func (c *Container) GetPublisherByName(name string) zpub.Publisher {
+	if p := c.source(ComponentPublisherSource).get(name); p != nil {
+		return p.(zpub.Publisher)
+	}
+	return nil
+}
+
+// GetSubscriber 由 Broker 配置创建的订阅者，未启用 enable_sub 时返回nil
+func (c *Container) GetSubscriber() zsub.Subscriber {
+	if s, ok := c.GetComponent(ComponentSubscriber); ok {
+		return s.(zsub.Subscriber)
+	}
+	return nil
+}
+
+// GetSubscriberByName BrokerSource 中指定名称的订阅者，未启用时返回nil
+func (c *Container) GetSubscriberByName(name string) zsub.Subscriber {
+	if s := c.source(ComponentSubscriberSource).get(name); s != nil {
+		return s.(zsub.Subscriber)
+	}
+	return nil
+}
+
+// GetSubscriberSource BrokerSource 中启用订阅的订阅者
+func (c *Container) GetSubscriberSource() map[string]zsub.Subscriber {
+	subs := c.source(ComponentSubscriberSource).all()
+	m := make(map[string]zsub.Subscriber, len(subs))
+	for name, s := range subs {
+		m[name] = s.(zsub.Subscriber)
+	}
+	return m
 }
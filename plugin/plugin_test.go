@@ -0,0 +1,184 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/plugin/component"
+)
+
+// flaky 前failures次初始化失败的组件
+func flaky(name string, failures int32, required bool) component.Factory {
+	var calls int32
+	return component.Factory{
+		Name:    name,
+		Section: "components." + name,
+		Init: func(conf *config.AppConf) (interface{}, error) {
+			if atomic.AddInt32(&calls, 1) <= failures {
+				return nil, errors.New("connection refused")
+			}
+			return name + "-conn", nil
+		},
+		Required: func(conf *config.AppConf) bool { return required },
+		Health:   func(ctx context.Context, instance interface{}) error { return nil },
+	}
+}
+
+func TestDegradedStartup(t *testing.T) {
+	component.MustRegister(flaky("test_required", 1, true))
+	component.MustRegister(flaky("test_optional", 1, false))
+
+	c := NewContainer()
+	c.Init(&config.AppConf{})
+	defer c.Close(context.Background())
+
+	// required 的组件启动时阻塞直到可用
+	var conn string
+	if err := c.Component("test_required", &conn); err != nil || conn != "test_required-conn" {
+		t.Fatalf("required component = %q, %v", conn, err)
+	}
+	// 非 required 的组件不阻塞启动，重试期间不就绪
+	if _, ok := c.GetComponent("test_optional"); ok {
+		t.Fatal("optional component should not be ready before retry")
+	}
+	if err := c.HealthChecks()["test_optional"](context.Background()); err == nil {
+		t.Error("pending component should report not ready")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := c.GetComponent("test_optional"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("optional component not available after retry")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := c.HealthChecks()["test_optional"](context.Background()); err != nil {
+		t.Errorf("available component health = %v", err)
+	}
+}
+
+func TestSameInstance(t *testing.T) {
+	p := new(int)
+	m := map[string]int{}
+	tests := []struct {
+		old, instance interface{}
+		want          bool
+	}{
+		{p, p, true},
+		{p, new(int), false},
+		{m, m, false}, // map不可比较，视为已替换
+		{[]int{1}, []int{1}, false},
+		{p, m, false},
+		{p, nil, false},
+		{nil, nil, true},
+	}
+	for i, tt := range tests {
+		if got := sameInstance(tt.old, tt.instance); got != tt.want {
+			t.Errorf("%d: sameInstance() = %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestReloadRetry(t *testing.T) {
+	failures := int32(1)
+	component.MustRegister(component.Factory{
+		Name:    "test_reload",
+		Section: "components.test_reload",
+		Init: func(conf *config.AppConf) (interface{}, error) {
+			return conf.Components["test_reload"], nil
+		},
+		Reload: func(old interface{}, conf *config.AppConf) (interface{}, error) {
+			if atomic.AddInt32(&failures, -1) >= 0 {
+				return nil, errors.New("reload failed")
+			}
+			return conf.Components["test_reload"], nil
+		},
+	})
+
+	c := NewContainer()
+	c.Init(&config.AppConf{Components: map[string]interface{}{"test_reload": "a"}})
+	defer c.Close(context.Background())
+
+	// 重新加载失败时保留原实例，相同配置再次推送时重试
+	next := &config.AppConf{Components: map[string]interface{}{"test_reload": "b"}}
+	for i, want := range []string{"a", "b"} {
+		c.Reload(next)
+		var v string
+		if err := c.Component("test_reload", &v); err != nil || v != want {
+			t.Errorf("%d: component = %q, %v, want %q", i, v, err, want)
+		}
+	}
+}
+
+func TestSourceReloadRetry(t *testing.T) {
+	failures := int32(1)
+	s := &source{
+		kind:    "test",
+		enabled: func(cfg interface{}) bool { return true },
+		init:    func(name string, cfg interface{}) (interface{}, error) { return cfg, nil },
+		reload: func(name string, cli, cfg interface{}) (interface{}, error) {
+			if atomic.AddInt32(&failures, -1) >= 0 {
+				return nil, errors.New("reload failed")
+			}
+			return cfg, nil
+		},
+		close: func(ctx context.Context, name string, cli interface{}) error { return nil },
+	}
+	s.apply(map[string]string{"a": "v1"}, true)
+	defer s.closeAll(context.Background())
+
+	// 重新加载失败时保留原client，相同配置再次推送时重试
+	for i, want := range []string{"v1", "v2"} {
+		s.apply(map[string]string{"a": "v2"}, false)
+		if cli := s.get("a"); cli != want {
+			t.Errorf("%d: client = %v, want %s", i, cli, want)
+		}
+	}
+}
+
+func TestRequiredCloseWhileWaiting(t *testing.T) {
+	component.MustRegister(component.Factory{
+		Name:    "test_down",
+		Section: "components.test_down",
+		Init: func(conf *config.AppConf) (interface{}, error) {
+			return nil, errors.New("connection refused")
+		},
+		Required: func(conf *config.AppConf) bool { return conf.Components["test_down"] != nil },
+		Health:   func(ctx context.Context, instance interface{}) error { return nil },
+	})
+
+	c := NewContainer()
+	done := make(chan struct{})
+	go func() {
+		c.Init(&config.AppConf{Components: map[string]interface{}{"test_down": true}})
+		close(done)
+	}()
+
+	// 等待期间健康检查不阻塞，Close 结束等待
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if check, ok := c.HealthChecks()["test_down"]; ok {
+			if err := check(context.Background()); err == nil {
+				t.Error("waiting component should report not ready")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("required component not pending")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Close(context.Background())
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Init did not return after Close")
+	}
+}
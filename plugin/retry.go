@@ -0,0 +1,162 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/plugin/component"
+)
+
+const (
+	retryMinBackoff = time.Second
+	retryMaxBackoff = 30 * time.Second
+)
+
+// retry 按指数退避重试fn直到成功，stop关闭时放弃并返回false
+func retry(stop <-chan struct{}, fn func() error) bool {
+	backoff := retryMinBackoff
+	for {
+		select {
+		case <-stop:
+			return false
+		case <-time.After(backoff):
+		}
+		if fn() == nil {
+			return true
+		}
+		if backoff *= 2; backoff > retryMaxBackoff {
+			backoff = retryMaxBackoff
+		}
+	}
+}
+
+// awaiter 启动时需要等待可用的组件，如正在重试的 Required 组件、包含 required client 的 source
+type awaiter interface {
+	// await 阻塞直到可用，stop关闭或重试停止时返回false
+	await(stop <-chan struct{}) bool
+}
+
+// pending 初始化失败、正在后台重试的组件
+type pending struct {
+	stop  chan struct{}
+	ready chan struct{} // 重试成功后关闭
+	mu    sync.Mutex
+	err   error // 最近一次初始化的错误
+}
+
+func newPending(err error) *pending {
+	return &pending{stop: make(chan struct{}), ready: make(chan struct{}), err: err}
+}
+
+func (p *pending) await(stop <-chan struct{}) bool {
+	select {
+	case <-p.ready:
+		return true
+	case <-p.stop:
+		return false
+	case <-stop:
+		return false
+	}
+}
+
+func (p *pending) setErr(err error) {
+	p.mu.Lock()
+	p.err = err
+	p.mu.Unlock()
+}
+
+// Err 未就绪的原因，用于健康检查
+func (p *pending) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return fmt.Errorf("connecting, last err: %s", p.err)
+}
+
+// start 初始化组件，失败时 Required 或提供 Health 的组件在后台重试，启动时由 Init 等待 Required 的组件可用
+// 返回组件是否已按appcfg初始化或正在按appcfg重试
+func (c *Container) start(f component.Factory, appcfg *config.AppConf, startup bool) bool {
+	instance, err := f.Init(appcfg)
+	if err == nil {
+		c.set(f.Name, instance)
+		return true
+	}
+	log.Printf("[Container.start] init %s err: %s\n", f.Name, err)
+	required := f.Required != nil && f.Required(appcfg)
+	if startup && required {
+		log.Printf("[Container.start] %s is required, wait until it is available\n", f.Name)
+	}
+	if f.Health == nil && !required {
+		// 没有健康检查的组件如logger，初始化错误通常是配置错误，重试无意义
+		return false
+	}
+	p := newPending(err)
+	c.mu.Lock()
+	c.pending[f.Name] = p
+	c.mu.Unlock()
+	go func() {
+		var instance interface{}
+		ok := retry(p.stop, func() (err error) {
+			if instance, err = f.Init(appcfg); err != nil {
+				log.Printf("[Container.start] retry %s err: %s\n", f.Name, err)
+				p.setErr(err)
+			}
+			return
+		})
+		if !ok {
+			return
+		}
+		c.mu.Lock()
+		current := c.pending[f.Name] == p
+		if current {
+			delete(c.pending, f.Name)
+			if instance != nil {
+				c.instances[f.Name] = instance
+			}
+		}
+		c.mu.Unlock()
+		if !current {
+			// 重试期间配置已变化或容器已关闭
+			if instance != nil && f.Close != nil {
+				release(f.Name, func(ctx context.Context) error {
+					return f.Close(ctx, instance)
+				})
+			}
+			return
+		}
+		close(p.ready)
+		log.Printf("[Container.start] %s is available\n", f.Name)
+	}()
+	return true
+}
+
+// awaiter 启动时需要等待的组件，Required 的组件正在重试时返回其pending，组件实例实现 awaiter 时返回实例
+func (c *Container) awaiter(f component.Factory, appcfg *config.AppConf) awaiter {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if p, ok := c.pending[f.Name]; ok {
+		if f.Required != nil && f.Required(appcfg) {
+			return p
+		}
+		return nil
+	}
+	if w, ok := c.instances[f.Name].(awaiter); ok {
+		return w
+	}
+	return nil
+}
+
+// cancelPending 停止组件的后台重试，返回是否在重试
+func (c *Container) cancelPending(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pending[name]
+	if ok {
+		close(p.stop)
+		delete(c.pending, name)
+	}
+	return ok
+}
@@ -1,6 +1,7 @@
 package zcontainer
 
 import (
+	"context"
 	"github.com/elvisNg/broccoli/mysql/zmysql"
 	"net/http"
 
@@ -9,7 +10,11 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/health"
+	"github.com/elvisNg/broccoli/metrics"
 	"github.com/elvisNg/broccoli/mongo/zmongo"
+	zpub "github.com/elvisNg/broccoli/pubsub/pub"
+	zsub "github.com/elvisNg/broccoli/pubsub/sub"
 	"github.com/elvisNg/broccoli/redis/zredis"
 	tracing "github.com/elvisNg/broccoli/trace"
 )
@@ -19,6 +24,9 @@ type Container interface {
 	Init(appcfg *config.AppConf)
 	Reload(appcfg *config.AppConf)
 	GetRedisCli() zredis.Redis
+	// GetRedisCliByName AppConf.RedisSource 中指定名称的client，未启用时返回nil
+	GetRedisCliByName(name string) zredis.Redis
+	GetRedisSource() map[string]zredis.Redis
 	SetGoMicroClient(cli client.Client)
 	GetGoMicroClient() client.Client
 	GetLogger() *logrus.Logger
@@ -30,5 +38,30 @@ type Container interface {
 	SetGoMicroService(s micro.Service)
 	GetGoMicroService() micro.Service
 	GetMongo() zmongo.Mongo
+	// GetMongoByName AppConf.MongoDBSource 中指定名称的client，未启用时返回nil
+	GetMongoByName(name string) zmongo.Mongo
+	GetMongoSource() map[string]zmongo.Mongo
 	GetMysql() zmysql.Mysql
+	// GetMysqlByName AppConf.MysqlSource 中指定名称的client，未启用时返回nil
+	GetMysqlByName(name string) zmysql.Mysql
+	GetMysqlSource() map[string]zmysql.Mysql
+	// GetPublisher Broker 配置的发布者，broker配置变化时重新连接，未启用时返回nil
+	GetPublisher() zpub.Publisher
+	GetPublisherByName(name string) zpub.Publisher
+	// GetSubscriber Broker 配置的订阅者，broker配置变化时重新连接并重新订阅，未启用时返回nil
+	GetSubscriber() zsub.Subscriber
+	GetSubscriberByName(name string) zsub.Subscriber
+	GetSubscriberSource() map[string]zsub.Subscriber
+	// GetComponent 获取组件实例，包括内置组件和 component.Register 注册的组件，未启用时返回false
+	GetComponent(name string) (interface{}, bool)
+	// Component 获取组件实例并赋值给out，如 var es *elastic.Client; c.Component("es", &es)
+	Component(name string, out interface{}) error
+	// Health 检查启用的组件，返回组件名 -> 检查结果
+	Health(ctx context.Context) map[string]error
+	// HealthChecks 启用的组件的检查函数，用于 /readyz 等按组件并发检查
+	HealthChecks() map[string]health.CheckFunc
+	// PoolStats redis和mysql的连接池状态，用于 metrics.RegisterPoolStats
+	PoolStats() []metrics.PoolStats
+	// Close 按初始化的相反顺序关闭组件，等待进行中的请求完成，ctx 结束时不再等待
+	Close(ctx context.Context) error
 }
@@ -1,8 +1,11 @@
 package zbroker
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net"
+	"net/url"
 
 	"github.com/Shopify/sarama"
 	"github.com/micro/go-micro/broker"
@@ -13,6 +16,31 @@ import (
 	"github.com/elvisNg/broccoli/pubsub/broker/rabbitmq"
 )
 
+// Pinger 支持检查连接状态的broker
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Ping 检查broker的连接，broker未实现 Pinger 时检查能否连接broker的地址
+func Ping(ctx context.Context, b broker.Broker) error {
+	if w, ok := b.(*brokerWrap); ok {
+		b = w.Broker
+	}
+	if p, ok := b.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	addr := b.Address()
+	if u, err := url.Parse(addr); err == nil && u.Host != "" {
+		addr = u.Host // 如 redis://127.0.0.1:6379
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
 type brokerWrap struct {
 	mqType string
 	broker.Broker
@@ -3,6 +3,7 @@ package kafka
 
 import (
 	"context"
+	"errors"
 	"sync"
 
 	"github.com/Shopify/sarama"
@@ -107,6 +108,26 @@ func (k *kBroker) Connect() error {
 	return nil
 }
 
+// Ping 刷新集群元数据检查与kafka的连接，ctx 结束时不再等待
+func (k *kBroker) Ping(ctx context.Context) error {
+	if k.c == nil {
+		return errors.New("not connected")
+	}
+	if k.c.Closed() {
+		return sarama.ErrClosedClient
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- k.c.RefreshMetadata()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (k *kBroker) Disconnect() error {
 	k.scMutex.Lock()
 	defer k.scMutex.Unlock()
@@ -282,6 +282,19 @@ func (r *rbroker) Connect() error {
 	return r.conn.Connect(r.opts.Secure, &conf)
 }
 
+// Ping 检查与rabbitmq的连接，连接断开、正在重连时返回错误
+func (r *rbroker) Ping(ctx context.Context) error {
+	if r.conn == nil {
+		return errors.New("not connected")
+	}
+	r.conn.Lock()
+	defer r.conn.Unlock()
+	if !r.conn.connected || r.conn.Connection == nil || r.conn.Connection.IsClosed() {
+		return errors.New("connection lost, reconnecting")
+	}
+	return nil
+}
+
 func (r *rbroker) Disconnect() error {
 	if r.conn == nil {
 		return errors.New("connection is nil")
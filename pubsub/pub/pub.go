@@ -7,12 +7,14 @@ import (
 	"log"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/micro/go-micro"
 	gmbroker "github.com/micro/go-micro/broker"
 	"github.com/micro/go-micro/client"
 
 	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/metrics"
 	"github.com/elvisNg/broccoli/microsrv/gomicro/codec/json"
 	zbroker "github.com/elvisNg/broccoli/pubsub/broker"
 	brokerpb "github.com/elvisNg/broccoli/pubsub/proto"
@@ -23,6 +25,7 @@ type pubClient struct {
 	cli          client.Client
 	publishers   map[string]micro.Publisher
 	wrPublishers sync.RWMutex
+	inflight     sync.WaitGroup // Publisher 进行中的发布，Reload 后等待完成再断开broker
 }
 
 func (pc *pubClient) publish(ctx context.Context, header *brokerpb.Header, msg interface{}) (err error) {
@@ -32,13 +35,22 @@ func (pc *pubClient) publish(ctx context.Context, header *brokerpb.Header, msg i
 	// 	opts.ContentType = "application/xxx"
 	// })
 	// return pc.cli.Publish(ctx, pmsg)
-	if p, ok := pc.publishers[topic]; ok && p != nil {
+	start := time.Now()
+	defer func() {
+		metrics.ObservePublish(topic, err, time.Since(start))
+	}()
+	pc.wrPublishers.RLock()
+	p, ok := pc.publishers[topic]
+	pc.wrPublishers.RUnlock()
+	if ok && p != nil {
 		return p.Publish(ctx, msg)
 	}
 	pc.wrPublishers.Lock()
-	defer pc.wrPublishers.Unlock()
-	p := micro.NewPublisher(topic, pc.cli)
-	pc.publishers[topic] = p
+	if p, ok = pc.publishers[topic]; !ok || p == nil {
+		p = micro.NewPublisher(topic, pc.cli)
+		pc.publishers[topic] = p
+	}
+	pc.wrPublishers.Unlock()
 	return p.Publish(ctx, msg)
 }
 
@@ -0,0 +1,124 @@
+package zpub
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/micro/go-micro/client"
+
+	"github.com/elvisNg/broccoli/config"
+	brokerpb "github.com/elvisNg/broccoli/pubsub/proto"
+)
+
+// drainTimeout Reload 后等待旧连接上进行中的发布完成的最长时间
+const drainTimeout = 30 * time.Second
+
+// Publisher 由容器管理的发布者，配置变化时重新连接broker
+type Publisher interface {
+	// Publish 发布消息，主题为 <topic_prefix>.<header.Category>.<header.Source>
+	Publish(ctx context.Context, header *brokerpb.Header, msg interface{}) error
+	// Reload 使用新配置连接broker后替换，旧连接在进行中的发布完成后断开，连接失败时返回错误，继续使用原连接
+	Reload(conf *config.Broker) error
+	GetClient() client.Client
+	Close(ctx context.Context) error
+}
+
+type publisher struct {
+	rw sync.RWMutex
+	pc *pubClient
+}
+
+// NewPublisher 连接broker，创建发布者
+func NewPublisher(conf *config.Broker) (Publisher, error) {
+	pc, err := newC(conf, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &publisher{pc: pc}, nil
+}
+
+func (p *publisher) current() *pubClient {
+	p.rw.RLock()
+	defer p.rw.RUnlock()
+	return p.pc
+}
+
+// acquire 获取当前连接并记录进行中的发布，发布完成后调用 inflight.Done
+func (p *publisher) acquire() *pubClient {
+	p.rw.RLock()
+	defer p.rw.RUnlock()
+	if p.pc != nil {
+		p.pc.inflight.Add(1)
+	}
+	return p.pc
+}
+
+func (p *publisher) Publish(ctx context.Context, header *brokerpb.Header, msg interface{}) error {
+	pc := p.acquire()
+	if pc == nil {
+		return errors.New("publisher already closed")
+	}
+	defer pc.inflight.Done()
+	return pc.publish(ctx, header, msg)
+}
+
+// release 等待进行中的发布完成后断开broker，ctx 结束时不再等待直接断开
+func release(ctx context.Context, pc *pubClient) error {
+	done := make(chan struct{})
+	go func() {
+		pc.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("[zpub.release] wait in-flight publishes err: %s\n", ctx.Err())
+	}
+	return pc.cli.Options().Broker.Disconnect()
+}
+
+func (p *publisher) Reload(conf *config.Broker) error {
+	pc, err := newC(conf, nil)
+	if err != nil {
+		return err
+	}
+	p.rw.Lock()
+	old := p.pc
+	p.pc = pc
+	p.rw.Unlock()
+	log.Printf("[zpub.Reload] publisher reload with new conf, hosts: %v\n", conf.Hosts)
+	if old == nil {
+		return nil
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		if err := release(ctx, old); err != nil {
+			log.Printf("[zpub.Reload] disconnect old broker err: %s\n", err)
+		}
+	}()
+	return nil
+}
+
+func (p *publisher) GetClient() client.Client {
+	pc := p.current()
+	if pc == nil {
+		return nil
+	}
+	return pc.cli
+}
+
+// Close 等待进行中的发布完成后断开broker，ctx 结束时不再等待
+func (p *publisher) Close(ctx context.Context) error {
+	p.rw.Lock()
+	pc := p.pc
+	p.pc = nil
+	p.rw.Unlock()
+	if pc == nil {
+		return errors.New("publisher already closed")
+	}
+	return release(ctx, pc)
+}
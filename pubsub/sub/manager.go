@@ -4,15 +4,13 @@ import (
 	"context"
 	"io"
 	"log"
-	"os"
-	"os/signal"
-	"syscall"
 
 	gmbroker "github.com/micro/go-micro/broker"
 	"github.com/micro/go-micro/codec"
 	"github.com/micro/go-micro/server"
 
 	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/lifecycle"
 	zjson "github.com/elvisNg/broccoli/microsrv/gomicro/codec/json"
 	zbroker "github.com/elvisNg/broccoli/pubsub/broker"
 	"github.com/elvisNg/broccoli/utils"
@@ -55,7 +53,7 @@ func NewManager(mc *ManagerConfig) (m *Manager, err error) {
 			log.Println("newS b.Connect err:", err)
 			return
 		}
-		srvOpts := []server.Option{server.Broker(b)}
+		srvOpts := []server.Option{server.Broker(b), server.WrapSubscriber(observeConsume)}
 		jsonCodeFn := zjson.NewCodec
 		if mc.JSONCodecFn != nil {
 			jsonCodeFn = mc.JSONCodecFn
@@ -100,8 +98,10 @@ func (m *Manager) Run(ctx context.Context) {
 			}
 		})
 	}
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL)
 	log.Println("=========================[Run subserver manager] waiting syscall=====================")
-	log.Printf("Subserver Manager Received signal %s\n", <-ch)
+	select {
+	case <-lifecycle.Done():
+		log.Println("Subserver Manager Received shutdown")
+	case <-ctx.Done():
+	}
 }
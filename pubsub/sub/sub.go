@@ -5,16 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"os"
-	"os/signal"
 	"strings"
 	"sync"
-	"syscall"
+	"time"
 
 	"github.com/micro/go-micro"
 	"github.com/micro/go-micro/server"
 
 	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/lifecycle"
+	"github.com/elvisNg/broccoli/metrics"
 	zbroker "github.com/elvisNg/broccoli/pubsub/broker"
 	"github.com/elvisNg/broccoli/utils"
 	gmbroker "github.com/micro/go-micro/broker"
@@ -80,13 +80,25 @@ func (ss *subServer) run(ctx context.Context) (err error) {
 	if err = ss.start(ctx); err != nil {
 		return
 	}
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL)
 	log.Println("=========================[Run subscribe] waiting syscall=====================")
-	log.Printf("SubServer Received signal %s\n", <-ch)
+	select {
+	case <-lifecycle.Done():
+		log.Println("SubServer Received shutdown")
+	case <-ctx.Done():
+	}
 	return ss.stop(ctx)
 }
 
+// observeConsume 记录消息处理的数量和耗时
+func observeConsume(fn server.SubscriberFunc) server.SubscriberFunc {
+	return func(ctx context.Context, msg server.Message) error {
+		start := time.Now()
+		err := fn(ctx, msg)
+		metrics.ObserveConsume(msg.Topic(), err, time.Since(start))
+		return err
+	}
+}
+
 func newS(conf *config.Broker, srv server.Server) (s *subServer, err error) {
 	topicPrefix := conf.TopicPrefix
 	if strings.TrimSpace(conf.TopicPrefix) == "" {
@@ -114,6 +126,7 @@ func newS(conf *config.Broker, srv server.Server) (s *subServer, err error) {
 		// log.Printf("newS b.Address()========%+v\n", b.Address())
 		srv = server.NewServer(
 			server.Broker(b),
+			server.WrapSubscriber(observeConsume),
 			// server.Codec("application/json", server.DefaultCodecs["application/json"]),
 		)
 		// 初始化
@@ -0,0 +1,223 @@
+package zsub
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/micro/go-micro/server"
+
+	"github.com/elvisNg/broccoli/config"
+	zjson "github.com/elvisNg/broccoli/microsrv/gomicro/codec/json"
+	zbroker "github.com/elvisNg/broccoli/pubsub/broker"
+)
+
+// restartDrainTimeout 重新订阅前等待原订阅处理中的消息的最长时间
+const restartDrainTimeout = 10 * time.Second
+
+var (
+	handlersMu sync.RWMutex
+	handlers   = make(map[string]interface{})
+)
+
+// RegisterHandler 注册主题的处理函数，由容器管理的订阅者按配置的 SubscribeTopics 订阅
+// key 为 TopicInfo.Topic，未配置 Topic 时为 <category>.<source>
+// 需在 service.Run 之前注册，之后注册的调用 Subscriber.Resubscribe 生效
+func RegisterHandler(key string, h interface{}) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers[key] = h
+}
+
+func registeredHandlers() map[string]interface{} {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+	m := make(map[string]interface{}, len(handlers))
+	for k, h := range handlers {
+		m[k] = h
+	}
+	return m
+}
+
+// Subscriber 由容器管理的订阅者，配置变化时重新连接broker并重新订阅
+type Subscriber interface {
+	// Reload 停止原订阅，使用新配置重新连接broker并订阅，失败时恢复原订阅并返回错误
+	Reload(conf *config.Broker) error
+	// Resubscribe 使用当前注册的处理函数重新订阅
+	Resubscribe() error
+	GetServer() server.Server
+	Close(ctx context.Context) error
+}
+
+type subscriber struct {
+	mu     sync.Mutex
+	conf   config.Broker
+	ss     *subServer // 重新订阅失败时为nil，再次 Reload 或 Resubscribe 时重新启动
+	fl     *inflight
+	closed bool
+}
+
+// inflight 正在处理的消息，停止时不再处理新消息，等待处理中的消息完成
+type inflight struct {
+	mu      sync.Mutex
+	closing bool
+	wg      sync.WaitGroup
+}
+
+func (f *inflight) wrap(fn server.SubscriberFunc) server.SubscriberFunc {
+	return func(ctx context.Context, msg server.Message) error {
+		f.mu.Lock()
+		if f.closing {
+			f.mu.Unlock()
+			// 返回错误不确认消息，由broker重新投递
+			return errors.New("subscriber is closing")
+		}
+		f.wg.Add(1)
+		f.mu.Unlock()
+		defer f.wg.Done()
+		return fn(ctx, msg)
+	}
+}
+
+// drain 不再处理新消息，等待处理中的消息完成，ctx 结束时不再等待
+func (f *inflight) drain(ctx context.Context) error {
+	f.mu.Lock()
+	f.closing = true
+	f.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// NewSubscriber 连接broker，订阅配置的主题并开始消费
+func NewSubscriber(conf *config.Broker) (Subscriber, error) {
+	ss, fl, err := startSubServer(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &subscriber{conf: *conf, ss: ss, fl: fl}, nil
+}
+
+// startSubServer 连接broker，使用注册的处理函数订阅并启动
+func startSubServer(conf *config.Broker) (ss *subServer, fl *inflight, err error) {
+	b, err := zbroker.New(conf)
+	if err != nil {
+		return
+	}
+	if err = b.Init(); err != nil {
+		return
+	}
+	if err = b.Connect(); err != nil {
+		return
+	}
+	fl = new(inflight)
+	srv := server.NewServer(
+		server.Broker(b),
+		server.Codec("application/json", zjson.NewCodec),
+		server.WrapSubscriber(fl.wrap, observeConsume),
+	)
+	if err = srv.Init(); err != nil {
+		b.Disconnect()
+		return
+	}
+	if ss, err = newS(conf, srv); err != nil {
+		b.Disconnect()
+		return
+	}
+	ss.handlers = registeredHandlers()
+	ctx := context.Background()
+	if err = ss.subscribe(ctx, ss.handlers); err != nil {
+		b.Disconnect()
+		return
+	}
+	if err = ss.start(ctx); err != nil {
+		b.Disconnect()
+		return
+	}
+	return
+}
+
+// stop 等待处理中的消息完成后停止订阅
+func (s *subscriber) stop(ctx context.Context) error {
+	if err := s.fl.drain(ctx); err != nil {
+		log.Printf("[zsub.stop] wait in-flight messages err: %s\n", err)
+	}
+	return s.ss.stop(ctx)
+}
+
+// restart 先停止原订阅避免新旧订阅重复消费，失败时使用原配置恢复
+func (s *subscriber) restart(conf *config.Broker) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("subscriber already closed")
+	}
+	if s.ss != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), restartDrainTimeout)
+		err := s.stop(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("[zsub.restart] stop subscriber err: %s\n", err)
+		}
+		s.ss, s.fl = nil, nil
+	}
+	ss, fl, err := startSubServer(conf)
+	if err != nil {
+		log.Printf("[zsub.restart] start subscriber err: %s, restore\n", err)
+		restored, rfl, rerr := startSubServer(&s.conf)
+		if rerr != nil {
+			log.Printf("[zsub.restart] restore subscriber err: %s\n", rerr)
+			return err
+		}
+		s.ss, s.fl = restored, rfl
+		return err
+	}
+	s.ss, s.fl, s.conf = ss, fl, *conf
+	return nil
+}
+
+func (s *subscriber) Reload(conf *config.Broker) error {
+	log.Printf("[zsub.Reload] subscriber reload with new conf, hosts: %v\n", conf.Hosts)
+	return s.restart(conf)
+}
+
+func (s *subscriber) Resubscribe() error {
+	s.mu.Lock()
+	conf := s.conf
+	s.mu.Unlock()
+	return s.restart(&conf)
+}
+
+func (s *subscriber) GetServer() server.Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ss == nil {
+		return nil
+	}
+	return s.ss.srv
+}
+
+// Close 不再处理新消息，等待处理中的消息完成后停止订阅，server停止时断开broker
+// 服务停止时先于容器关闭订阅者，重复调用返回nil
+func (s *subscriber) Close(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	closed := s.closed || s.ss == nil
+	s.closed = true
+	if closed {
+		return nil
+	}
+	err := s.stop(ctx)
+	s.ss, s.fl = nil, nil
+	return err
+}
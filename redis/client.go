@@ -1,27 +1,47 @@
 package redisclient
 
 import (
+	"context"
+	"errors"
 	"log"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-redis/redis"
 
 	"github.com/elvisNg/broccoli/config"
 )
 
+const (
+	drainTimeout  = 30 * time.Second       // Reload 后等待旧连接上的请求完成的最长时间
+	drainInterval = 100 * time.Millisecond // 检查连接池是否空闲的间隔
+)
+
 type Client struct {
 	client *redis.Client
 	rw     sync.RWMutex
 }
 
+// InitClient 创建客户端，连接失败时panic，需要处理错误时使用 NewClient
 func InitClient(cfg *config.Redis) *Client {
-	rds := new(Client)
-	rds.client = newRedisClient(cfg)
+	rds, err := NewClient(cfg)
+	if err != nil {
+		panic(err)
+	}
 	return rds
 }
 
-func newRedisClient(cfg *config.Redis) *redis.Client {
+// NewClient 创建客户端，ping失败时返回错误
+func NewClient(cfg *config.Redis) (*Client, error) {
+	client, err := newRedisClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{client: client}, nil
+}
+
+func newRedisClient(cfg *config.Redis) (*redis.Client, error) {
 	var client *redis.Client
 	if cfg.SentinelHost != "" {
 		client = redis.NewFailoverClient(&redis.FailoverOptions{
@@ -36,22 +56,37 @@ func newRedisClient(cfg *config.Redis) *redis.Client {
 		})
 	}
 	if err := client.Ping().Err(); err != nil {
-		log.Fatalf("[redis.newRedisClient] redis ping failed: %s\n", err.Error())
-		return nil
+		log.Printf("[redis.newRedisClient] redis ping failed: %s\n", err.Error())
+		client.Close()
+		return nil, err
 	}
 	log.Printf("[redis.newRedisClient] success \n")
-	return client
+	return client, nil
 }
 
-func (rds *Client) Reload(cfg *config.Redis) {
+// Reload 使用新配置创建客户端后替换，旧客户端在进行中的请求完成后关闭
+// 新配置连接失败时返回错误，继续使用旧客户端
+func (rds *Client) Reload(cfg *config.Redis) error {
+	client, err := newRedisClient(cfg)
+	if err != nil {
+		return err
+	}
 	rds.rw.Lock()
-	defer rds.rw.Unlock()
-	if err := rds.client.Close(); err != nil {
-		log.Printf("redis close failed: %s\n", err.Error())
-		return
+	old := rds.client
+	rds.client = client
+	rds.rw.Unlock()
+	log.Printf("[redis.Reload] redisclient reload with new conf, host: %s, sentinel: %s\n", cfg.Host, cfg.SentinelHost)
+	if old == nil {
+		return nil
 	}
-	log.Printf("[redis.Reload] redisclient reload with new conf: %+v\n", cfg)
-	rds.client = newRedisClient(cfg)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		if err := closeClient(ctx, old); err != nil {
+			log.Printf("[redis.Reload] close old client failed: %s\n", err)
+		}
+	}()
+	return nil
 }
 
 func (rds *Client) GetCli() *redis.Client {
@@ -60,11 +95,32 @@ func (rds *Client) GetCli() *redis.Client {
 	return rds.client
 }
 
-func (rds *Client) release() {
+// Close 等待进行中的请求完成后关闭客户端，ctx 结束时不再等待直接关闭
+func (rds *Client) Close(ctx context.Context) error {
 	rds.rw.Lock()
-	defer rds.rw.Unlock()
-	if err := rds.client.Close(); err != nil {
-		log.Printf("redis close failed: %s\n", err.Error())
-		return
+	client := rds.client
+	rds.client = nil
+	rds.rw.Unlock()
+	if client == nil {
+		return errors.New("redis client already closed")
+	}
+	return closeClient(ctx, client)
+}
+
+// closeClient 连接池中没有使用中的连接时关闭
+func closeClient(ctx context.Context, client *redis.Client) error {
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for {
+		stats := client.PoolStats()
+		if stats.TotalConns <= stats.IdleConns {
+			return client.Close()
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Printf("[redis.closeClient] %d connections still in use, close anyway\n", stats.TotalConns-stats.IdleConns)
+			return client.Close()
+		}
 	}
 }
@@ -1,11 +1,15 @@
 package zredis
 
 import (
+	"context"
+
 	"github.com/elvisNg/broccoli/config"
 	"github.com/go-redis/redis"
 )
 
 type Redis interface {
-	Reload(cfg *config.Redis)
+	// Reload 新配置连接失败时返回错误，继续使用原连接
+	Reload(cfg *config.Redis) error
 	GetCli() *redis.Client
+	Close(ctx context.Context) error
 }
@@ -0,0 +1,331 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/engine"
+	broccolierrors "github.com/elvisNg/broccoli/errors"
+	"github.com/elvisNg/broccoli/metrics"
+	"github.com/elvisNg/broccoli/trace/zipkin"
+	"github.com/elvisNg/broccoli/utils"
+)
+
+const (
+	adminPathPrefix = "/broccoli/admin"
+	adminTokenKey   = "admin_token" // AppConf.Ext 中管理接口的token
+
+	// defaultAdminInterface 管理接口默认只监听本机
+	defaultAdminInterface = "127.0.0.1"
+)
+
+// startTime 进程启动时间，由 /broccoli/admin/version 返回
+var startTime = time.Now()
+
+// registerAdminHandler 注册管理接口，只在 AdminPort 的监听上提供
+// GET  /broccoli/admin/config/history          配置历史
+// GET  /broccoli/admin/config/diff?from=1&to=2 比较两个版本
+// POST /broccoli/admin/config/rollback?version=1 回滚到指定版本
+// GET  /broccoli/admin/config/watch            配置监听状态
+func (s *Service) registerAdminHandler(r *mux.Router) {
+	sr := r.PathPrefix(adminPathPrefix).Subrouter()
+	sr.Use(s.adminAuth)
+	sr.HandleFunc("/config/history", s.configHistoryHandler).Methods(http.MethodGet)
+	sr.HandleFunc("/config/diff", s.configDiffHandler).Methods(http.MethodGet)
+	sr.HandleFunc("/config/rollback", s.configRollbackHandler).Methods(http.MethodPost)
+	sr.HandleFunc("/config/watch", s.configWatchHandler).Methods(http.MethodGet)
+}
+
+// startAdminServer 启动管理接口的监听，与 ApiPort 分开，AdminPort 为0时不启动
+// 除 registerAdminHandler 的接口外提供:
+// GET     /debug/pprof/                     pprof
+// GET     /metrics                          prometheus指标，同时在 ApiPort 上提供
+// GET     /broccoli/admin/config             生效的配置，敏感字段已遮盖
+// GET     /broccoli/admin/endpoints          注册到注册中心的go-micro接口
+// GET     /broccoli/admin/routes             HttpHandlerRegisterFn 注册的gin路由
+// GET     /broccoli/admin/version            编译和运行时信息
+// GET/PUT /broccoli/admin/log/level          容器logger的日志级别，PUT {"level":"debug"}
+// GET/PUT /broccoli/admin/trace/sampling     trace采样率，PUT {"rate":0.1}
+func (s *Service) startAdminServer() error {
+	if s.options.AdminPort <= 0 {
+		return nil
+	}
+	iface := s.options.AdminInterface
+	if utils.IsEmptyString(iface) {
+		iface = defaultAdminInterface
+	}
+	r := mux.NewRouter()
+	s.registerAdminHandler(r)
+	s.registerDebugHandler(r)
+	r.Handle(metrics.Path, metrics.Handler()).Methods(http.MethodGet)
+	ln, err := net.Listen("tcp", net.JoinHostPort(iface, strconv.Itoa(s.options.AdminPort)))
+	if err != nil {
+		return err
+	}
+	s.adminServer = &http.Server{Handler: r}
+	log.Printf("[broccoli] admin server listen on %s\n", ln.Addr())
+	go func() {
+		if err := s.adminServer.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("[broccoli] [s.startAdminServer] err: %s\n", err)
+		}
+	}()
+	return nil
+}
+
+func (s *Service) stopAdminServer(ctx context.Context) error {
+	if s.adminServer == nil {
+		return nil
+	}
+	return s.adminServer.Shutdown(ctx)
+}
+
+// registerDebugHandler 注册只在管理接口监听上提供的接口
+func (s *Service) registerDebugHandler(r *mux.Router) {
+	pr := r.PathPrefix("/debug/pprof").Subrouter()
+	pr.Use(s.adminAuth)
+	pr.HandleFunc("/cmdline", pprof.Cmdline)
+	pr.HandleFunc("/profile", pprof.Profile)
+	pr.HandleFunc("/symbol", pprof.Symbol)
+	pr.HandleFunc("/trace", pprof.Trace)
+	pr.PathPrefix("/").HandlerFunc(pprof.Index)
+
+	sr := r.PathPrefix(adminPathPrefix).Subrouter()
+	sr.Use(s.adminAuth)
+	sr.HandleFunc("/config", s.configHandler).Methods(http.MethodGet)
+	sr.HandleFunc("/endpoints", s.endpointsHandler).Methods(http.MethodGet)
+	sr.HandleFunc("/routes", s.routesHandler).Methods(http.MethodGet)
+	sr.HandleFunc("/version", s.versionHandler).Methods(http.MethodGet)
+	sr.HandleFunc("/log/level", s.logLevelHandler).Methods(http.MethodGet, http.MethodPut)
+	sr.HandleFunc("/trace/sampling", s.traceSamplingHandler).Methods(http.MethodGet, http.MethodPut)
+}
+
+// adminAuth 配置了 admin_token 时校验 Authorization: Bearer <token>，否则只允许本机访问
+func (s *Service) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		if configer, err := s.ng.GetConfiger(); err == nil && configer != nil {
+			token = configer.Get().ExtString(adminTokenKey, "")
+		}
+		if utils.IsEmptyString(token) {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+				broccolierrors.ECodeNoPermission.ParseErr("admin api only allowed from localhost").Write(w)
+				return
+			}
+		} else {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				broccolierrors.ECodeNoPermission.ParseErr("invalid admin token").Write(w)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Service) historian(w http.ResponseWriter) (engine.Historian, bool) {
+	h, ok := s.ng.(engine.Historian)
+	if !ok {
+		broccolierrors.ECodeSystem.ParseErr("engine does not support config history").Write(w)
+	}
+	return h, ok
+}
+
+func (s *Service) configHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	h, ok := s.historian(w)
+	if !ok {
+		return
+	}
+	writeAdminData(w, h.History().List())
+}
+
+func (s *Service) configDiffHandler(w http.ResponseWriter, r *http.Request) {
+	h, ok := s.historian(w)
+	if !ok {
+		return
+	}
+	from, err1 := strconv.Atoi(r.URL.Query().Get("from"))
+	to, err2 := strconv.Atoi(r.URL.Query().Get("to"))
+	if err1 != nil || err2 != nil {
+		broccolierrors.ECodeInvalidParams.ParseErr("from and to must be version numbers").Write(w)
+		return
+	}
+	changes, err := h.History().Diff(from, to)
+	if err != nil {
+		broccolierrors.ECodeNoRecord.ParseErr(err.Error()).Write(w)
+		return
+	}
+	// 配置已解密，遮盖敏感字段
+	for i, c := range changes {
+		changes[i] = config.RedactChange(c)
+	}
+	writeAdminData(w, changes)
+}
+
+func (s *Service) configRollbackHandler(w http.ResponseWriter, r *http.Request) {
+	h, ok := s.historian(w)
+	if !ok {
+		return
+	}
+	version, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil {
+		broccolierrors.ECodeInvalidParams.ParseErr("version must be a version number").Write(w)
+		return
+	}
+	if err = h.Rollback(version); err != nil {
+		broccolierrors.ECodeSystem.ParseErr(err.Error()).Write(w)
+		return
+	}
+	writeAdminData(w, nil)
+}
+
+func (s *Service) configWatchHandler(w http.ResponseWriter, r *http.Request) {
+	h, ok := s.ng.(engine.WatchHealthReporter)
+	if !ok {
+		broccolierrors.ECodeSystem.ParseErr("engine does not report watch health").Write(w)
+		return
+	}
+	writeAdminData(w, h.WatchHealth())
+}
+
+// configHandler 生效的配置，敏感字段的值替换为***
+func (s *Service) configHandler(w http.ResponseWriter, r *http.Request) {
+	configer, err := s.ng.GetConfiger()
+	if err != nil || configer == nil {
+		broccolierrors.ECodeSystem.ParseErr("config not loaded").Write(w)
+		return
+	}
+	b, err := utils.Marshal(configer.Get())
+	if err != nil {
+		broccolierrors.ECodeSystem.ParseErr(err.Error()).Write(w)
+		return
+	}
+	writeAdminData(w, json.RawMessage(config.Redact(b)))
+}
+
+func (s *Service) endpointsHandler(w http.ResponseWriter, r *http.Request) {
+	gms := s.container.GetGoMicroService()
+	if gms == nil {
+		broccolierrors.ECodeSystem.ParseErr("gomicro service not started").Write(w)
+		return
+	}
+	opts := gms.Server().Options()
+	services, err := gms.Options().Registry.GetService(opts.Name)
+	if err != nil {
+		broccolierrors.ECodeSystem.ParseErr(err.Error()).Write(w)
+		return
+	}
+	for _, svc := range services {
+		if svc.Version == opts.Version {
+			writeAdminData(w, svc.Endpoints)
+			return
+		}
+	}
+	writeAdminData(w, nil)
+}
+
+// routesHandler HttpHandlerRegisterFn 返回gin.Engine时列出路由
+func (s *Service) routesHandler(w http.ResponseWriter, r *http.Request) {
+	type route struct {
+		Method  string `json:"method"`
+		Path    string `json:"path"`
+		Handler string `json:"handler"`
+	}
+	routes := []route{}
+	if g, ok := s.httpHandler.(*gin.Engine); ok {
+		for _, ri := range g.Routes() {
+			routes = append(routes, route{Method: ri.Method, Path: ri.Path, Handler: ri.Handler})
+		}
+	}
+	writeAdminData(w, routes)
+}
+
+func (s *Service) versionHandler(w http.ResponseWriter, r *http.Request) {
+	writeAdminData(w, map[string]interface{}{
+		"build":      s.options.BuildInfo,
+		"runtime":    runtime.Version(),
+		"os_arch":    runtime.GOOS + "/" + runtime.GOARCH,
+		"goroutines": runtime.NumGoroutine(),
+		"start_time": startTime,
+		"uptime":     time.Since(startTime).String(),
+		"service_id": s.container.GetServiceID(),
+	})
+}
+
+// logLevelHandler 查看或修改容器logger的日志级别，配置重新加载logger后使用配置的级别
+func (s *Service) logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	logger := s.container.GetLogger()
+	if logger == nil {
+		broccolierrors.ECodeSystem.ParseErr("logger not initialized").Write(w)
+		return
+	}
+	if r.Method == http.MethodPut {
+		var req struct {
+			Level string `json:"level"`
+		}
+		if err := readAdminBody(w, r, &req); err != nil {
+			broccolierrors.ECodeInvalidParams.ParseErr(err.Error()).Write(w)
+			return
+		}
+		level, err := logrus.ParseLevel(req.Level)
+		if err != nil {
+			broccolierrors.ECodeInvalidParams.ParseErr(err.Error()).Write(w)
+			return
+		}
+		logger.SetLevel(level)
+		log.Printf("[broccoli] [s.logLevelHandler] log level set to %s\n", level)
+	}
+	writeAdminData(w, map[string]string{"level": logger.GetLevel().String()})
+}
+
+// traceSamplingHandler 查看或修改trace采样率，取值与配置 trace.rate 相同，配置重新加载tracer后使用配置的值
+func (s *Service) traceSamplingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var req struct {
+			Rate *float64 `json:"rate"`
+		}
+		if err := readAdminBody(w, r, &req); err != nil || req.Rate == nil {
+			broccolierrors.ECodeInvalidParams.ParseErr("rate is required").Write(w)
+			return
+		}
+		if err := zipkin.SetSampleRate(*req.Rate); err != nil {
+			broccolierrors.ECodeSystem.ParseErr(err.Error()).Write(w)
+			return
+		}
+	}
+	kind, rate, mod := zipkin.Sampling()
+	writeAdminData(w, map[string]interface{}{"sampler": kind, "rate": rate, "mod": mod})
+}
+
+func readAdminBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if err = utils.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("invalid body: %s", err)
+	}
+	return nil
+}
+
+func writeAdminData(w http.ResponseWriter, data interface{}) {
+	e := broccolierrors.New(broccolierrors.ECodeSuccessed, "", "")
+	e.Data = data
+	e.Write(w)
+}
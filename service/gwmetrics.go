@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+
+	broccolierrors "github.com/elvisNg/broccoli/errors"
+	"github.com/elvisNg/broccoli/metrics"
+)
+
+const (
+	gwPathUnmatched = "unmatched" // 没有匹配的路由
+	gwPathUnknown   = "unknown"   // 匹配了路由但未转发，如参数解析失败
+)
+
+// gwMethodKey 请求上下文中记录转发的grpc方法的key，值为*string
+type gwMethodKey struct{}
+
+// gwDialOptions 传给 HttpGWHandlerRegisterFn 的连接参数
+// 拦截器记录转发的grpc方法，作为指标的path标签，避免使用带参数的url路径
+func gwDialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(gwUnaryMethodInterceptor),
+		grpc.WithStreamInterceptor(gwStreamMethodInterceptor),
+	}
+}
+
+func setGWMethod(ctx context.Context, method string) {
+	if m, ok := ctx.Value(gwMethodKey{}).(*string); ok {
+		*m = method
+	}
+}
+
+func gwUnaryMethodInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	setGWMethod(ctx, method)
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func gwStreamMethodInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	setGWMethod(ctx, method)
+	return streamer(ctx, desc, cc, method, opts...)
+}
+
+// observeGateway 记录grpc-gateway请求的指标，path标签为转发的grpc方法，如 /pkg.Service/Method
+func observeGateway(httpMethod, grpcMethod string, w *gwBodyWriter, d time.Duration) {
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	path := grpcMethod
+	if path == "" {
+		path = gwPathUnknown
+		if status == http.StatusNotFound {
+			path = gwPathUnmatched
+		}
+	}
+	errcode := broccolierrors.ECodeSuccessed
+	switch {
+	case w.broccoliErr != nil:
+		errcode = w.broccoliErr.ErrCode
+	case status != http.StatusOK:
+		errcode = broccolierrors.ECodeProxyFailed
+	}
+	metrics.ObserveHTTP("gateway", httpMethod, path, status, int32(errcode), d)
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/engine"
+	"github.com/elvisNg/broccoli/health"
+	"github.com/elvisNg/broccoli/utils"
+)
+
+const (
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
+
+	// watcherCheckName engine配置监听的检查名，默认非关键
+	watcherCheckName = "config_watcher"
+)
+
+// registerHealthHandler 注册健康检查接口
+// GET /healthz 存活检查，进程能处理请求即返回200
+// GET /readyz  就绪检查，关键组件不可用时返回503，返回各组件的检查结果
+func (s *Service) registerHealthHandler(r *mux.Router) {
+	r.HandleFunc(healthzPath, s.healthzHandler).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc(readyzPath, s.readyzHandler).Methods(http.MethodGet, http.MethodHead)
+}
+
+func (s *Service) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, health.Report{Status: health.StatusUp})
+}
+
+func (s *Service) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	report := s.Health(r.Context())
+	code := http.StatusOK
+	if !report.Up() {
+		code = http.StatusServiceUnavailable
+	}
+	writeHealth(w, code, report)
+}
+
+// Health 检查容器组件、engine配置监听和 WithHealthCheckOption 添加的检查，开始停止后不就绪
+func (s *Service) Health(ctx context.Context) health.Report {
+	var conf config.Health
+	if configer, err := s.ng.GetConfiger(); err == nil && configer != nil {
+		conf = configer.Get().Health
+	}
+	critical := func(name string, def bool) bool {
+		if c, ok := conf.Criticality[name]; ok {
+			return c
+		}
+		return def
+	}
+	var checks []health.Check
+	if atomic.LoadInt32(&s.stopping) == 1 {
+		checks = append(checks, health.Check{
+			Name:     "shutdown",
+			Critical: true,
+			Fn:       func(ctx context.Context) error { return errors.New("shutting down") },
+		})
+	}
+	for name, fn := range s.container.HealthChecks() {
+		checks = append(checks, health.Check{Name: name, Critical: critical(name, true), Fn: fn})
+	}
+	if h, ok := s.ng.(engine.WatchHealthReporter); ok {
+		checks = append(checks, health.Check{
+			Name:     watcherCheckName,
+			Critical: critical(watcherCheckName, false),
+			Fn: func(ctx context.Context) error {
+				if wh := h.WatchHealth(); !wh.Healthy {
+					if utils.IsEmptyString(wh.LastError) {
+						return errors.New("config watcher unhealthy")
+					}
+					return errors.New(wh.LastError)
+				}
+				return nil
+			},
+		})
+	}
+	for _, c := range s.options.HealthChecks {
+		c.Critical = critical(c.Name, c.Critical)
+		checks = append(checks, c)
+	}
+	return health.Run(ctx, time.Duration(conf.Timeout)*time.Millisecond, checks)
+}
+
+// grpcHealth grpc.health.v1.Health 的检查，service为空时返回整体就绪状态，否则返回指定组件的状态
+func (s *Service) grpcHealth(ctx context.Context, service string) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	report := s.Health(ctx)
+	up := report.Up()
+	if !utils.IsEmptyString(service) {
+		res, ok := report.Component(service)
+		if !ok {
+			return grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+		up = res.Status == health.StatusUp
+	}
+	if up {
+		return grpc_health_v1.HealthCheckResponse_SERVING
+	}
+	return grpc_health_v1.HealthCheckResponse_NOT_SERVING
+}
+
+func writeHealth(w http.ResponseWriter, code int, report health.Report) {
+	b, err := utils.Marshal(report)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(b)
+}
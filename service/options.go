@@ -4,6 +4,8 @@ import (
 	"context"
 	"flag"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
 	"github.com/micro/go-micro/client"
@@ -11,6 +13,8 @@ import (
 	"google.golang.org/grpc"
 
 	"github.com/elvisNg/broccoli/engine"
+	"github.com/elvisNg/broccoli/health"
+	"github.com/elvisNg/broccoli/lifecycle"
 )
 
 type Options struct {
@@ -21,6 +25,11 @@ type Options struct {
 	Port int
 	// Interface string
 
+	// AdminPort 管理接口的端口，与 ApiPort 分开监听，0 不启动
+	AdminPort int
+	// AdminInterface 管理接口绑定的地址，默认只监听本机，未配置 admin_token 时只允许本机访问
+	AdminInterface string
+
 	Log       string
 	LogFormat string
 	LogLevel  string
@@ -29,6 +38,18 @@ type Options struct {
 
 	ConfEntryPath string
 
+	// 配置入口，优先级高于环境变量和配置入口文件
+	EngineType string
+	ConfigPath string
+	EndPoints  string // 逗号分隔
+	Profile    string // dev/test/prod等，选择配置入口中对应的profile
+
+	// Engine 外部传入的engine，设置后忽略配置入口，如测试中使用的memory engine
+	Engine engine.Engine
+
+	// ConfOverrides 按路径覆盖配置，格式 path=value，如 redis.host=127.0.0.1:6379
+	ConfOverrides StringSlice
+
 	GoMicroHandlerRegisterFn GoMicroHandlerRegisterFn
 
 	HttpGWHandlerRegisterFn HttpGWHandlerRegisterFn
@@ -37,21 +58,60 @@ type Options struct {
 
 	ProcessChangeFn ProcessChangeFn
 
+	ConfigEventHandlers []ConfigEventHandler
+
 	LoadEngineFn          LoadEngineFn
 	InitServiceCompleteFn InitServiceCompleteFn
 
 	GoMicroServerWrapGenerateFn []GoMicroServerWrapGenerateFn
 	GoMicroClientWrapGenerateFn []GoMicroClientWrapGenerateFn
 
+	// HealthChecks 容器组件之外的健康检查，如依赖的下游服务
+	HealthChecks []health.Check
+
+	// ShutdownTimeout 停止时等待http、grpc进行中的请求和订阅处理中的消息完成的最长时间，默认30s
+	ShutdownTimeout time.Duration
+	// ShutdownHooks 停止订阅之后、关闭容器组件之前按顺序执行
+	ShutdownHooks []lifecycle.Hook
+
+	// BuildInfo 编译信息，由管理接口返回
+	BuildInfo BuildInfo
+
 	Version bool
 }
 
 type Option func(o *Options)
 
+// BuildInfo 编译信息，通常由 -ldflags "-X main.Version=..." 注入 main 后传入
+type BuildInfo struct {
+	Version   string `json:"version"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// StringSlice 可重复传递的命令行参数
+type StringSlice []string
+
+func (ss *StringSlice) String() string {
+	return strings.Join(*ss, ",")
+}
+
+func (ss *StringSlice) Set(v string) error {
+	*ss = append(*ss, v)
+	return nil
+}
+
 type ProcessChangeFn func(event interface{})
 
+// ConfigEventHandler 按分区订阅配置变化事件，Section为空则订阅所有事件
+type ConfigEventHandler struct {
+	Section string
+	Fn      engine.EventHandler
+}
+
 type GoMicroHandlerRegisterFn func(s server.Server, opts ...server.HandlerOption) error
 
+// HttpGWHandlerRegisterFn 注册grpc-gateway，连接endpoint时应使用opts，其中的拦截器记录转发的grpc方法用于指标
 type HttpGWHandlerRegisterFn func(ctx context.Context, endpoint string, opts []grpc.DialOption) (*runtime.ServeMux, error)
 
 type HttpHandlerRegisterFn func(ctx context.Context, prefix string, ng engine.Engine) (http.Handler, error)
@@ -69,6 +129,13 @@ func WithProcessChangeFnOption(fn ProcessChangeFn) Option {
 	}
 }
 
+// WithConfigEventFnOption 订阅配置分区的变化事件，如 engine.SectionRedis
+func WithConfigEventFnOption(section string, fn engine.EventHandler) Option {
+	return func(o *Options) {
+		o.ConfigEventHandlers = append(o.ConfigEventHandlers, ConfigEventHandler{Section: section, Fn: fn})
+	}
+}
+
 func WithGoMicrohandlerRegisterFnOption(fn GoMicroHandlerRegisterFn) Option {
 	return func(o *Options) {
 		o.GoMicroHandlerRegisterFn = fn
@@ -123,6 +190,48 @@ func WithSwaggerJSONFileName(s string) Option {
 	}
 }
 
+// WithEngineOption 使用外部传入的engine，不再根据配置入口创建
+func WithEngineOption(ng engine.Engine) Option {
+	return func(o *Options) {
+		o.Engine = ng
+	}
+}
+
+// WithConfOverridesOption 按路径覆盖配置，格式 path=value
+func WithConfOverridesOption(pairs ...string) Option {
+	return func(o *Options) {
+		o.ConfOverrides = append(o.ConfOverrides, pairs...)
+	}
+}
+
+// WithHealthCheckOption 添加健康检查，critical 为true时不可用则服务不就绪，可通过 AppConf.Health.Criticality 覆盖
+func WithHealthCheckOption(name string, critical bool, fn health.CheckFunc) Option {
+	return func(o *Options) {
+		o.HealthChecks = append(o.HealthChecks, health.Check{Name: name, Critical: critical, Fn: fn})
+	}
+}
+
+// WithShutdownTimeoutOption 停止时等待进行中的请求完成的最长时间
+func WithShutdownTimeoutOption(d time.Duration) Option {
+	return func(o *Options) {
+		o.ShutdownTimeout = d
+	}
+}
+
+// WithShutdownHookOption 添加停止步骤，在停止订阅之后、关闭容器组件之前执行，如刷新缓冲的数据
+func WithShutdownHookOption(name string, timeout time.Duration, fn func(ctx context.Context) error) Option {
+	return func(o *Options) {
+		o.ShutdownHooks = append(o.ShutdownHooks, lifecycle.Hook{Name: name, Timeout: timeout, Fn: fn})
+	}
+}
+
+// WithBuildInfoOption 设置编译信息，由管理接口 /broccoli/admin/version 返回
+func WithBuildInfoOption(info BuildInfo) Option {
+	return func(o *Options) {
+		o.BuildInfo = info
+	}
+}
+
 // ParseCommandLine ...
 func ParseCommandLine() (options Options, err error) {
 	flag.IntVar(&options.Port, "port", 0, "Port to listen on")               // 0-使用随机端口
@@ -130,12 +239,20 @@ func ParseCommandLine() (options Options, err error) {
 
 	// flag.StringVar(&options.Interface, "interface", "", "Interface to bind to")
 	flag.StringVar(&options.ApiInterface, "apiInterface", "", "Interface to for API to bind to")
+	flag.IntVar(&options.AdminPort, "adminPort", 0, "Port to provide admin api and pprof on, 0 to disable")
+	flag.StringVar(&options.AdminInterface, "adminInterface", defaultAdminInterface, "Interface for admin api to bind to")
 
 	flag.StringVar(&options.Log, "log", "", "logging to use (console, file, redis, kafka, syslog or logstash)")
 	flag.StringVar(&options.LogFormat, "logFormat", "", "log fromat to use (text, json)")
 	flag.StringVar(&options.LogLevel, "logLevel", "", "log at or above(debug, info, warn, error, fatal, panic) this level to the logging output(default >=info)")
 
-	flag.StringVar(&options.ConfEntryPath, "confEntryPath", "./conf/broccoli.json", "config entry path")
+	flag.StringVar(&options.ConfEntryPath, "confEntryPath", "./conf/broccoli.json", "config entry path, optional if the entry is given by env or flags")
+	flag.StringVar(&options.EngineType, "engineType", "", "config engine type (etcd, file, consul), overrides BROCCOLI_ENGINE_TYPE")
+	flag.StringVar(&options.ConfigPath, "configPath", "", "config path, overrides BROCCOLI_CONFIG_PATH")
+	flag.StringVar(&options.EndPoints, "endpoints", "", "comma separated config center endpoints, overrides BROCCOLI_ENDPOINTS")
+	flag.StringVar(&options.Profile, "profile", "", "config entry profile (e.g. dev, test, prod), overrides BROCCOLI_PROFILE")
+	flag.Var(&options.ConfOverrides, "set", "override config value by path, repeatable (e.g. -set redis.host=127.0.0.1:6379)")
+	flag.DurationVar(&options.ShutdownTimeout, "shutdownTimeout", defaultShutdownTimeout, "max time to wait for in-flight requests and messages on shutdown")
 	flag.BoolVar(&options.Version, "version", false, "show version")
 
 	flag.Parse()
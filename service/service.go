@@ -3,12 +3,11 @@ package service
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"os"
 	"path"
 	"runtime"
 	"strconv"
@@ -29,9 +28,12 @@ import (
 
 	"github.com/elvisNg/broccoli/config"
 	"github.com/elvisNg/broccoli/engine"
+	"github.com/elvisNg/broccoli/engine/consul"
 	"github.com/elvisNg/broccoli/engine/etcd"
 	"github.com/elvisNg/broccoli/engine/file"
+	httpng "github.com/elvisNg/broccoli/engine/http"
 	broccolierrors "github.com/elvisNg/broccoli/errors"
+	"github.com/elvisNg/broccoli/metrics"
 	"github.com/elvisNg/broccoli/microsrv/gomicro"
 	zgomicro "github.com/elvisNg/broccoli/microsrv/gomicro"
 	"github.com/elvisNg/broccoli/plugin/zcontainer"
@@ -42,6 +44,7 @@ import (
 const (
 	retryPeriod       = 5 * time.Second
 	changesBufferSize = 10
+	closeTimeout      = 30 * time.Second // 服务停止后关闭容器组件的最长时间
 )
 
 var confEntry *config.Entry
@@ -61,8 +64,10 @@ func init() {
 	}
 
 	engineProvidors = map[string]engine.NewEngineFn{
-		"etcd": newEtcdEngine,
-		"file": newFileEngine,
+		"etcd":   newEtcdEngine,
+		"file":   newFileEngine,
+		"consul": newConsulEngine,
+		"http":   newHTTPEngine,
 	}
 }
 
@@ -70,7 +75,16 @@ func newEtcdEngine(cnt zcontainer.Container) (engine.Engine, error) {
 	return etcd.New(confEntry, cnt)
 }
 
-// newFileEngine fileengine的实现，目前并不完善，不要应用到生产，可简单用在开发测试
+func newConsulEngine(cnt zcontainer.Container) (engine.Engine, error) {
+	return consul.New(confEntry, cnt)
+}
+
+// newHTTPEngine httpengine，轮询配置服务的url获取配置
+func newHTTPEngine(cnt zcontainer.Container) (engine.Engine, error) {
+	return httpng.New(confEntry, cnt)
+}
+
+// newFileEngine fileengine的实现，基于文件系统事件监听配置文件变化
 func newFileEngine(cnt zcontainer.Container) (engine.Engine, error) {
 	return file.New(confEntry, cnt)
 }
@@ -91,18 +105,29 @@ func Run(cnt zcontainer.Container, conf *Options, opts ...Option) (err error) {
 	}
 	if err = s.RunServer(); err != nil {
 		log.Printf("[broccoli] [service.Run] err: %s\n", err)
-		s.watcherCancelC <- struct{}{} // 服务启动失败，通知停止engine的监听
-		return
 	}
 	return
 }
 
 func (s *Service) Init() (err error) {
-	s.initConfEntry()
+	if err = s.initOverlay(); err != nil {
+		log.Printf("[broccoli] [service.Run] err: %s\n", err)
+		return
+	}
 
-	if fn, ok := engineProvidors[confEntry.EngineType]; ok && fn != nil {
+	if s.options.Engine != nil {
+		// 使用外部传入的engine，如测试中的memory engine，不需要配置入口
+		s.ng = s.options.Engine
+	} else if err = s.initConfEntry(); err != nil {
+		log.Printf("[broccoli] [service.Run] err: %s\n", err)
+		return
+	} else if err = initSecretProvider(); err != nil {
+		log.Printf("[broccoli] [service.Run] err: %s\n", err)
+		return
+	} else if fn, ok := engineProvidors[confEntry.EngineType]; ok && fn != nil {
 		if s.ng, err = fn(s.container); err != nil {
 			log.Printf("[broccoli] [service.Run] err: %s\n", err)
+			return
 		}
 	} else {
 		err = fmt.Errorf("no newEnginFn providor for engineType: %s", confEntry.EngineType)
@@ -115,10 +140,18 @@ func (s *Service) Init() (err error) {
 		log.Printf("[broccoli] [service.Run] err: %s\n", err)
 		return
 	}
+	if err := metrics.RegisterPoolStats(s.container.PoolStats); err != nil {
+		log.Printf("[broccoli] [service.Run] register pool stats err: %s\n", err)
+	}
 
 	if err = s.initServer(); err != nil {
 		log.Printf("[broccoli] [service.Run] err: %s\n", err)
-		s.watcherCancelC <- struct{}{} // 服务启动失败，通知停止engine的监听
+		// 服务启动失败，通知停止engine的监听
+		ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		defer cancel()
+		if werr := s.stopWatcher(ctx); werr != nil {
+			log.Printf("[broccoli] [service.Run] stop watcher err: %s\n", werr)
+		}
 		return
 	}
 
@@ -137,8 +170,16 @@ type Service struct {
 	container      zcontainer.Container
 	ng             engine.Engine
 	watcherCancelC chan struct{}
+	watcherDone    chan struct{} // engine 的监听退出时关闭
 	watcherErrorC  chan struct{}
 	watcherWg      sync.WaitGroup
+	events         *engine.EventBus
+	eventsC        chan []engine.Event // 配置变化的事件，由 publishEvents 按顺序发布
+	appliedConf    *config.AppConf     // 最近一次应用到容器的配置
+	httpServer     *http.Server        // AfterStart 中启动的http apiserver
+	adminServer    *http.Server        // 管理接口，AdminPort 为0时为nil
+	stopping       int32               // 开始停止后为1，/readyz 返回503
+	httpHandler    http.Handler        // HttpHandlerRegisterFn 返回的handler，用于管理接口列出路由
 }
 
 func NewService(options Options, container zcontainer.Container, opts ...Option) *Service {
@@ -154,6 +195,14 @@ func NewService(options Options, container zcontainer.Container, opts ...Option)
 		container:      container,
 		watcherErrorC:  make(chan struct{}),
 		watcherCancelC: make(chan struct{}),
+		watcherDone:    make(chan struct{}),
+		events:         engine.NewEventBus(),
+	}
+	// 指标wrap在用户wrap之前，记录处理函数返回的原始错误
+	o.GoMicroServerWrapGenerateFn = append([]GoMicroServerWrapGenerateFn{zgomicro.GenerateServerMetricsWrap}, o.GoMicroServerWrapGenerateFn...)
+	o.GoMicroClientWrapGenerateFn = append([]GoMicroClientWrapGenerateFn{zgomicro.GenerateClientMetricsWrap}, o.GoMicroClientWrapGenerateFn...)
+	for _, h := range o.ConfigEventHandlers {
+		s.events.Subscribe(h.Section, h.Fn)
 	}
 	if !utils.IsEmptyString(options.ConfEntryPath) {
 		confEntryPath = options.ConfEntryPath
@@ -162,19 +211,75 @@ func NewService(options Options, container zcontainer.Container, opts ...Option)
 	return s
 }
 
-func (s *Service) initConfEntry() {
-	b, err := ioutil.ReadFile(confEntryPath)
-	if err != nil {
-		panic(err)
+// Events 配置变化事件总线，可按分区订阅
+func (s *Service) Events() *engine.EventBus {
+	return s.events
+}
+
+// initConfEntry 初始化配置入口，配置入口文件可选，可通过环境变量和命令行参数提供
+func (s *Service) initConfEntry() (err error) {
+	overrides := make(map[string]string)
+	for k, v := range map[string]string{
+		"engine_type": s.options.EngineType,
+		"config_path": s.options.ConfigPath,
+		"endpoints":   s.options.EndPoints,
+		"profile":     s.options.Profile,
+	} {
+		if !utils.IsEmptyString(v) {
+			overrides[k] = v
+		}
+	}
+	if confEntry, err = config.LoadEntry(confEntryPath, os.Environ(), overrides); err != nil {
+		return
+	}
+	log.Printf("[broccoli] [service.initConfEntry] confEntry: %s", confEntry)
+	return
+}
+
+// initOverlay 初始化配置覆盖层，engine每次加载配置都会应用
+// 优先级：engine配置 < 环境变量 < 命令行参数
+func (s *Service) initOverlay() (err error) {
+	o := config.NewOverlay()
+	o.LoadEnv(os.Environ())
+	for _, kv := range [][2]string{
+		{"log_conf.log", s.options.Log},
+		{"log_conf.format", s.options.LogFormat},
+		{"log_conf.level", s.options.LogLevel},
+	} {
+		if utils.IsEmptyString(kv[1]) {
+			continue
+		}
+		if err = o.Set(config.SourceFlag, kv[0], kv[1]); err != nil {
+			return
+		}
 	}
-	confEntry = &config.Entry{}
-	if err := json.Unmarshal(b, confEntry); err != nil {
-		panic(err)
+	for _, pair := range s.options.ConfOverrides {
+		if err = o.SetPair(config.SourceFlag, pair); err != nil {
+			return
+		}
 	}
-	log.Printf("[broccoli] [service.initConfEntry] confEntry: %+v", confEntry)
+	config.SetOverlay(o)
+	log.Printf("[broccoli] [service.initOverlay] %d config overrides loaded\n", o.Len())
 	return
 }
 
+// initSecretProvider 根据配置入口初始化 ENC(...) 配置值的解密器
+// 已通过 config.SetSecretProvider 设置时不覆盖
+func initSecretProvider() error {
+	if config.GetSecretProvider() != nil {
+		return nil
+	}
+	p, err := config.NewSecretProvider(confEntry)
+	if err != nil {
+		return err
+	}
+	if p != nil {
+		config.SetSecretProvider(p)
+		log.Println("[broccoli] [service.initSecretProvider] secret provider loaded")
+	}
+	return nil
+}
+
 // loadEngine 初始化engine，开启监听
 func (s *Service) loadEngine() (err error) {
 	if err = s.ng.Init(); err != nil {
@@ -188,22 +293,15 @@ func (s *Service) loadEngine() (err error) {
 	}
 	// 初始化容器组件
 	conf := *configer.Get()
-	if !utils.IsEmptyString(s.options.Log) {
-		conf.LogConf.Log = s.options.Log
-	}
-	if !utils.IsEmptyString(s.options.LogFormat) {
-		conf.LogConf.Format = s.options.LogFormat
-	}
-	if !utils.IsEmptyString(s.options.LogLevel) {
-		conf.LogConf.Level = s.options.LogLevel
-	}
 	s.container.Init(&conf)
+	s.appliedConf = configer.Get()
+	config.ApplyExtBindings(s.appliedConf)
 
 	changesC := make(chan interface{}, changesBufferSize)
 	// 监听配置变化
 	go func() {
 		defer close(changesC)
-		defer close(s.watcherCancelC)
+		defer close(s.watcherDone)
 		if err := s.ng.Subscribe(changesC, s.watcherCancelC); err != nil {
 			log.Println("[broccoli] [s.n.subscribe] err:", err)
 			s.watcherErrorC <- struct{}{}
@@ -211,12 +309,20 @@ func (s *Service) loadEngine() (err error) {
 		}
 	}()
 
+	s.eventsC = make(chan []engine.Event, changesBufferSize)
+	go s.publishEvents(s.eventsC)
+
 	// 处理事件
 	go func() {
+		defer close(s.eventsC)
 		defer close(s.watcherErrorC)
 		for {
 			select {
-			case change := <-changesC:
+			case change, ok := <-changesC:
+				if !ok {
+					// 监听已停止
+					return
+				}
 				if err := s.processChange(change); err != nil {
 					log.Printf("[broccoli] failed to processChange, change=%#v, err=%s\n", change, err)
 				}
@@ -233,21 +339,22 @@ func (s *Service) loadEngine() (err error) {
 }
 
 func (s *Service) processChange(ev interface{}) (err error) {
+	if a, ok := ev.(engine.Acker); ok {
+		// 重新加载容器组件后确认，如 memory engine 的 Set 等待确认后返回
+		defer a.Ack()
+	}
 	switch c := ev.(type) {
 	case config.Configer:
-		log.Printf("[broccoli] config change\n")
+		events := engine.DiffEvents(s.appliedConf, c.Get())
+		log.Printf("[broccoli] config change, %d section events\n", len(events))
 		// 重新加载容器组件
 		conf := *c.Get()
-		if !utils.IsEmptyString(s.options.Log) {
-			conf.LogConf.Log = s.options.Log
-		}
-		if !utils.IsEmptyString(s.options.LogFormat) {
-			conf.LogConf.Format = s.options.LogFormat
-		}
-		if !utils.IsEmptyString(s.options.LogLevel) {
-			conf.LogConf.Level = s.options.LogLevel
-		}
 		s.container.Reload(&conf)
+		s.appliedConf = c.Get()
+		config.ApplyExtBindings(s.appliedConf)
+		if len(events) > 0 {
+			s.eventsC <- events
+		}
 	default:
 		log.Printf("[broccoli] unsupported event change\n")
 	}
@@ -259,6 +366,20 @@ func (s *Service) processChange(ev interface{}) (err error) {
 	return
 }
 
+// publishEvents 按配置变化的顺序发布事件，不阻塞配置的处理，处理函数panic时继续发布后续事件
+func (s *Service) publishEvents(eventsC <-chan []engine.Event) {
+	for events := range eventsC {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("[broccoli] [service.publishEvents] event handler panic: %v\n", r)
+				}
+			}()
+			s.events.Publish(events...)
+		}()
+	}
+}
+
 type gwOption struct {
 	grpcEndpoint    string
 	swaggerJSONFile string
@@ -310,6 +431,10 @@ func (s *Service) initServer() (err error) {
 		}
 		s.container.SetHTTPHandler(gw)
 
+		srv := &http.Server{
+			Handler: gw,
+		}
+		s.httpServer = srv
 		go func() {
 			addr := fmt.Sprintf("%s:%d", s.options.ApiInterface, s.options.ApiPort)
 			// log.Printf("http apiserver listen on %s", addr)
@@ -317,9 +442,6 @@ func (s *Service) initServer() (err error) {
 			// if err := http.ListenAndServe(addr, gw); err != nil {
 			// 	log.Fatal(err)
 			// }
-			srv := &http.Server{
-				Handler: gw,
-			}
 			ln, err := net.Listen("tcp", addr)
 			if err != nil {
 				log.Fatal(err)
@@ -328,7 +450,7 @@ func (s *Service) initServer() (err error) {
 			// host, port, err := net.SplitHostPort(ln.Addr().String())
 			// log.Println(host, port)
 			log.Printf("http apiserver listen on %s\n", ln.Addr())
-			if err := srv.Serve(ln); err != nil {
+			if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
 				log.Fatal(err)
 				return
 			}
@@ -343,25 +465,6 @@ func (s *Service) initServer() (err error) {
 	return
 }
 
-func (s *Service) RunServer() (err error) {
-	gw := s.container.GetHTTPHandler()
-	go func() {
-		addr := fmt.Sprintf("%s:%d", s.options.ApiInterface, s.options.ApiPort)
-		log.Printf("http server listen on %s", addr)
-		// Start HTTP server (and proxy calls to gRPC server endpoint, serve http, serve swagger)
-		if err := http.ListenAndServe(addr, gw); err != nil {
-			log.Fatal(err)
-		}
-	}()
-	gomicroservice := s.container.GetGoMicroService()
-	// Run the service
-	if err = gomicroservice.Run(); err != nil {
-		log.Println("[broccoli] err:", err)
-		return
-	}
-	return
-}
-
 func (s *Service) newGomicroSrv(conf config.GoMicro, srvopts ...micro.Option) (gms micro.Service, err error) {
 	var gomicroservice micro.Service
 	opts := []micro.Option{
@@ -404,6 +507,10 @@ func (s *Service) newGomicroSrv(conf config.GoMicro, srvopts ...micro.Option) (g
 	opts = append(opts, srvopts...)
 	// new micro service
 	gomicroservice = zgomicro.NewService(context.Background(), conf, opts...)
+	if err = zgomicro.RegisterHealthHandler(gomicroservice.Server(), s.grpcHealth); err != nil {
+		log.Println("[broccoli] [s.newGomicroSrv] RegisterHealthHandler err:", err)
+		return
+	}
 	if s.options.GoMicroHandlerRegisterFn != nil {
 		if err = s.options.GoMicroHandlerRegisterFn(gomicroservice.Server()); err != nil {
 			log.Println("[broccoli] [s.newGomicroSrv] GoMicroHandlerRegister err:", err)
@@ -522,24 +629,22 @@ func (s *Service) newHTTPGateway(opt gwOption) (h http.Handler, err error) {
 	serveSwaggerUI("/swagger-ui/", r, opt.swaggerJSONFile)
 	log.Println("[broccoli] [s.newHTTPGateway] swaggerRegister success.")
 
+	// health handler
+	s.registerHealthHandler(r)
+
+	// metrics handler
+	r.Handle(metrics.Path, metrics.Handler()).Methods(http.MethodGet)
+
 	// http handler
 	if s.options.HttpHandlerRegisterFn != nil {
 		var handler http.Handler
-		handlerPrefix := ""
-		if conf != nil {
-			if v, ok := conf.Ext["httphandler_pathprefix"]; ok {
-				handlerPrefix = fmt.Sprint(v)
-			}
-		}
-		if utils.IsEmptyString(handlerPrefix) {
-			// handlerPrefix = "/broccoli/"
-			handlerPrefix = "/api/"
-		}
+		handlerPrefix := conf.ExtString("httphandler_pathprefix", "/api/")
 		if handler, err = s.options.HttpHandlerRegisterFn(context.Background(), handlerPrefix, s.ng); err != nil {
 			log.Println("[broccoli] [s.newHTTPGateway] HttpHandlerRegister err:", err)
 			return
 		}
 		if handler != nil {
+			s.httpHandler = handler
 			r.PathPrefix(handlerPrefix).Handler(handler)
 			log.Println("[broccoli] [s.newHTTPGateway] HttpHandlerRegister success.")
 		}
@@ -548,26 +653,21 @@ func (s *Service) newHTTPGateway(opt gwOption) (h http.Handler, err error) {
 	// gateway handler
 	if s.options.HttpGWHandlerRegisterFn != nil {
 		var gwmux *gruntime.ServeMux
-		if gwmux, err = s.options.HttpGWHandlerRegisterFn(context.Background(), opt.grpcEndpoint, nil); err != nil {
+		if gwmux, err = s.options.HttpGWHandlerRegisterFn(context.Background(), opt.grpcEndpoint, gwDialOptions()); err != nil {
 			log.Println("[broccoli] [s.newHTTPGateway] HttpGWHandlerRegister err:", err)
 			return
 		}
 		gruntime.HTTPError = grpcGatewayHTTPError // 覆盖默认的错误处理函数
 		if gwmux != nil {
-			gwPrefix := ""
-			if conf != nil {
-				if v, ok := conf.Ext["grpcgateway_pathprefix"]; ok {
-					gwPrefix = fmt.Sprint(v)
-				}
-			}
-			if utils.IsEmptyString(gwPrefix) {
-				gwPrefix = "/"
-			}
+			gwPrefix := conf.ExtString("grpcgateway_pathprefix", "/")
 			r.PathPrefix(gwPrefix).HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-				rr := r.WithContext(r.Context())
+				var method string // 转发的grpc方法，由 gwDialOptions 的拦截器设置
+				rr := r.WithContext(context.WithValue(r.Context(), gwMethodKey{}, &method))
 				rr.URL.Path = strings.Replace(r.URL.Path, gwPrefix, "/", 1)
 				bwriter := &gwBodyWriter{body: bytes.NewBufferString(""), ResponseWriter: rw}
+				start := time.Now()
 				gwmux.ServeHTTP(bwriter, rr)
+				observeGateway(rr.Method, method, bwriter, time.Since(start))
 			})
 			log.Println("[broccoli] [s.newHTTPGateway] HttpGWHandlerRegister success.")
 		}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/elvisNg/broccoli/lifecycle"
+	zsub "github.com/elvisNg/broccoli/pubsub/sub"
+)
+
+// defaultShutdownTimeout 未设置 Options.ShutdownTimeout 时等待进行中的请求完成的最长时间
+const defaultShutdownTimeout = 30 * time.Second
+
+// gomicroRunner micro.Service 的启动和停止，不使用 Run 避免go-micro安装自己的信号处理
+type gomicroRunner interface {
+	Start() error
+	Stop() error
+}
+
+// deregisterer go-micro的server实现提供的从注册中心注销
+type deregisterer interface {
+	Deregister() error
+}
+
+// RunServer 启动服务，收到 SIGTERM/SIGINT 或 lifecycle.Shutdown 后按顺序停止
+// 启动失败时停止engine的监听并释放容器组件，停止过程的错误由 shutdown 返回
+func (s *Service) RunServer() (err error) {
+	gms, err := s.startServer()
+	if err != nil {
+		s.abort()
+		return
+	}
+	<-lifecycle.Done()
+	return s.shutdown(gms)
+}
+
+// startServer 启动管理接口和go-micro服务
+func (s *Service) startServer() (gms gomicroRunner, err error) {
+	gms, ok := s.container.GetGoMicroService().(gomicroRunner)
+	if !ok {
+		return nil, errors.New("gomicro service can not be started")
+	}
+	if err = s.startAdminServer(); err != nil {
+		log.Println("[broccoli] [s.startAdminServer] err:", err)
+		return
+	}
+	if err = gms.Start(); err != nil {
+		log.Println("[broccoli] err:", err)
+		s.stopAdminServer(context.Background())
+		return
+	}
+	return
+}
+
+// abort 服务启动失败，停止engine的监听并释放容器组件，正常停止时由 shutdown 释放
+func (s *Service) abort() {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	if err := s.stopWatcher(ctx); err != nil {
+		log.Printf("[broccoli] [service.abort] stop watcher err: %s\n", err)
+	}
+	if err := s.container.Close(ctx); err != nil {
+		log.Printf("[broccoli] [service.abort] close container err: %s\n", err)
+	}
+}
+
+// shutdown 依次从注册中心注销，停止接收新请求并等待http、grpc进行中的请求完成，
+// 等待订阅处理中的消息完成后停止订阅，停止配置监听，最后关闭容器组件
+func (s *Service) shutdown(gms gomicroRunner) error {
+	log.Println("[broccoli] [service.shutdown] start")
+	atomic.StoreInt32(&s.stopping, 1)
+	timeout := s.options.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	var m lifecycle.Manager
+	m.Add("deregister", timeout, func(ctx context.Context) error {
+		// 先于停止grpc注销，客户端不再选择本节点，grpc停止时go-micro会再次注销
+		if d, ok := s.container.GetGoMicroService().Server().(deregisterer); ok {
+			return d.Deregister()
+		}
+		return nil
+	})
+	m.Add("http", timeout, func(ctx context.Context) error {
+		if s.httpServer == nil {
+			return nil
+		}
+		return s.httpServer.Shutdown(ctx)
+	})
+	// go-micro的grpc server停止时等待进行中的请求完成
+	m.Add("grpc", timeout, func(ctx context.Context) error {
+		return gms.Stop()
+	})
+	m.Add("subscriber", timeout, func(ctx context.Context) (err error) {
+		closeSub := func(name string, sub zsub.Subscriber) {
+			if e := sub.Close(ctx); e != nil {
+				log.Printf("[broccoli] [service.shutdown] close subscriber %s err: %s\n", name, e)
+				err = e
+			}
+		}
+		if sub := s.container.GetSubscriber(); sub != nil {
+			closeSub("default", sub)
+		}
+		for name, sub := range s.container.GetSubscriberSource() {
+			closeSub(name, sub)
+		}
+		return
+	})
+	for _, h := range s.options.ShutdownHooks {
+		m.Add(h.Name, h.Timeout, h.Fn)
+	}
+	m.Add("config_watcher", timeout, func(ctx context.Context) error {
+		return s.stopWatcher(ctx)
+	})
+	// 管理接口最后停止，停止过程中仍可查看pprof等
+	m.Add("admin", timeout, s.stopAdminServer)
+	m.Add("container", closeTimeout, s.container.Close)
+	err := m.Stop()
+	log.Println("[broccoli] [service.shutdown] finish")
+	return err
+}
+
+// stopWatcher 通知engine停止监听，监听已退出时直接返回
+func (s *Service) stopWatcher(ctx context.Context) error {
+	select {
+	case s.watcherCancelC <- struct{}{}:
+	case <-s.watcherDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	clientv3 "github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/elvisNg/broccoli/config"
+	"github.com/elvisNg/broccoli/engine/etcd"
+	"github.com/elvisNg/broccoli/utils"
+)
+
+const usage = `broccoli-conf 管理etcd中的服务配置，使用与服务相同的配置入口
+
+Usage:
+  broccoli-conf [flags] <command> [args]
+
+Commands:
+  get                      输出服务配置，多个key时输出合并后的配置
+  put <file>               校验本地配置文件后写入 config_path
+  diff <file>              比较etcd中的配置与本地配置文件
+  watch                    监听配置变化
+  history [-n 10] [-rev N] 列出 config_path 的历史版本，-rev 输出指定版本的内容
+  template [-format json]  输出配置模板，支持json/yaml
+
+Flags:
+`
+
+var (
+	entryPath  = flag.String("confEntryPath", "./conf/broccoli.json", "config entry path, same as the service")
+	configPath = flag.String("configPath", "", "config path, overrides the entry")
+	endpoints  = flag.String("endpoints", "", "comma separated etcd endpoints, overrides the entry")
+	profile    = flag.String("profile", "", "config entry profile")
+	timeout    = flag.Duration("timeout", 10*time.Second, "etcd request timeout")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+
+	var err error
+	switch cmd {
+	case "template":
+		err = runTemplate(args)
+	case "get", "put", "diff", "watch", "history":
+		err = runEtcd(cmd, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func loadEntry() (*config.Entry, error) {
+	overrides := make(map[string]string)
+	for k, v := range map[string]string{
+		"config_path": *configPath,
+		"endpoints":   *endpoints,
+		"profile":     *profile,
+	} {
+		if !utils.IsEmptyString(v) {
+			overrides[k] = v
+		}
+	}
+	entry, err := config.LoadEntry(*entryPath, os.Environ(), overrides)
+	if err != nil {
+		return nil, err
+	}
+	if entry.EngineType != "etcd" {
+		return nil, fmt.Errorf("engine_type %q is not supported, only etcd", entry.EngineType)
+	}
+	return entry, nil
+}
+
+func runEtcd(cmd string, args []string) error {
+	log.SetOutput(ioutil.Discard) // 只输出命令结果
+	entry, err := loadEntry()
+	if err != nil {
+		return err
+	}
+	cli, err := etcd.NewClient(entry)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+	c := &command{entry: entry, cli: cli}
+	switch cmd {
+	case "get":
+		return c.get()
+	case "put":
+		if len(args) != 1 {
+			return errors.New("usage: put <file>")
+		}
+		return c.put(args[0])
+	case "diff":
+		if len(args) != 1 {
+			return errors.New("usage: diff <file>")
+		}
+		return c.diff(args[0])
+	case "watch":
+		return c.watch()
+	case "history":
+		return c.history(args)
+	}
+	return nil
+}
+
+type command struct {
+	entry *config.Entry
+	cli   *clientv3.Client
+}
+
+func (c *command) ctx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), *timeout)
+}
+
+// tree 读取 config_path 配置树的所有key
+func (c *command) tree() (map[string][]byte, error) {
+	ctx, cancel := c.ctx()
+	defer cancel()
+	resp, err := c.cli.Get(ctx, c.entry.ConfigPath, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	kvs := make(map[string][]byte)
+	for _, kv := range resp.Kvs {
+		if config.InTree(c.entry.ConfigPath, string(kv.Key)) {
+			kvs[string(kv.Key)] = kv.Value
+		}
+	}
+	return kvs, nil
+}
+
+// remote 读取 config_path 配置树，只有一个key时返回原始内容，否则返回合并后的json
+func (c *command) remote() (content []byte, format string, err error) {
+	kvs, err := c.tree()
+	if err != nil {
+		return
+	}
+	if len(kvs) == 0 {
+		return nil, "", fmt.Errorf("%s not found", c.entry.ConfigPath)
+	}
+	if v, ok := kvs[c.entry.ConfigPath]; ok && len(kvs) == 1 {
+		format = c.entry.ConfigFormat
+		if utils.IsEmptyString(format) {
+			format = config.DetectFormat(c.entry.ConfigPath, v)
+		}
+		return v, format, nil
+	}
+	merged, err := config.MergeTree(nil, c.entry.ConfigPath, kvs, c.entry.ConfigFormat)
+	if err != nil {
+		return
+	}
+	content, err = utils.MarshalIndent(merged, "", "  ")
+	return content, config.FormatJSON, err
+}
+
+func (c *command) get() error {
+	content, _, err := c.remote()
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(content))
+	return nil
+}
+
+// put 写入前按服务加载配置的流程校验，配置中的 ENC(...) 值需要配置入口中的密钥
+// 文件格式须与服务解析 config_path 使用的格式一致，config_path 为多key配置树时拒绝写入
+func (c *command) put(file string) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	p, err := config.NewSecretProvider(c.entry)
+	if err != nil {
+		return err
+	}
+	config.SetSecretProvider(p)
+	entry := *c.entry
+	if utils.IsEmptyString(entry.ConfigFormat) {
+		// 与服务加载时一致，按 config_path 和内容识别
+		entry.ConfigFormat = config.DetectFormat(entry.ConfigPath, content)
+	}
+	if format := config.DetectFormat(file, content); format != entry.ConfigFormat {
+		return fmt.Errorf("%s is %s, but %s is loaded as %s", file, format, entry.ConfigPath, entry.ConfigFormat)
+	}
+	if _, err = config.Load(&entry, content, nil); err != nil {
+		return fmt.Errorf("invalid config %s: %s", file, err)
+	}
+	kvs, err := c.tree()
+	if err != nil {
+		return err
+	}
+	if len(kvs) > 1 {
+		return fmt.Errorf("%s is a config tree of %d keys, put only writes the root key", c.entry.ConfigPath, len(kvs))
+	}
+	ctx, cancel := c.ctx()
+	defer cancel()
+	resp, err := c.cli.Put(ctx, c.entry.ConfigPath, string(content))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("put %s at revision %d\n", c.entry.ConfigPath, resp.Header.Revision)
+	return nil
+}
+
+// diff 比较原始文档，不解密，输出中隐藏敏感字段
+func (c *command) diff(file string) error {
+	local, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	localMap, err := config.DecodeMap(config.DetectFormat(file, local), local)
+	if err != nil {
+		return fmt.Errorf("decode %s failed: %s", file, err)
+	}
+	remote, format, err := c.remote()
+	if err != nil {
+		return err
+	}
+	remoteMap, err := config.DecodeMap(format, remote)
+	if err != nil {
+		return fmt.Errorf("decode %s failed: %s", c.entry.ConfigPath, err)
+	}
+	changes := config.DiffMap(remoteMap, localMap)
+	if len(changes) == 0 {
+		fmt.Println("no difference")
+		return nil
+	}
+	fmt.Printf("--- %s\n+++ %s\n", c.entry.ConfigPath, file)
+	for _, ch := range changes {
+		ch = config.RedactChange(ch)
+		switch {
+		case ch.Old == nil:
+			fmt.Printf("+ %s: %v\n", ch.Path, ch.New)
+		case ch.New == nil:
+			fmt.Printf("- %s: %v\n", ch.Path, ch.Old)
+		default:
+			fmt.Printf("~ %s: %v -> %v\n", ch.Path, ch.Old, ch.New)
+		}
+	}
+	return nil
+}
+
+func (c *command) watch() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+	fmt.Printf("watching %s ...\n", c.entry.ConfigPath)
+	for wresp := range c.cli.Watch(ctx, c.entry.ConfigPath, clientv3.WithPrefix()) {
+		if err := wresp.Err(); err != nil {
+			return err
+		}
+		for _, ev := range wresp.Events {
+			if !config.InTree(c.entry.ConfigPath, string(ev.Kv.Key)) {
+				continue
+			}
+			fmt.Printf("%s %s %s revision=%d\n", time.Now().Format(time.RFC3339), ev.Type, ev.Kv.Key, ev.Kv.ModRevision)
+			if ev.Type == clientv3.EventTypePut {
+				fmt.Println(config.Redact(ev.Kv.Value))
+			}
+		}
+	}
+	return nil
+}
+
+// history 通过逐个读取历史revision列出 config_path 的版本，已被压缩的版本无法读取
+func (c *command) history(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	n := fs.Int("n", 10, "max versions to list")
+	rev := fs.Int64("rev", 0, "print the content at this revision")
+	fs.Parse(args)
+
+	if *rev > 0 {
+		ctx, cancel := c.ctx()
+		defer cancel()
+		resp, err := c.cli.Get(ctx, c.entry.ConfigPath, clientv3.WithRev(*rev))
+		if err != nil {
+			return err
+		}
+		if len(resp.Kvs) == 0 {
+			return fmt.Errorf("%s not found at revision %d", c.entry.ConfigPath, *rev)
+		}
+		fmt.Println(config.Redact(resp.Kvs[0].Value))
+		return nil
+	}
+
+	fmt.Printf("%-10s %-8s %-8s %s\n", "REVISION", "VERSION", "SIZE", "SHA256")
+	var opts []clientv3.OpOption
+	for i := 0; i < *n; i++ {
+		ctx, cancel := c.ctx()
+		resp, err := c.cli.Get(ctx, c.entry.ConfigPath, opts...)
+		cancel()
+		if err == rpctypes.ErrCompacted {
+			fmt.Println("older revisions have been compacted")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(resp.Kvs) == 0 {
+			return nil
+		}
+		kv := resp.Kvs[0]
+		sum := sha256.Sum256(kv.Value)
+		fmt.Printf("%-10d %-8d %-8d %s\n", kv.ModRevision, kv.Version, len(kv.Value), hex.EncodeToString(sum[:])[:12])
+		if kv.Version <= 1 {
+			return nil
+		}
+		opts = []clientv3.OpOption{clientv3.WithRev(kv.ModRevision - 1)}
+	}
+	return nil
+}
+
+// runTemplate 输出包含所有字段的配置模板
+func runTemplate(args []string) error {
+	fs := flag.NewFlagSet("template", flag.ExitOnError)
+	format := fs.String("format", config.FormatJSON, "json or yaml")
+	fs.Parse(args)
+
+	conf := config.AppConf{
+		LogConf:       config.LogConf{Log: "console", Level: "info", Format: "json", RotationTime: "day"},
+		RedisSource:   map[string]config.Redis{},
+		MongoDBSource: map[string]config.MongoDB{},
+		BrokerSource:  map[string]config.Broker{},
+		Ext:           map[string]interface{}{},
+		Trace:         config.Trace{Sampler: "boundary", Rate: 1},
+		GoMicro:       config.GoMicro{RegistryPluginType: "etcd", RegistryAddrs: []string{"127.0.0.1:2379"}},
+	}
+	b, err := utils.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case config.FormatJSON:
+	case config.FormatYAML:
+		var m yaml.MapSlice
+		if err = yaml.Unmarshal(b, &m); err != nil {
+			return err
+		}
+		if b, err = yaml.Marshal(m); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported template format: %s", *format)
+	}
+	fmt.Println(string(b))
+	return nil
+}
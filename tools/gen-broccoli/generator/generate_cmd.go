@@ -79,7 +79,9 @@ func main() {
 	curr := runtime.GOMAXPROCS(num)
 	log.Printf("[CURRENT GOMAXPROCS] %v\n", curr)
 	log.Println("service run ...")
-	if err := service.Run(container.GetContainer(), nil, global.ServiceOpts...); err != nil {
+	buildInfo := service.BuildInfo{Version: Version, BuildDate: BuildDate, GoVersion: GoVersion}
+	opts := append(global.ServiceOpts, service.WithBuildInfoOption(buildInfo))
+	if err := service.Run(container.GetContainer(), nil, opts...); err != nil {
 		log.Printf("Service exited with error: %s\n", err)
 		os.Exit(255)
 	} else {
@@ -11,14 +11,22 @@ func GenerateReadme(PD *Generator, rootdir string) (err error) {
 	// 默认路径 ./conf/broccoli.json
 	{
 		"engine_type": "etcd",
-		"config_path": "/broccoli/{PKG}", // 服务应用的配置路径
+		"config_path": "/broccoli/{PKG}", // 服务应用的配置路径，子key（如 /broccoli/{PKG}/redis）会合并到对应字段
+		"common_path": "/broccoli/common", // 可选，多个服务共享的配置路径，优先级低于 config_path
 		"config_format": "json",     // 配置格式
 		"endpoints": ["127.0.0.1:2379"],
 		"username": "root",
-		"password": "123456"
+		"password": "123456",
+		"secret_key_env": "BROCCOLI_SECRET_KEY", // 可选，配置中 ENC(...) 形式的值使用该密钥解密，也可用 secret_key_file 指定密钥文件
+		"profiles": { // 可选，通过 -profile 或 BROCCOLI_PROFILE 选择，非空字段覆盖上面的配置
+			"prod": {"config_path": "/broccoli/prod/{PKG}", "endpoints": ["etcd-prod:2379"]}
+		}
 	}
 {QUOTE}
 
+配置入口文件可选，也可以通过环境变量（BROCCOLI_ENGINE_TYPE、BROCCOLI_CONFIG_PATH、BROCCOLI_ENDPOINTS 等，即 BROCCOLI_ 加大写的字段名）
+或命令行参数（-engineType、-configPath、-endpoints、-profile）提供，优先级：命令行参数 > 环境变量 > profile > 配置入口文件
+
 ## 应用服务配置
 {QUOTE}json
 	// 路径 /broccoli/{PKG}
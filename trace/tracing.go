@@ -2,6 +2,7 @@ package tracing
 
 import (
 	"context"
+	"io"
 
 	"github.com/micro/go-micro/metadata"
 	"github.com/opentracing/opentracing-go"
@@ -11,12 +12,35 @@ import (
 )
 
 type TracerWrap struct {
-	tracer opentracing.Tracer
+	tracer  opentracing.Tracer
+	closers []io.Closer
 }
 
-func NewTracerWrap(tracer opentracing.Tracer) *TracerWrap {
+// NewTracerWrap closers 在 Close 时关闭，如zipkin的collector
+func NewTracerWrap(tracer opentracing.Tracer, closers ...io.Closer) *TracerWrap {
 	return &TracerWrap{
-		tracer: tracer,
+		tracer:  tracer,
+		closers: closers,
+	}
+}
+
+// Close 关闭collector，发送缓存中剩余的span，ctx 结束时不再等待
+func (t *TracerWrap) Close(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		for _, c := range t.closers {
+			if e := c.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
@@ -2,15 +2,30 @@ package zipkin
 
 import (
 	"github.com/elvisNg/broccoli/config"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"sync"
 	//"github.com/micro/go-micro/metadata"
 	"github.com/opentracing/opentracing-go"
 	zipkin "github.com/openzipkin/zipkin-go-opentracing"
 	"github.com/elvisNg/broccoli/utils"
 )
 
+// InitTracer 创建tracer并设置为全局tracer
 func InitTracer(cfg *config.Trace) error {
+	tracer, _, err := NewTracer(cfg)
+	if err != nil {
+		return err
+	}
+	opentracing.SetGlobalTracer(tracer)
+	return nil
+}
+
+// NewTracer 创建tracer，返回的collector关闭时发送缓存中剩余的span
+func NewTracer(cfg *config.Trace) (opentracing.Tracer, io.Closer, error) {
 	zipkinURL := cfg.TraceUrl
 	hostPort, _ := os.Hostname()
 	serviceName := cfg.ServiceName
@@ -20,27 +35,14 @@ func InitTracer(cfg *config.Trace) error {
 	collector, err := zipkin.NewHTTPCollector(zipkinURL)
 	if err != nil {
 		log.Printf("unable to create Zipkin HTTP collector: %v", err)
-		return err
-	}
-	// 为0默认完全开启采样
-	// 为负值则关闭采样
-	// 大于1则完全开启采样
-	if rate == 0 {
-		rate = 1.0
+		return nil, nil, err
 	}
 	if utils.IsEmptyString(sampler) {
 		sampler = "boundary"
 	}
 	log.Printf("final sampler: %s, rate: %f, mod: %d", sampler, rate, mod)
-	samplerOpt := zipkin.WithSampler(zipkin.NewBoundarySampler(rate, 0))
-	switch sampler {
-	case "boundary":
-		samplerOpt = zipkin.WithSampler(zipkin.NewBoundarySampler(rate, 0))
-	case "counting":
-		samplerOpt = zipkin.WithSampler(zipkin.NewCountingSampler(rate))
-	case "mod":
-		samplerOpt = zipkin.WithSampler(zipkin.ModuloSampler(mod))
-	}
+	setSampling(sampler, rate, mod)
+	samplerOpt := zipkin.WithSampler(sample)
 	tracer, err := zipkin.NewTracer(
 		zipkin.NewRecorder(collector, false, hostPort, serviceName),
 		//zipkin.ClientServerSameSpan(false),
@@ -49,9 +51,68 @@ func InitTracer(cfg *config.Trace) error {
 	)
 	if err != nil {
 		log.Printf("unable to create Zipkin tracer: %v", err)
-		return err
+		collector.Close()
+		return nil, nil, err
 	}
-	log.Printf("reload tracer")
-	opentracing.SetGlobalTracer(tracer)
+	return tracer, collector, nil
+}
+
+// sampling 当前tracer的采样器，可通过 SetSampleRate 在运行时修改，重新加载tracer时使用配置的值
+var sampling struct {
+	sync.RWMutex
+	kind    string
+	rate    float64
+	mod     uint64
+	sampler zipkin.Sampler
+}
+
+func sample(id uint64) bool {
+	sampling.RLock()
+	defer sampling.RUnlock()
+	return sampling.sampler(id)
+}
+
+func setSampling(kind string, rate float64, mod uint64) {
+	// 为0默认完全开启采样
+	// 为负值则关闭采样
+	// 大于1则完全开启采样
+	r := rate
+	if r == 0 {
+		r = 1.0
+	}
+	var sampler zipkin.Sampler
+	switch kind {
+	case "counting":
+		sampler = zipkin.NewCountingSampler(r)
+	case "mod":
+		sampler = zipkin.ModuloSampler(mod)
+	default:
+		sampler = zipkin.NewBoundarySampler(r, 0)
+	}
+	sampling.Lock()
+	sampling.kind, sampling.rate, sampling.mod, sampling.sampler = kind, rate, mod, sampler
+	sampling.Unlock()
+}
+
+// SetSampleRate 运行时修改采样率，取值与配置 trace.rate 相同，mod 采样器不使用采样率
+func SetSampleRate(rate float64) error {
+	sampling.RLock()
+	kind, mod, ok := sampling.kind, sampling.mod, sampling.sampler != nil
+	sampling.RUnlock()
+	if !ok {
+		return errors.New("tracer not initialized")
+	}
+	if kind == "mod" {
+		return fmt.Errorf("sampler %s does not use rate", kind)
+	}
+	setSampling(kind, rate, mod)
+	log.Printf("[zipkin.SetSampleRate] sampler: %s, rate: %f", kind, rate)
 	return nil
 }
+
+// Sampling 当前的采样器类型、采样率和mod
+func Sampling() (kind string, rate float64, mod uint64) {
+	sampling.RLock()
+	defer sampling.RUnlock()
+	return sampling.kind, sampling.rate, sampling.mod
+}